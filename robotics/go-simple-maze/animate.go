@@ -0,0 +1,106 @@
+package main
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"os"
+	"time"
+)
+
+// Animated playback of maze generation, built on the events in events.go.
+//
+// In the terminal, the maze is redrawn in place after every wall (and after
+// the entrance, the exit and any repairs), with a pause between frames.  The
+// same frames can also be collected into an animated GIF.
+
+// The colors of a GIF frame, indexed by the values that mazeImage() uses.
+var imagePalette = color.Palette{
+	color.RGBA{255, 255, 255, 255}, // Floor
+	color.RGBA{32, 32, 32, 255},    // Walls
+	color.RGBA{150, 150, 150, 255}, // Fill
+	color.RGBA{0, 175, 0, 255},     // Entrance
+	color.RGBA{205, 0, 0, 255},     // Exit
+	color.RGBA{0, 95, 255, 255},    // Solution
+}
+
+// Draws the maze as an image, with each cell as a square of the given size.
+// The cells in solution (indexed by cell offset; see solutionCells()) are
+// highlighted, unless it's nil.
+func (m *Maze) mazeImage(scale int, solution []bool) *image.Paletted {
+	img := image.NewPaletted(image.Rect(0, 0, m.width * scale, m.height * scale), imagePalette)
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			var index uint8
+			switch c := m.cell(m.offset(x, y)); {
+			case c == m.fill:
+				index = 2
+			case c != m.floor:
+				index = 1
+			case inAnyRect(x, y, m.entrances):
+				index = 3
+			case inAnyRect(x, y, m.exits):
+				index = 4
+			case solution != nil && solution[m.offset(x, y)]:
+				index = 5
+			default:
+				continue
+			}
+			for py := y * scale; py < (y + 1) * scale; py++ {
+				for px := x * scale; px < (x + 1) * scale; px++ {
+					img.SetColorIndex(px, py, index)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// Draws the frames of an animation.  Use handle() as the maze's event
+// handler.
+type animator struct {
+	delay time.Duration
+
+	// Where to draw the frames in the terminal, or nil not to.
+	terminal io.Writer
+	colorMode ColorMode
+
+	// The GIF being recorded, or nil not to record one.
+	gif *gif.GIF
+	scale int
+}
+
+func (a *animator) handle(e Event) {
+	if a.terminal != nil {
+		fmt.Fprint(a.terminal, "\x1b[H")
+		e.Maze.WriteColor(a.terminal, ColorOptions{Mode: a.colorMode})
+		fmt.Fprintf(a.terminal, "\x1b[K%v (thickness %v): %v walls\n", e.Kind, e.Thickness, e.Walls)
+		time.Sleep(a.delay)
+	}
+	if a.gif != nil {
+		a.gif.Image = append(a.gif.Image, e.Maze.mazeImage(a.scale, nil))
+
+		// GIF delays are in hundredths of a second, and most viewers
+		// treat anything under 2 as "as fast as possible", if not as
+		// 10.
+		a.gif.Delay = append(a.gif.Delay, max(2, int(a.delay / (10 * time.Millisecond))))
+	}
+}
+
+// Adds a final frame showing the finished maze, held for a few seconds, and
+// writes the GIF to the given file.
+func (a *animator) writeGIF(m *Maze, path string) error {
+	a.gif.Image = append(a.gif.Image, m.mazeImage(a.scale, nil))
+	a.gif.Delay = append(a.gif.Delay, 300)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = gif.EncodeAll(f, a.gif)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
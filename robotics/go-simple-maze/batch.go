@@ -0,0 +1,374 @@
+package main
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"github.com/akamensky/argparse"
+)
+
+// Batch generation.
+//
+// RunBatch() generates many mazes at once on a pool of workers, for building
+// datasets.  Maze i is generated from the seed "<prefix>/<i>", so any one of
+// them can be reproduced later with "maze --seed", and the results don't
+// depend on how many workers there were or which of them finished first.
+// Each maze is written to its own file, and the manifest lists the seed,
+// parameters and statistics of every maze in the batch.
+
+// The parameters of a batch.
+type BatchOptions struct {
+	Count int
+	SeedPrefix string
+
+	// Where the mazes go, and in which format (see batchFormatNames().)
+	Directory string
+	Format string
+
+	// The number of mazes to generate at once.
+	Jobs int
+
+	// The generation parameters, as for GenerateNested() and
+	// GenerateWithConstraints().
+	ThicknessValues []int
+	Constraints []Constraint
+	Attempts int
+
+	// If this is greater than 0, a maze that takes longer than this to
+	// generate is abandoned.
+	Timeout time.Duration
+
+	// For the "svg" and "png" formats: the size of each cell, in pixels
+	// (see ImageOptions.)
+	Scale int
+
+	// For the "dxf" format; see WriteDXF().
+	Pitch, WallThickness float64
+}
+
+// A line of the manifest.
+type BatchEntry struct {
+	Index int                    `json:"index"`
+
+	// The file that the maze was written to, relative to the directory.
+	// This is empty if the maze could not be generated.
+	File string                  `json:"file"`
+
+	// The seed that reproduces the maze.  With constraints, this is the
+	// seed of the attempt that was kept (see GenerateWithConstraints().)
+	Seed string                  `json:"seed"`
+	Width int                    `json:"width"`
+	Height int                   `json:"height"`
+	ThicknessValues []int        `json:"thicknessValues"`
+	MinWallLength int            `json:"minWallLength"`
+	MaxWallLength int            `json:"maxWallLength"`
+	Attempts int                 `json:"attempts,omitempty"`
+	Stats *Stats                 `json:"stats,omitempty"`
+
+	// Why the maze could not be generated, or why it falls short (an
+	// incomplete maze, or one that doesn't meet the constraints.)
+	Error string                 `json:"error,omitempty"`
+}
+
+func batchFormatNames() []string {
+	return []string{"text", "json", "svg", "png", "dxf"}
+}
+
+func manifestFormatNames() []string {
+	return []string{"json", "csv"}
+}
+
+// Returns the name of the file for the given maze.  The indices are padded
+// so that the files sort in order.
+func (o BatchOptions) fileName(index int) string {
+	extension := o.Format
+	if o.Format == "text" {
+		extension = "txt"
+	}
+	digits := len(strconv.Itoa(max(0, o.Count - 1)))
+	return fmt.Sprintf("maze-%0*d.%v", digits, index, extension)
+}
+
+// Generates the given maze of the batch and writes it to its file.
+func (o BatchOptions) generate(ctx context.Context, template Maze, index int) BatchEntry {
+	m := template
+	m.Clear()
+	seed := fmt.Sprintf("%v/%v", o.SeedPrefix, index)
+	entry := BatchEntry{
+		Index: index,
+		Width: m.width,
+		Height: m.height,
+		ThicknessValues: o.ThicknessValues,
+		MinWallLength: m.minWallLength,
+		MaxWallLength: m.maxWallLength,
+	}
+
+	if o.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.Timeout)
+		defer cancel()
+	}
+	var err error
+	if len(o.Constraints) == 0 {
+		m.SetSeed(seed)
+		err = m.GenerateNestedContext(ctx, o.ThicknessValues)
+	} else {
+		entry.Attempts, err = m.GenerateWithConstraints(ctx, o.ThicknessValues, seed, o.Constraints, o.Attempts)
+	}
+	entry.Seed = m.Seed()
+	if err != nil {
+		entry.Error = err.Error()
+		if ctx.Err() != nil {
+			return entry
+		}
+	}
+
+	stats := m.Stats()
+	entry.Stats = &stats
+	f, err := os.Create(filepath.Join(o.Directory, o.fileName(index)))
+	if err == nil {
+		switch o.Format {
+		case "json":
+			err = m.WriteJSON(f)
+		case "svg":
+			err = m.WriteSVG(f, ImageOptions{Scale: o.Scale})
+		case "png":
+			err = m.WritePNG(f, ImageOptions{Scale: o.Scale})
+		case "dxf":
+			err = m.WriteDXF(f, o.Pitch, o.WallThickness)
+		default:
+			err = m.WriteText(f)
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		if entry.Error != "" {
+			entry.Error += "; "
+		}
+		entry.Error += err.Error()
+		return entry
+	}
+	entry.File = o.fileName(index)
+	m.log().Info("generated", "index", index, "seed", entry.Seed, "file", entry.File)
+	return entry
+}
+
+// Generates a batch of mazes like the given one (which supplies the size,
+// runes, wall lengths and logger) and returns the manifest, in order.  Only
+// a failure to create the directory stops the batch; problems with single
+// mazes are recorded in their entries.
+func RunBatch(ctx context.Context, template Maze, o BatchOptions) ([]BatchEntry, error) {
+	if err := os.MkdirAll(o.Directory, 0755); err != nil {
+		return nil, err
+	}
+	template.eventHandler = nil
+	entries := make([]BatchEntry, max(0, o.Count))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < max(1, o.Jobs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range(indices) {
+				entries[index] = o.generate(ctx, template, index)
+			}
+		}()
+	}
+	for index := range(entries) {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+	return entries, nil
+}
+
+// Writes the manifest as a JSON array.
+func WriteManifestJSON(w io.Writer, entries []BatchEntry) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+// Writes the manifest as CSV, with a header row.  Only the statistics that
+// are single numbers are included.
+func WriteManifestCSV(w io.Writer, entries []BatchEntry) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{
+		"index", "file", "seed", "width", "height", "thickness", "minWallLength", "maxWallLength", "attempts",
+		"rooms", "deadEnds", "junctions", "riverFactor", "solutionLength", "solutionShare", "solutionTurns", "longestDeadEnd",
+		"error",
+	})
+	for _, e := range(entries) {
+		thickness := []string{}
+		for _, t := range(e.ThicknessValues) {
+			thickness = append(thickness, strconv.Itoa(t))
+		}
+		record := []string{
+			strconv.Itoa(e.Index), e.File, e.Seed, strconv.Itoa(e.Width), strconv.Itoa(e.Height), strings.Join(thickness, ","),
+			strconv.Itoa(e.MinWallLength), strconv.Itoa(e.MaxWallLength), strconv.Itoa(e.Attempts),
+		}
+		if s := e.Stats; s != nil {
+			record = append(record,
+				strconv.Itoa(s.Rooms), strconv.Itoa(s.DeadEnds), strconv.Itoa(s.Junctions),
+				strconv.FormatFloat(s.RiverFactor, 'g', -1, 64), strconv.Itoa(s.SolutionLength),
+				strconv.FormatFloat(s.SolutionShare, 'g', -1, 64), strconv.Itoa(s.SolutionTurns), strconv.Itoa(s.LongestDeadEnd))
+		} else {
+			record = append(record, "", "", "", "", "", "", "", "")
+		}
+		record = append(record, e.Error)
+		out.Write(record)
+	}
+	out.Flush()
+	return out.Error()
+}
+
+func batchMain(args []string) {
+	config, err := LoadConfig(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read the configuration file: %v.\n", err)
+		os.Exit(1)
+	}
+	parser := argparse.NewParser("maze batch", "Generates many mazes at once, each from its own seed, and writes them to a directory along with a manifest of their seeds, parameters and statistics.  The other arguments are the same as for \"maze\"; --timeout applies to each maze.")
+	arguments := addMazeArguments(parser, config)
+	var count *int = parser.Int("n", "count", &argparse.Options{
+		Required: true,
+		Help: "The number of mazes to generate",
+	})
+	var seedPrefix *string = parser.String("", "seed-prefix", &argparse.Options{
+		Required: false,
+		Help: "Maze i is generated from the seed \"<prefix>/<i>\".  The default is the --seed",
+		Default: "",
+	})
+	var directory *string = parser.String("o", "out", &argparse.Options{
+		Required: true,
+		Help: "The directory to write the mazes and the manifest to.  It is created if need be",
+	})
+	var format *string = parser.String("", "format", &argparse.Options{
+		Required: false,
+		Help: fmt.Sprintf("The format of each maze: %v.  JSON is as for --json, with the solution and statistics", strings.Join(batchFormatNames(), ", ")),
+		Default: "text",
+	})
+	var manifestFormat *string = parser.String("", "manifest", &argparse.Options{
+		Required: false,
+		Help: fmt.Sprintf("The format of the manifest: %v", strings.Join(manifestFormatNames(), " or ")),
+		Default: "json",
+	})
+	var jobs *int = parser.Int("j", "jobs", &argparse.Options{
+		Required: false,
+		Help: "The number of mazes to generate at once.  The default is the number of CPUs",
+		Default: runtime.NumCPU(),
+	})
+	var scale *int = parser.Int("", "scale", &argparse.Options{
+		Required: false,
+		Help: "For --format svg and png: the size of each cell, in pixels, from 1 to 64",
+		Default: 8,
+	})
+	var pitch *float64 = parser.Float("", "pitch", &argparse.Options{
+		Required: false,
+		Help: "For --format dxf: the distance between the centers of neighboring corridors, in millimeters",
+		Default: 300.0,
+	})
+	var wallThickness *float64 = parser.Float("", "wall-thickness", &argparse.Options{
+		Required: false,
+		Help: "For --format dxf: the thickness of the wall panels, in millimeters",
+		Default: 18.0,
+	})
+
+	err = parser.Parse(args)
+	if err != nil {
+		fmt.Print(parser.Usage(err))
+		return
+	}
+	m, ok := arguments.newMaze(parser)
+	if !ok {
+		return
+	}
+	buildErr := checkBuildDimensions(*pitch, *wallThickness)
+	ok = false
+	switch {
+	case *count < 0:
+		fmt.Fprintf(os.Stderr, "The --count can't be negative.\n")
+	case !slices.Contains(batchFormatNames(), *format):
+		fmt.Fprintf(os.Stderr, "Unknown --format \"%v\"; expected one of %v.\n", *format, strings.Join(batchFormatNames(), ", "))
+	case !slices.Contains(manifestFormatNames(), *manifestFormat):
+		fmt.Fprintf(os.Stderr, "Unknown --manifest \"%v\"; expected one of %v.\n", *manifestFormat, strings.Join(manifestFormatNames(), ", "))
+	case *jobs < 1:
+		fmt.Fprintf(os.Stderr, "The --jobs must be at least 1.\n")
+	case *scale < 1 || *scale > 64:
+		fmt.Fprintf(os.Stderr, "The --scale must be from 1 to 64.\n")
+	case *format == "png" && !imageFits(m.width, m.height, *scale):
+		fmt.Fprintf(os.Stderr, "A %vx%v maze at a --scale of %v is larger than the limit of %v pixels.\n", m.width, m.height, *scale, maxImagePixels)
+	case *format == "dxf" && buildErr != nil:
+		fmt.Fprintf(os.Stderr, "Could not use --pitch and --wall-thickness: %v.\n", buildErr)
+	default:
+		ok = true
+	}
+	if !ok {
+		fmt.Print(parser.Usage(nil))
+		return
+	}
+	if *seedPrefix == "" {
+		*seedPrefix = m.Seed()
+	}
+
+	options := BatchOptions{
+		Count: *count,
+		SeedPrefix: *seedPrefix,
+		Directory: *directory,
+		Format: *format,
+		Jobs: *jobs,
+		ThicknessValues: arguments.thicknessValues,
+		Constraints: arguments.constraints,
+		Attempts: *arguments.attempts,
+		Timeout: arguments.timeoutValue,
+		Scale: *scale,
+		Pitch: *pitch,
+		WallThickness: *wallThickness,
+	}
+	m.log().Info("starting", "seedPrefix", options.SeedPrefix, "count", options.Count, "jobs", options.Jobs)
+	entries, err := RunBatch(context.Background(), m, options)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not generate the batch: %v.\n", err)
+		os.Exit(1)
+	}
+
+	manifest := filepath.Join(options.Directory, "manifest." + *manifestFormat)
+	f, err := os.Create(manifest)
+	if err == nil {
+		switch *manifestFormat {
+		case "csv":
+			err = WriteManifestCSV(f, entries)
+		default:
+			err = WriteManifestJSON(f, entries)
+		}
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write \"%v\": %v.\n", manifest, err)
+		os.Exit(1)
+	}
+
+	failed := 0
+	for _, e := range(entries) {
+		if e.File == "" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%v of the %v mazes could not be generated; see \"%v\".\n", failed, len(entries), manifest)
+		os.Exit(1)
+	}
+}
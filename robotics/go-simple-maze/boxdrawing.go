@@ -0,0 +1,170 @@
+package main
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Box-drawing wall joins.
+//
+// drawRect() only knows three wall runes, so a corner, a T-junction and a
+// crossing all come out as m.intersection.  When the maze is printed with a
+// box-drawing style, each wall cell is instead drawn with the glyph that
+// joins it to exactly the walls around it.  Walls that stop short of
+// anything (such as the ones beside the entrance) get end caps.
+//
+// The cells themselves are never changed, so everything else (Validate(),
+// Stats(), WallSegments() and so on) continues to see the original runes.
+
+// The glyphs for a single style, indexed by the mask of the neighboring
+// walls that a cell joins (see the left, up, right and down constants.)
+// Index 0 is used for isolated wall posts.
+type boxStyle [16]rune
+
+var boxStyles = map[string]boxStyle{
+	"light": {
+		'·', '╴', '╵', '┘', '╶', '─', '└', '┴',
+		'╷', '┐', '│', '┤', '┌', '┬', '├', '┼',
+	},
+	"heavy": {
+		'•', '╸', '╹', '┛', '╺', '━', '┗', '┻',
+		'╻', '┓', '┃', '┫', '┏', '┳', '┣', '╋',
+	},
+
+	// There are no double-line end caps, so the walls just stop.
+	"double": {
+		'•', '═', '║', '╝', '═', '═', '╚', '╩',
+		'║', '╗', '║', '╣', '╔', '╦', '╠', '╬',
+	},
+	"rounded": {
+		'·', '╴', '╵', '╯', '╶', '─', '╰', '┴',
+		'╷', '╮', '│', '┤', '╭', '┬', '├', '┼',
+	},
+}
+
+// Returns the names of the box-drawing styles in alphabetical order.
+func boxStyleNames() []string {
+	names := []string{}
+	for name := range(boxStyles) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Selects the box-drawing style that Print() uses for the walls.  An empty
+// name turns box drawing off, so that the walls are printed with the maze's
+// own intersection, horizontal and vertical runes.
+func (m *Maze) SetBoxStyle(name string) error {
+	if name == "" {
+		m.boxStyle = nil
+		return nil
+	}
+	style, ok := boxStyles[name]
+	if !ok {
+		return fmt.Errorf("unknown box-drawing style \"%v\"; expected one of %v", name, strings.Join(boxStyleNames(), ", "))
+	}
+	m.boxStyle = &style
+	return nil
+}
+
+// Returns the wall rune that a box-drawing glyph of the selected style stands
+// for, so that Load() can read a maze that was printed with one: the
+// horizontal or vertical rune for the glyphs that only join walls in one
+// direction, and the intersection for the rest.  Anything else is returned
+// as is.
+//
+// A glyph can't say whether it was drawn for an intersection or a straight
+// wall, but Validate() and everything else only care which way a wall
+// joins its neighbors.
+func (m *Maze) unjoinedCell(c rune) rune {
+	if m.boxStyle == nil {
+		return c
+	}
+	for joins, glyph := range(m.boxStyle) {
+		if glyph != c {
+			continue
+		}
+		switch mask(joins) {
+		case left, right, left | right:
+			return m.horizontal
+		case up, down, up | down:
+			return m.vertical
+		}
+		return m.intersection
+	}
+	return c
+}
+
+// Returns a copy of the cells with each wall rune replaced by the
+// box-drawing glyph that joins it to its neighbors.  If no box-drawing style
+// has been selected, the copy is identical to the cells.
+func (m *Maze) joinedCells() []rune {
+	result := make([]rune, m.cellCount())
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			result[m.offset(x, y)] = m.joinedCell(x, y)
+		}
+	}
+	return result
+}
+
+// Returns the rune at the given position, with walls replaced by the
+// box-drawing glyph that joins them to their neighbors if a box-drawing style
+// has been selected.
+func (m *Maze) joinedCell(x, y int) rune {
+	c := m.cell(m.offset(x, y))
+	if m.boxStyle == nil || c == m.floor || c == m.fill || (c != m.intersection && c != m.horizontal && c != m.vertical) {
+		return c
+	}
+
+	// A horizontal wall can only join the walls to its left and right,
+	// and a vertical wall can only join the walls above and below it.
+	// This keeps two parallel walls that happen to be side by side from
+	// being joined.
+	carriesHorizontal := func(x, y int) bool {
+		if !m.valid(x, y) {
+			return false
+		}
+		c := m.cell(m.offset(x, y))
+		return c == m.horizontal || c == m.intersection
+	}
+	carriesVertical := func(x, y int) bool {
+		if !m.valid(x, y) {
+			return false
+		}
+		c := m.cell(m.offset(x, y))
+		return c == m.vertical || c == m.intersection
+	}
+
+	var joins mask
+	if carriesHorizontal(x, y) {
+		if carriesHorizontal(x - 1, y) {
+			joins |= left
+		}
+		if carriesHorizontal(x + 1, y) {
+			joins |= right
+		}
+	}
+	if carriesVertical(x, y) {
+		if carriesVertical(x, y - 1) {
+			joins |= up
+		}
+		if carriesVertical(x, y + 1) {
+			joins |= down
+		}
+	}
+
+	// A straight wall that joins nothing at all is still a straight wall.
+	if joins == 0 {
+		switch c {
+		case m.intersection:
+			break
+		case m.horizontal:
+			joins = left | right
+		case m.vertical:
+			joins = up | down
+		}
+	}
+	return m.boxStyle[joins]
+}
@@ -0,0 +1,273 @@
+package main
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Build plans for constructing a physical maze out of sheet material.
+//
+// The physical model treats every unit rectangle as a point on a grid whose
+// spacing is half the cell pitch, where the cell pitch is the distance
+// between the centers of two neighboring corridors.  (For a maze of thickness
+// 1, the corridors are two characters apart: one for the floor and one for
+// the wall.)  Walls are panels of a fixed thickness centered on the lines
+// that join wall units together.
+//
+// Horizontal panels are the maximal horizontal runs of wall units and run all
+// the way through their junctions.  Vertical panels are cut to fit between
+// them, so that the pieces of the cut list never overlap.  A wall unit with
+// no wall neighbors at all becomes a square post.
+//
+// Nested mazes (multiple thicknesses) are measured against the final, smallest
+// thickness.  Solid blocks of wall left behind by the larger thicknesses come
+// out as stacks of parallel horizontal panels.
+
+type WallSegmentKind int
+const (
+	HorizontalWall WallSegmentKind = iota
+	VerticalWall
+	Post
+)
+
+func (k WallSegmentKind) String() string {
+	switch k {
+	case HorizontalWall:
+		return "horizontal"
+	case VerticalWall:
+		return "vertical"
+	default:
+		return "post"
+	}
+}
+
+// A maximal straight run of wall units.
+type WallSegment struct {
+	Kind WallSegmentKind
+
+	// The unit coordinates of the first and last units in the run,
+	// inclusive.  For horizontal segments, Y1 == Y2; for vertical
+	// segments, X1 == X2; for posts, both are equal.
+	X1, Y1, X2, Y2 int
+
+	// Vertical segments only: true if the top (or bottom) of this segment
+	// butts against a horizontal segment, and so must be cut short.
+	TrimStart, TrimEnd bool
+}
+
+// Extracts the maximal straight wall segments from the maze, in the order
+// described at the top of this file: horizontal segments from top to bottom,
+// followed by vertical segments and posts from left to right.
+func (m *Maze) WallSegments() []WallSegment {
+	unitWidth, unitHeight := m.unitDimensions()
+	if unitWidth <= 0 || unitHeight <= 0 {
+		return nil
+	}
+
+	wall := make([]bool, unitWidth * unitHeight)
+	for unitRow := 0; unitRow < unitHeight; unitRow++ {
+		for unitColumn := 0; unitColumn < unitWidth; unitColumn++ {
+			wall[unitRow * unitWidth + unitColumn] = !m.unitIsOpen(unitColumn, unitRow)
+		}
+	}
+
+	segments := []WallSegment{}
+
+	// Horizontal runs of two or more units come first, and claim the
+	// units they cover.
+	covered := make([]bool, len(wall))
+	for unitRow := 0; unitRow < unitHeight; unitRow++ {
+		for unitColumn := 0; unitColumn < unitWidth; {
+			if !wall[unitRow * unitWidth + unitColumn] {
+				unitColumn++
+				continue
+			}
+			start := unitColumn
+			for unitColumn < unitWidth && wall[unitRow * unitWidth + unitColumn] {
+				unitColumn++
+			}
+			if unitColumn - start < 2 {
+				continue
+			}
+			segments = append(segments, WallSegment{
+				Kind: HorizontalWall,
+				X1: start, Y1: unitRow,
+				X2: unitColumn - 1, Y2: unitRow,
+			})
+			for i := start; i < unitColumn; i++ {
+				covered[unitRow * unitWidth + i] = true
+			}
+		}
+	}
+
+	// Whatever is left over is made of vertical runs (which stop at any
+	// unit claimed by a horizontal run) and isolated posts.
+	for unitColumn := 0; unitColumn < unitWidth; unitColumn++ {
+		for unitRow := 0; unitRow < unitHeight; {
+			index := unitRow * unitWidth + unitColumn
+			if !wall[index] || covered[index] {
+				unitRow++
+				continue
+			}
+			start := unitRow
+			for unitRow < unitHeight && wall[unitRow * unitWidth + unitColumn] && !covered[unitRow * unitWidth + unitColumn] {
+				unitRow++
+			}
+			segment := WallSegment{
+				Kind: VerticalWall,
+				X1: unitColumn, Y1: start,
+				X2: unitColumn, Y2: unitRow - 1,
+				TrimStart: start > 0 && covered[(start - 1) * unitWidth + unitColumn],
+				TrimEnd: unitRow < unitHeight && covered[unitRow * unitWidth + unitColumn],
+			}
+			if segment.Y1 == segment.Y2 && !segment.TrimStart && !segment.TrimEnd {
+				segment.Kind = Post
+			}
+			segments = append(segments, segment)
+		}
+	}
+
+	return segments
+}
+
+// Returns the footprint of the segment as an axis-aligned rectangle, in
+// physical units, with (0, 0) at the center of the upper-left unit of the
+// maze and Y increasing downward.
+func (s WallSegment) Footprint(pitch, thickness float64) (x1, y1, x2, y2 float64) {
+	spacing := pitch / 2
+	x1 = float64(s.X1) * spacing - thickness / 2
+	x2 = float64(s.X2) * spacing + thickness / 2
+	y1 = float64(s.Y1) * spacing - thickness / 2
+	y2 = float64(s.Y2) * spacing + thickness / 2
+	if s.TrimStart {
+		y1 = float64(s.Y1 - 1) * spacing + thickness / 2
+	}
+	if s.TrimEnd {
+		y2 = float64(s.Y2 + 1) * spacing - thickness / 2
+	}
+	return x1, y1, x2, y2
+}
+
+// Returns the length of the panel that has to be cut for this segment.
+func (s WallSegment) Length(pitch, thickness float64) float64 {
+	x1, y1, x2, y2 := s.Footprint(pitch, thickness)
+	if s.Kind == VerticalWall {
+		return y2 - y1
+	}
+	return x2 - x1
+}
+
+// Returns an error unless the pitch is positive and the wall thickness leaves
+// room for the corridors between the walls.
+func checkBuildDimensions(pitch, thickness float64) error {
+	if !(pitch > 0) {
+		return fmt.Errorf("the cell pitch must be greater than 0, not %v", pitch)
+	}
+	if !(thickness > 0 && thickness < pitch) {
+		return fmt.Errorf("the wall thickness must be greater than 0 and less than the cell pitch (%v), not %v", pitch, thickness)
+	}
+	return nil
+}
+
+// Writes the maze's wall segments to the given writer as an AutoCAD R12 DXF
+// drawing.  Every segment is drawn as a closed rectangle on the WALLS layer;
+// the drawing's units are whatever units the pitch and thickness are in
+// (millimeters being the customary choice.)  The pitch must be positive and
+// the thickness between 0 and the pitch.
+func (m *Maze) WriteDXF(w io.Writer, pitch, thickness float64) error {
+	if err := checkBuildDimensions(pitch, thickness); err != nil {
+		return err
+	}
+	_, unitHeight := m.unitDimensions()
+
+	// DXF's Y axis points up, whereas ours points down.
+	top := float64(unitHeight - 1) * pitch / 2
+
+	var err error
+	write := func(code int, value string) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%3d\n%s\n", code, value)
+		}
+	}
+	line := func(x1, y1, x2, y2 float64) {
+		write(0, "LINE")
+		write(8, "WALLS")
+		write(10, fmt.Sprintf("%.3f", x1))
+		write(20, fmt.Sprintf("%.3f", top - y1))
+		write(30, "0.0")
+		write(11, fmt.Sprintf("%.3f", x2))
+		write(21, fmt.Sprintf("%.3f", top - y2))
+		write(31, "0.0")
+	}
+
+	write(0, "SECTION")
+	write(2, "ENTITIES")
+	for _, s := range(m.WallSegments()) {
+		x1, y1, x2, y2 := s.Footprint(pitch, thickness)
+		line(x1, y1, x2, y1)
+		line(x2, y1, x2, y2)
+		line(x2, y2, x1, y2)
+		line(x1, y2, x1, y1)
+	}
+	write(0, "ENDSEC")
+	write(0, "EOF")
+	return err
+}
+
+// Writes a bill of materials for the maze's wall segments: one line for each
+// distinct panel length, with the number of panels of that length to cut.
+// The pitch and thickness are as for WriteDXF().
+func (m *Maze) WriteBillOfMaterials(w io.Writer, pitch, thickness float64) error {
+	if err := checkBuildDimensions(pitch, thickness); err != nil {
+		return err
+	}
+
+	type entry struct {
+		kind WallSegmentKind
+		length float64
+	}
+	counts := map[entry]int{}
+	totalCount, totalLength := 0, 0.0
+	for _, s := range(m.WallSegments()) {
+		kind := s.Kind
+		if kind == HorizontalWall {
+			// Once cut, a horizontal panel is no different from
+			// a vertical one.
+			kind = VerticalWall
+		}
+		// Round to the nearest tenth so that floating-point noise
+		// doesn't split identical panels into separate lines.
+		length := float64(int64(s.Length(pitch, thickness) * 10 + 0.5)) / 10
+		counts[entry{kind, length}]++
+		totalCount++
+		totalLength += length
+	}
+
+	entries := []entry{}
+	for e := range(counts) {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].kind != entries[j].kind {
+			return entries[i].kind < entries[j].kind
+		}
+		return entries[i].length > entries[j].length
+	})
+
+	_, err := fmt.Fprintf(w, "Bill of materials (cell pitch %.1f, wall thickness %.1f)\n\n%6v  %10v  %v\n",
+		pitch, thickness, "Count", "Length", "Type")
+	for _, e := range(entries) {
+		if err != nil {
+			break
+		}
+		kind := "panel"
+		if e.kind == Post {
+			kind = "post"
+		}
+		_, err = fmt.Fprintf(w, "%6d  %10.1f  %v\n", counts[e], e.length, kind)
+	}
+	if err == nil {
+		_, err = fmt.Fprintf(w, "\nTotal pieces: %v.  Total length: %.1f.\n", totalCount, totalLength)
+	}
+	return err
+}
@@ -0,0 +1,214 @@
+package main
+import (
+	"math"
+)
+
+// Choosing where to start the next wall.
+//
+// The generator used to pick a unit and a direction at random and try them,
+// counting a "miss" whenever the unit was empty, the wall had nowhere to go,
+// or (49 times out of 50) the wall was longer than --max.  As a maze filled
+// up, nearly every try was a miss.
+//
+// Instead, each occupied unit has a weight for each direction: 50 if a wall
+// could start there, 1 if it could but would be too long, and 0 otherwise.
+// Choosing a unit and direction in proportion to those weights gives exactly
+// the walls that the old tries would have settled on, with the same odds,
+// but it never misses.  Placing a wall only changes the weights of the units
+// near it, so they are kept up to date as we go.
+
+// The weights of the different kinds of walls.  A wall that's too long is
+// accepted only one time in 50.
+const (
+	fittingWallWeight = 50
+	longWallWeight = 1
+)
+
+// A list of weights that supports changing a weight and choosing an index at
+// random in proportion to its weight, both in logarithmic time.  (It's a
+// Fenwick tree.)
+//
+// There are four of these, each with an entry for every even unit, so they
+// take up more memory than the maze itself unless they're kept small: the
+// weights fit in a byte, and the sums in the tree fit in 32 bits unless the
+// maze is enormous, in which case wideTree is used instead.
+type weightedSet struct {
+	weights []uint8
+	tree []int32
+	wideTree []int64
+	total int
+}
+
+func newWeightedSet(n int) *weightedSet {
+	s := &weightedSet{weights: make([]uint8, n)}
+	if n > math.MaxInt32 / fittingWallWeight {
+		s.wideTree = make([]int64, n + 1)
+	} else {
+		s.tree = make([]int32, n + 1)
+	}
+	return s
+}
+
+// Returns the number of entries in the tree, which is one more than the
+// number of weights.
+func (s *weightedSet) treeSize() int {
+	return len(s.weights) + 1
+}
+
+// Returns the sum that the tree keeps at the given index.
+func (s *weightedSet) node(i int) int {
+	if s.wideTree != nil {
+		return int(s.wideTree[i])
+	}
+	return int(s.tree[i])
+}
+
+func (s *weightedSet) set(index, weight int) {
+	delta := weight - int(s.weights[index])
+	if delta == 0 {
+		return
+	}
+	s.weights[index] = uint8(weight)
+	s.total += delta
+	for i := index + 1; i < s.treeSize(); i += i & -i {
+		if s.wideTree != nil {
+			s.wideTree[i] += int64(delta)
+		} else {
+			s.tree[i] += int32(delta)
+		}
+	}
+}
+
+// Returns the index whose share of the total contains r, where
+// 0 <= r < s.total.
+func (s *weightedSet) find(r int) int {
+	index := 0
+	step := 1
+	for step * 2 < s.treeSize() {
+		step *= 2
+	}
+	for ; step > 0; step /= 2 {
+		if index + step < s.treeSize() && s.node(index + step) <= r {
+			index += step
+			r -= s.node(index)
+		}
+	}
+	return index
+}
+
+// Returns the index of each even unit, which is where walls start.
+func (g *Generator) unitIndex(unitColumn, unitRow int) int {
+	return (unitRow / 2) * (g.unitWidth / 2 + 1) + unitColumn / 2
+}
+
+// Returns the number of even units.
+func (g *Generator) evenUnits() int {
+	return (g.unitWidth / 2 + 1) * (g.unitHeight / 2 + 1)
+}
+
+// Returns the minimum and maximum wall lengths, rounded to odd numbers.
+//
+// Note that the actual wall length will always be between 3 and the
+// potential wall length, regardless of what minWallLength and maxWallLength
+// are set to.  They are _guidelines_, not rigid constraints.
+func (g *Generator) wallLengthRange() (minWallLength, maxWallLength int) {
+	minWallLength, maxWallLength = g.m.minWallLength, g.m.maxWallLength
+	minWallLength += (minWallLength + 1) % 2                        // Round even minimum lengths up to the next highest odd number
+	maxWallLength -= (maxWallLength + 1) % 2                        // Round even maximum lengths down to the next lowest odd number
+	if maxWallLength < minWallLength {
+		maxWallLength = minWallLength // minWallLength <= maxWallLength
+	}
+	return minWallLength, maxWallLength
+}
+
+// Returns the number of units that a wall starting at the given unit could
+// cover in the given direction, counting the starting unit.  This is always
+// odd.  If limit is greater than 0, we stop looking after that many steps of
+// two units, since the weights don't care how much longer the wall could go.
+func (g *Generator) potentialWallLength(unitColumn, unitRow, vx, vy, limit int) int {
+	potentialWallLength := 1
+	for steps := 0; limit <= 0 || steps < limit; steps++ {
+		if !g.canExtend(unitColumn, unitRow, vx, vy) {
+			break
+		}
+		potentialWallLength += 2
+		unitColumn += 2 * vx
+		unitRow += 2 * vy
+	}
+	return potentialWallLength
+}
+
+// Returns how far potentialWallLength() has to look to tell the kinds of
+// walls apart.
+func (g *Generator) weightLimit() int {
+	_, maxWallLength := g.wallLengthRange()
+	limit := (max(3, maxWallLength) - 1) / 2 + 1
+	if limit > max(g.unitWidth, g.unitHeight) / 2 {
+		// No wall can be too long, so all we need to know is whether
+		// there's room for one at all.
+		limit = 1
+	}
+	return limit
+}
+
+// Returns the weight of a wall starting at the given unit in the given
+// direction (see above.)
+func (g *Generator) wallWeight(unitColumn, unitRow, direction int) int {
+	m := g.m
+	x, y, width, height := m.unitCoordinatesToRect(unitColumn, unitRow)
+	if m.rectContains(x, y, width, height, m.floor) {
+		return 0
+	}
+	_, maxWallLength := g.wallLengthRange()
+	d := directions[direction]
+	switch potentialWallLength := g.potentialWallLength(unitColumn, unitRow, d.x, d.y, g.weightLimit()); {
+	case potentialWallLength < 3:
+		return 0
+	case potentialWallLength > max(3, maxWallLength):
+		return longWallWeight
+	}
+	return fittingWallWeight
+}
+
+// Computes the weights of every wall that could be started in this pass.
+func (g *Generator) indexCandidates() {
+	g.candidates = make([]*weightedSet, len(directions))
+	for direction := range(directions) {
+		g.candidates[direction] = newWeightedSet(g.evenUnits())
+	}
+	for unitRow := 0; unitRow < g.unitHeight; unitRow += 2 {
+		for unitColumn := 0; unitColumn < g.unitWidth; unitColumn += 2 {
+			for direction := range(directions) {
+				g.candidates[direction].set(g.unitIndex(unitColumn, unitRow), g.wallWeight(unitColumn, unitRow, direction))
+			}
+		}
+	}
+}
+
+// Updates the weights after a wall has been drawn from the given unit.  The
+// wall's own units have new weights, and so do the nearest occupied units
+// that look toward it in a straight line; nothing else can have changed.
+func (g *Generator) updateCandidates(unitColumn, unitRow, vx, vy, wallLength int) {
+	limit := g.weightLimit()
+	for i := 0; i < wallLength; i += 2 {
+		c, r := unitColumn + i * vx, unitRow + i * vy
+		for direction, d := range(directions) {
+			g.candidates[direction].set(g.unitIndex(c, r), g.wallWeight(c, r, direction))
+
+			// A unit further away than the limit can't tell the
+			// difference.
+			for step := 1; step <= limit; step++ {
+				otherColumn, otherRow := c + 2 * step * d.x, r + 2 * step * d.y
+				if otherColumn < 0 || otherRow < 0 || otherColumn >= g.unitWidth || otherRow >= g.unitHeight {
+					break
+				}
+				x, y, width, height := g.m.unitCoordinatesToRect(otherColumn, otherRow)
+				if !g.m.rectContains(x, y, width, height, g.m.floor) {
+					opposite := (direction + 2) % len(directions)
+					g.candidates[opposite].set(g.unitIndex(otherColumn, otherRow), g.wallWeight(otherColumn, otherRow, opposite))
+					break
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,525 @@
+package main
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"github.com/akamensky/argparse"
+)
+
+// Tiled mazes.
+//
+// A maze that's too big to generate in one go (or that has no end at all,
+// like the map of an open world) can be generated as a grid of chunks, each
+// of which is an ordinary maze of its own.  Every chunk is generated from a
+// seed derived from the grid's seed and the chunk's coordinates, so any
+// chunk can be generated at any time, in any order, without looking at the
+// others.
+//
+// Neighboring chunks share the wall between them: the wall along the right
+// of one chunk is the wall along the left of the next, and likewise for the
+// walls along the bottom and top.  To connect the chunks, each one (other
+// than the first) cuts a doorway through the wall to its left or the wall
+// above it, chosen at random; the chunks in the top row always go left and
+// the ones in the left column always go up.  That makes a meta-maze over the
+// chunks.  (It has the strong diagonal bias of any "binary tree" maze, but
+// the chunks themselves hide most of it.)  The doorway's position along the
+// wall also depends only on the seed and the coordinates of the chunk that
+// cut it, so both chunks agree on where it is.  Since each chunk is a perfect
+// maze and the meta-maze is a tree, the whole grid is a perfect maze, too.
+//
+// Chunk coordinates start at (0, 0) in the upper left and grow to the right
+// and down.  A grid with a fixed number of columns and rows also gets an
+// entrance on the left of its first chunk and an exit on the right of its
+// last.
+
+type ChunkGrid struct {
+	// The maze that every chunk is copied from.  Only its size,
+	// thickness, runes, wall lengths and seed matter.
+	template Maze
+
+	// The number of chunks across and down, or 0 if there's no end in
+	// that direction.
+	columns, rows int
+
+	// True if stitched mazes should be packed (see storage.go.)  The
+	// chunks themselves are small enough not to bother.
+	packed bool
+}
+
+// Returns a grid of chunks shaped like the given maze.  The chunk size is
+// trimmed to fit the maze's unit grid exactly (see gridDimensions()), so
+// that neighboring chunks line up.  The number of columns or rows may be 0
+// for a grid that goes on forever in that direction.
+func NewChunkGrid(template Maze, columns, rows int) (*ChunkGrid, error) {
+	if columns < 0 || rows < 0 {
+		return nil, fmt.Errorf("the grid can't have %v columns and %v rows", columns, rows)
+	}
+	template.thickness = max(1, template.thickness)
+	unitWidth, unitHeight := template.unitDimensions()
+	if unitWidth < 3 || unitHeight < 3 {
+		return nil, fmt.Errorf("a chunk of %vx%v is too small for a thickness of %v", template.width, template.height, template.thickness)
+	}
+	width, height := template.gridDimensions()
+	c := &ChunkGrid{columns: columns, rows: rows, packed: template.Packed()}
+	template.setSize(width, height)
+	template.cells, template.packed = nil, nil
+	template.eventHandler = nil
+	c.template = template
+	return c, nil
+}
+
+// Returns the size of a chunk in cells.
+func (c *ChunkGrid) ChunkDimensions() (width, height int) {
+	return c.template.width, c.template.height
+}
+
+// Returns the distance, in cells, between the left edges of neighboring
+// chunks and between their top edges.  This is one border wall less than
+// the size of a chunk.
+func (c *ChunkGrid) stride() (x, y int) {
+	unitWidth, unitHeight := c.template.unitDimensions()
+	x, y, _, _ = c.template.unitCoordinatesToRect(unitWidth - 1, unitHeight - 1)
+	return x, y
+}
+
+// Returns the size of the whole grid in cells, or 0 in a direction that has
+// no end.
+func (c *ChunkGrid) Dimensions() (width, height int) {
+	strideX, strideY := c.stride()
+	if c.columns > 0 {
+		width = c.columns * strideX + c.template.width - strideX
+	}
+	if c.rows > 0 {
+		height = c.rows * strideY + c.template.height - strideY
+	}
+	return width, height
+}
+
+// Returns true if there's a chunk at the given coordinates.
+func (c *ChunkGrid) contains(column, row int) bool {
+	return column >= 0 && row >= 0 && (c.columns == 0 || column < c.columns) && (c.rows == 0 || row < c.rows)
+}
+
+// Returns the seed for the chunk at the given coordinates.
+func (c *ChunkGrid) chunkSeed(column, row int) string {
+	return fmt.Sprintf("%v/%v,%v", c.template.seed, column, row)
+}
+
+// Returns a random number from 0 to n - 1 that depends only on the grid's
+// seed and the given description.
+func (c *ChunkGrid) choose(n int, description string) int {
+	return rand.New(rand.NewSource(seedValue(c.template.seed + "/" + description))).Intn(n)
+}
+
+// Returns the direction (an index into the directions array) of the doorway
+// that the given chunk cuts to join the meta-maze: 0 for left or 1 for up.
+// The first chunk doesn't cut one, so it gets -1.
+func (c *ChunkGrid) link(column, row int) int {
+	switch {
+	case column == 0 && row == 0:
+		return -1
+	case row == 0:
+		return 0
+	case column == 0:
+		return 1
+	}
+	return c.choose(2, fmt.Sprintf("link %v,%v", column, row))
+}
+
+// Returns the position of a doorway cut by the given chunk through its wall
+// in the given direction, as a unit along that wall.  This is always odd.
+func (c *ChunkGrid) doorway(column, row, direction int) int {
+	unitWidth, unitHeight := c.template.unitDimensions()
+	length := unitHeight
+	if directions[direction].x == 0 {
+		length = unitWidth
+	}
+	return 1 + 2 * c.choose(length / 2, fmt.Sprintf("doorway %v,%v,%v", column, row, direction))
+}
+
+// A doorway in one of a chunk's walls.
+type chunkDoorway struct {
+	direction int
+	position int
+}
+
+// Returns the doorways in the given chunk's walls: the one it cut itself,
+// the ones its right and lower neighbors cut into it, and the entrance or
+// exit of a grid with an end.
+func (c *ChunkGrid) doorways(column, row int) (doorways []chunkDoorway, entrance, exit *chunkDoorway) {
+	if d := c.link(column, row); d >= 0 {
+		doorways = append(doorways, chunkDoorway{direction: d, position: c.doorway(column, row, d)})
+	}
+	if c.contains(column + 1, row) && c.link(column + 1, row) == 0 {
+		doorways = append(doorways, chunkDoorway{direction: 2, position: c.doorway(column + 1, row, 0)})
+	}
+	if c.contains(column, row + 1) && c.link(column, row + 1) == 1 {
+		doorways = append(doorways, chunkDoorway{direction: 3, position: c.doorway(column, row + 1, 1)})
+	}
+	if c.columns > 0 && c.rows > 0 {
+		if column == 0 && row == 0 {
+			entrance = &chunkDoorway{direction: 0, position: c.doorway(column, row, 0)}
+			doorways = append(doorways, *entrance)
+		}
+		if column == c.columns - 1 && row == c.rows - 1 {
+			exit = &chunkDoorway{direction: 2, position: c.doorway(column, row, 2)}
+			doorways = append(doorways, *exit)
+		}
+	}
+	return doorways, entrance, exit
+}
+
+// Cuts a doorway through the border wall in the given direction, at the
+// given unit along it, and returns the rectangle of cells that it cleared.
+// Like the entrance and exit, the doorway only clears the interior of the
+// wall's unit for thicknesses greater than 2.
+func (m *Maze) cutDoorway(direction, position int) (x, y, width, height int) {
+	unitWidth, unitHeight := m.unitDimensions()
+	p := point{x: position, y: position}
+	switch direction {
+	case 0:
+		p.x = 0
+	case 1:
+		p.y = 0
+	case 2:
+		p.x = unitWidth - 1
+	case 3:
+		p.y = unitHeight - 1
+	}
+	x, y, width, height = m.unitCoordinatesToRect(p.x, p.y)
+	if m.thickness > 2 {
+		if directions[direction].x == 0 {
+			x, width = x + 1, width - 2
+		} else {
+			y, height = y + 1, height - 2
+		}
+	}
+	m.clearRect(x, y, width, height)
+	m.touchUpWalls(x, y, width, height)
+	return x, y, width, height
+}
+
+// Generates the chunk at the given coordinates.  The same grid always
+// produces the same chunk.
+func (c *ChunkGrid) Chunk(ctx context.Context, column, row int) (Maze, error) {
+	if !c.contains(column, row) {
+		return Maze{}, fmt.Errorf("there is no chunk at (%v, %v)", column, row)
+	}
+	m := c.template
+	m.Clear()
+	m.SetSeed(c.chunkSeed(column, row))
+	g := m.Generator()
+	g.SetClosed(true)
+	if err := m.run(ctx, g); err != nil {
+		return Maze{}, err
+	}
+
+	doorways, entrance, exit := c.doorways(column, row)
+	m.entrances, m.exits = []rect{}, []rect{}
+	for _, d := range(doorways) {
+		x, y, width, height := m.cutDoorway(d.direction, d.position)
+		switch {
+		case entrance != nil && d == *entrance:
+			m.entrances = append(m.entrances, rect{x, y, width, height})
+		case exit != nil && d == *exit:
+			m.exits = append(m.exits, rect{x, y, width, height})
+		}
+	}
+	return m, nil
+}
+
+// Returns an empty maze of the given size with the grid's runes and
+// parameters.
+func (c *ChunkGrid) blank(width, height int) Maze {
+	m := c.template
+	m.setSize(width, height)
+	m.entrances, m.exits = []rect{}, []rect{}
+	if c.packed {
+		m.packed = newPackedCells(0, m.floor)
+	}
+	m.Clear()
+	return m
+}
+
+// Returns the rune for a cell that two chunks share.  They always agree on
+// where the floor is, but not on how the walls join: what is the middle of a
+// wall on one side can be where a wall meets it on the other.
+func (m *Maze) mergeCells(a, b rune) rune {
+	switch {
+	case a == b:
+		return a
+	case a == m.floor || a == m.fill:
+		return b
+	case b == m.floor || b == m.fill:
+		return a
+	}
+	return m.intersection
+}
+
+// Copies the cells of another maze (a chunk) into this one, with the other
+// maze's upper-left corner at the given position.  The chunk's entrance and
+// exit come along with it.
+func (m *Maze) paste(other *Maze, x, y int) {
+	for row := 0; row < other.height; row++ {
+		for column := 0; column < other.width; column++ {
+			if !m.valid(x + column, y + row) {
+				continue
+			}
+			offset := m.offset(x + column, y + row)
+			m.setCell(offset, m.mergeCells(m.cell(offset), other.cell(other.offset(column, row))))
+		}
+	}
+	for _, r := range(other.entrances) {
+		m.entrances = append(m.entrances, rect{r.x + x, r.y + y, r.width, r.height})
+	}
+	for _, r := range(other.exits) {
+		m.exits = append(m.exits, rect{r.x + x, r.y + y, r.width, r.height})
+	}
+}
+
+// Generates the given row of chunks and pastes them into m, with the top of
+// the row at y.  The grid must have a fixed number of columns.
+func (c *ChunkGrid) pasteRow(ctx context.Context, m *Maze, row, y int) error {
+	strideX, _ := c.stride()
+	for column := 0; column < c.columns; column++ {
+		chunk, err := c.Chunk(ctx, column, row)
+		if err != nil {
+			return err
+		}
+		m.paste(&chunk, column * strideX, y)
+	}
+	return nil
+}
+
+// Returns an error unless the grid has a fixed number of columns and rows.
+func (c *ChunkGrid) checkBounded() error {
+	if c.columns == 0 || c.rows == 0 {
+		return fmt.Errorf("the grid has no end, so it can only be generated a chunk at a time")
+	}
+	return nil
+}
+
+// Generates every chunk and stitches them into a single maze.  The grid must
+// have a fixed number of columns and rows, and the whole maze has to fit in
+// memory; see WriteText() for a way around the latter.
+func (c *ChunkGrid) Stitch(ctx context.Context) (Maze, error) {
+	if err := c.checkBounded(); err != nil {
+		return Maze{}, err
+	}
+	width, height := c.Dimensions()
+	_, strideY := c.stride()
+	m := c.blank(width, height)
+	for row := 0; row < c.rows; row++ {
+		if err := c.pasteRow(ctx, &m, row, row * strideY); err != nil {
+			return Maze{}, err
+		}
+	}
+	return m, nil
+}
+
+// Writes the stitched maze as Print() would, generating the chunks a row at
+// a time so that only two rows of chunks are ever in memory.  The grid must
+// have a fixed number of columns and rows.
+func (c *ChunkGrid) WriteText(ctx context.Context, w io.Writer) error {
+	if err := c.checkBounded(); err != nil {
+		return err
+	}
+	width, _ := c.Dimensions()
+	_, strideY := c.stride()
+	chunkHeight := c.template.height
+	out := bufio.NewWriter(w)
+	writeRow := func(m *Maze, y int) error {
+		for _, s := range(m.renderedRow(y)) {
+			out.WriteString(s)
+		}
+		return out.WriteByte('\n')
+	}
+
+	// The window holds a row of chunks and the row below it, which share
+	// a wall (as many cells thick as the maze.)  The box-drawing joins
+	// along that wall depend on both.  The top row of chunks has its
+	// upper wall to itself.
+	window := c.blank(width, chunkHeight + strideY)
+	shared := chunkHeight - strideY
+	if err := c.pasteRow(ctx, &window, 0, 0); err != nil {
+		return err
+	}
+	for y := 0; y < shared; y++ {
+		if err := writeRow(&window, y); err != nil {
+			return err
+		}
+	}
+	for row := 0; row < c.rows; row++ {
+		if row + 1 < c.rows {
+			if err := c.pasteRow(ctx, &window, row + 1, strideY); err != nil {
+				return err
+			}
+		}
+		for y := shared; y < chunkHeight; y++ {
+			if err := writeRow(&window, y); err != nil {
+				return err
+			}
+		}
+
+		// Slide the window down by a row of chunks.
+		for y := 0; y < window.height; y++ {
+			for x := 0; x < width; x++ {
+				cell := window.floor
+				if y + strideY < window.height {
+					cell = window.cell(window.offset(x, y + strideY))
+				}
+				window.setCell(window.offset(x, y), cell)
+			}
+		}
+	}
+	return out.Flush()
+}
+
+// Writes each chunk of the grid to its own file in the given directory,
+// named after its coordinates ("chunk-3-5.txt" for column 3, row 5.)  The
+// grid must have a fixed number of columns and rows.
+func (c *ChunkGrid) WriteChunks(ctx context.Context, directory string) error {
+	if err := c.checkBounded(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+	for row := 0; row < c.rows; row++ {
+		for column := 0; column < c.columns; column++ {
+			chunk, err := c.Chunk(ctx, column, row)
+			if err != nil {
+				return err
+			}
+			f, err := os.Create(filepath.Join(directory, fmt.Sprintf("chunk-%v-%v.txt", column, row)))
+			if err != nil {
+				return err
+			}
+			err = chunk.WriteText(f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Parses chunk coordinates of the form "column,row".
+func parseChunkCoordinates(s string) (column, row int, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) == 2 {
+		column, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err == nil {
+			row, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+		}
+		if err == nil {
+			return column, row, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("\"%v\" is not of the form \"column,row\"", s)
+}
+
+func chunksMain(args []string) {
+	config, err := LoadConfig(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read the configuration file: %v.\n", err)
+		os.Exit(1)
+	}
+	parser := argparse.NewParser("maze chunks", "Generates a huge maze as a grid of chunks, each of which is a maze of --width by --height connected to its neighbors.  Any chunk can be generated on its own from the seed and its coordinates.  The other arguments are the same as for \"maze\", except that only the last --thickness is used, and --difficulty, --require, --entrance, --exit, --room and --solid can't be used.")
+	arguments := addMazeArguments(parser, config)
+	var columns *int = parser.Int("", "columns", &argparse.Options{
+		Required: false,
+		Help: "The number of chunks across.  0 means that the grid goes on forever, in which case only --chunk can be used",
+		Default: 4,
+	})
+	var rows *int = parser.Int("", "rows", &argparse.Options{
+		Required: false,
+		Help: "The number of chunks down.  0 means that the grid goes on forever, in which case only --chunk can be used",
+		Default: 4,
+	})
+	var chunk *string = parser.String("", "chunk", &argparse.Options{
+		Required: false,
+		Help: "Prints only the chunk at the given coordinates, as \"column,row\" (counting from 0,0 in the upper left)",
+		Default: "",
+	})
+	var outputDirectory *string = parser.String("o", "output-dir", &argparse.Options{
+		Required: false,
+		Help: "Writes each chunk to its own file in this directory (chunk-<column>-<row>.txt) instead of printing the stitched maze",
+		Default: "",
+	})
+
+	err = parser.Parse(args)
+	if err != nil {
+		fmt.Print(parser.Usage(err))
+		return
+	}
+	m, ok := arguments.newMaze(parser)
+	if !ok {
+		return
+	}
+	if len(arguments.constraints) > 0 {
+		fmt.Fprintf(os.Stderr, "--difficulty and --require can't be used with chunks.\n")
+		fmt.Print(parser.Usage(nil))
+		return
+	}
+	if len(*arguments.rooms) > 0 || len(*arguments.solids) > 0 {
+		// The regions would be copied into every chunk at its own
+		// coordinates rather than into the one chunk that holds them.
+		fmt.Fprintf(os.Stderr, "--room and --solid can't be used with chunks.\n")
+		fmt.Print(parser.Usage(nil))
+		return
+	}
+	if len(*arguments.entrances) > 0 || len(*arguments.exits) > 0 {
+		// Every chunk has its own openings to its neighbors, so there
+		// is no border of the whole grid to put these on.
+		fmt.Fprintf(os.Stderr, "--entrance and --exit can't be used with chunks.\n")
+		fmt.Print(parser.Usage(nil))
+		return
+	}
+	grid, err := NewChunkGrid(m, *columns, *rows)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not make the grid: %v.\n", err)
+		fmt.Print(parser.Usage(nil))
+		return
+	}
+
+	ctx := context.Background()
+	if arguments.timeoutValue > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, arguments.timeoutValue)
+		defer cancel()
+	}
+	m.log().Info("starting", "seed", m.Seed(), "columns", *columns, "rows", *rows)
+
+	switch {
+	case *chunk != "":
+		column, row, parseErr := parseChunkCoordinates(*chunk)
+		if parseErr != nil {
+			fmt.Fprintf(os.Stderr, "Could not use --chunk: %v.\n", parseErr)
+			fmt.Print(parser.Usage(nil))
+			return
+		}
+		var result Maze
+		result, err = grid.Chunk(ctx, column, row)
+		if err == nil {
+			err = result.WriteText(os.Stdout)
+		}
+	case *outputDirectory != "":
+		err = grid.WriteChunks(ctx, *outputDirectory)
+	default:
+		err = grid.WriteText(ctx, os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not generate the chunks: %v.\n", err)
+		os.Exit(1)
+	}
+}
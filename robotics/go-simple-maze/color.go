@@ -0,0 +1,369 @@
+package main
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Color output for terminals.
+//
+// The walls, the entrance, the exit and (optionally) the solution are each
+// drawn in their own color.  Since the floor is normally blank, everything
+// other than the walls is drawn by coloring the background of the floor
+// cells.  A theme with a visible floor (such as ".") gets a color of its own
+// for the floor, too.
+//
+// In heat map mode, each floor cell is shaded by its distance from the
+// entrance, from blue (near) to red (far.)  The cells along the solution
+// get steadily warmer, and the long branches that lead away from it stand
+// out as the warm areas that don't lead to the exit.
+
+// How many colors the terminal can display.
+type ColorMode int
+const (
+	NoColor ColorMode = iota
+	Color16
+	Color256
+	TrueColor
+)
+
+// Returns the names accepted by ParseColorMode(), in order of preference.
+func colorModeNames() []string {
+	return []string{"auto", "never", "16", "256", "truecolor"}
+}
+
+// Converts a --color argument into a ColorMode.  "auto" picks a mode based
+// on the terminal that the given file is attached to (see
+// detectColorMode()); the other modes are used as-is, even if the output is
+// not a terminal.
+func ParseColorMode(name string, f *os.File) (ColorMode, error) {
+	switch name {
+	case "auto":
+		return detectColorMode(f), nil
+	case "never":
+		return NoColor, nil
+	case "16":
+		return Color16, nil
+	case "256":
+		return Color256, nil
+	case "truecolor":
+		return TrueColor, nil
+	}
+	return NoColor, fmt.Errorf("unknown color mode \"%v\"; expected one of %v", name, strings.Join(colorModeNames(), ", "))
+}
+
+// Guesses how many colors the terminal attached to the given file supports.
+// There is no color at all if the file isn't a terminal, if TERM is "dumb",
+// or if the NO_COLOR environment variable is set to anything (see
+// https://no-color.org/.)
+func detectColorMode(f *os.File) ColorMode {
+	if os.Getenv("NO_COLOR") != "" {
+		return NoColor
+	}
+	info, err := f.Stat()
+	if err != nil || info.Mode() & os.ModeCharDevice == 0 {
+		return NoColor
+	}
+	term := os.Getenv("TERM")
+	switch colorTerm := os.Getenv("COLORTERM"); {
+	case term == "dumb":
+		return NoColor
+	case colorTerm == "truecolor" || colorTerm == "24bit":
+		return TrueColor
+	case strings.Contains(term, "256color"):
+		return Color256
+	}
+	return Color16
+}
+
+// A 24-bit color.
+type rgb struct {
+	r, g, b uint8
+}
+
+// The colors used for each part of the maze.
+type palette struct {
+	wall, fill, floor, entrance, exit, solution rgb
+}
+
+var defaultPalette = palette{
+	wall: rgb{200, 200, 200},
+	fill: rgb{110, 110, 110},
+	floor: rgb{140, 140, 140},
+	entrance: rgb{0, 175, 0},
+	exit: rgb{205, 0, 0},
+	solution: rgb{215, 175, 0},
+}
+
+// The standard 16 ANSI colors, as xterm displays them.
+var ansiColors = [16]rgb{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// Returns the SGR parameters that select the given color as the foreground
+// (or background) in the given mode.
+func (c rgb) sgr(mode ColorMode, background bool) string {
+	squaredDistance := func(a, b rgb) int {
+		dr, dg, db := int(a.r) - int(b.r), int(a.g) - int(b.g), int(a.b) - int(b.b)
+		return dr * dr + dg * dg + db * db
+	}
+
+	switch mode {
+	case Color16:
+		best := 0
+		for i := range(ansiColors) {
+			if squaredDistance(c, ansiColors[i]) < squaredDistance(c, ansiColors[best]) {
+				best = i
+			}
+		}
+		code := 30 + best
+		if best >= 8 {
+			code = 90 + best - 8
+		}
+		if background {
+			code += 10
+		}
+		return fmt.Sprint(code)
+
+	case Color256:
+		// The 6x6x6 color cube that starts at index 16.
+		levels := []int{0, 95, 135, 175, 215, 255}
+		nearest := func(value uint8) int {
+			best := 0
+			for i := range(levels) {
+				if abs(levels[i] - int(value)) < abs(levels[best] - int(value)) {
+					best = i
+				}
+			}
+			return best
+		}
+		index := 16 + 36 * nearest(c.r) + 6 * nearest(c.g) + nearest(c.b)
+		if background {
+			return fmt.Sprintf("48;5;%v", index)
+		}
+		return fmt.Sprintf("38;5;%v", index)
+	}
+
+	if background {
+		return fmt.Sprintf("48;2;%v;%v;%v", c.r, c.g, c.b)
+	}
+	return fmt.Sprintf("38;2;%v;%v;%v", c.r, c.g, c.b)
+}
+
+// Returns the heat map color for a fraction between 0 (blue) and 1 (red),
+// going around the color wheel by way of cyan, green and yellow.
+func heatColor(fraction float64) rgb {
+	fraction = math.Max(0, math.Min(1, fraction))
+	hue := (1 - fraction) * 240
+	sector := hue / 60
+	x := 1 - math.Abs(math.Mod(sector, 2) - 1)
+	var r, g, b float64
+	switch int(sector) {
+	case 0:
+		r, g, b = 1, x, 0
+	case 1:
+		r, g, b = x, 1, 0
+	case 2:
+		r, g, b = 0, 1, x
+	case 3:
+		r, g, b = 0, x, 1
+	default:
+		r, g, b = x, 0, 1
+	}
+	const brightness = 200
+	return rgb{uint8(r * brightness), uint8(g * brightness), uint8(b * brightness)}
+}
+
+// Performs a breadth-first search through the floor cells of the maze,
+// starting from the floor cells of the entrances.  Returns the number of
+// steps to every cell, indexed by cell offset, or -1 for cells that can't
+// be reached.
+//
+// Unlike roomDistances(), this works a character at a time, so it doesn't
+// care whether the rooms of a nested maze line up with one another.  The
+// search stays within the unit grid (see gridDimensions()), so that it
+// doesn't leak out of the entrance into any blank margin on the right or at
+// the bottom.
+func (m *Maze) cellDistances() []int {
+	return m.cellDistancesFrom(m.entrances...)
+}
+
+// Like cellDistances(), but starts from the floor cells in the given
+// rectangles.
+func (m *Maze) cellDistancesFrom(rects ...rect) []int {
+	distances := make([]int, m.cellCount())
+	for i := range(distances) {
+		distances[i] = -1
+	}
+	unitWidth, unitHeight := m.unitDimensions()
+	if unitWidth < 3 || unitHeight < 3 {
+		return distances
+	}
+	gridWidth, gridHeight := m.gridDimensions()
+
+	queue := []point{}
+	for _, r := range(rects) {
+		for row := r.y; row < r.y + r.height; row++ {
+			for column := r.x; column < r.x + r.width; column++ {
+				if m.valid(column, row) && m.cell(m.offset(column, row)) == m.floor && distances[m.offset(column, row)] < 0 {
+					distances[m.offset(column, row)] = 0
+					queue = append(queue, point{column, row})
+				}
+			}
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, d := range(directions) {
+			neighbor := point{x: current.x + d.x, y: current.y + d.y}
+			if neighbor.x < 0 || neighbor.y < 0 || neighbor.x >= gridWidth || neighbor.y >= gridHeight {
+				continue
+			}
+			offset := m.offset(neighbor.x, neighbor.y)
+			if distances[offset] < 0 && m.cell(offset) == m.floor {
+				distances[offset] = distances[m.offset(current.x, current.y)] + 1
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+	return distances
+}
+
+// Returns the cells along the shortest path from the entrance to the exit,
+// indexed by cell offset, given the distances from cellDistances().
+func (m *Maze) solutionCells(distances []int) []bool {
+	result := make([]bool, m.cellCount())
+	for _, p := range(m.solutionPath(distances)) {
+		result[m.offset(p.x, p.y)] = true
+	}
+	return result
+}
+
+// Returns the cells along the shortest path from any entrance to any exit,
+// in order, given the distances from cellDistances().  Returns nil if no
+// exit can be reached.
+func (m *Maze) solutionPath(distances []int) []point {
+	// Start from the exit cell nearest to an entrance.
+	current := point{-1, -1}
+	for _, exit := range(m.exits) {
+		for row := exit.y; row < exit.y + exit.height; row++ {
+			for column := exit.x; column < exit.x + exit.width; column++ {
+				if !m.valid(column, row) || distances[m.offset(column, row)] < 0 {
+					continue
+				}
+				if current.x < 0 || distances[m.offset(column, row)] < distances[m.offset(current.x, current.y)] {
+					current = point{column, row}
+				}
+			}
+		}
+	}
+	if current.x < 0 {
+		return nil
+	}
+
+	// Then walk downhill.
+	path := []point{}
+	for {
+		path = append(path, current)
+		distance := distances[m.offset(current.x, current.y)]
+		if distance == 0 {
+			break
+		}
+		for _, d := range(directions) {
+			neighbor := point{x: current.x + d.x, y: current.y + d.y}
+			if m.valid(neighbor.x, neighbor.y) && distances[m.offset(neighbor.x, neighbor.y)] == distance - 1 {
+				current = neighbor
+				break
+			}
+		}
+	}
+	slices.Reverse(path)
+	return path
+}
+
+// Options for WriteColor().
+type ColorOptions struct {
+	Mode ColorMode
+
+	// Highlights the shortest path from the entrance to the exit.
+	Solution bool
+
+	// Shades the floor by its distance from the entrance.
+	HeatMap bool
+}
+
+// Writes the maze with ANSI colors, using the box-drawing style if one has
+// been selected.  With a mode of NoColor, the output is the same as Print().
+func (m *Maze) WriteColor(w io.Writer, options ColorOptions) error {
+	cells := m.renderedCells()
+
+	var distances []int
+	var solution []bool
+	longestDistance := 0
+	if options.Mode != NoColor && (options.Solution || options.HeatMap) {
+		distances = m.cellDistances()
+		for _, distance := range(distances) {
+			longestDistance = max(longestDistance, distance)
+		}
+		if options.Solution {
+			solution = m.solutionCells(distances)
+		}
+	}
+
+	// There's no point in coloring a blank floor.
+	floorVisible := strings.TrimSpace(m.glyph(m.floor)) != ""
+
+	out := bufio.NewWriter(w)
+	current := ""
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			offset := m.offset(x, y)
+
+			// Work out the SGR parameters for this cell.
+			style := ""
+			switch c := m.cell(offset); {
+			case options.Mode == NoColor:
+				break
+			case c == m.fill:
+				style = defaultPalette.fill.sgr(options.Mode, false)
+			case c != m.floor:
+				style = defaultPalette.wall.sgr(options.Mode, false)
+			case inAnyRect(x, y, m.entrances):
+				style = defaultPalette.entrance.sgr(options.Mode, true)
+			case inAnyRect(x, y, m.exits):
+				style = defaultPalette.exit.sgr(options.Mode, true)
+			case solution != nil && solution[offset]:
+				style = defaultPalette.solution.sgr(options.Mode, true)
+			case options.HeatMap && distances[offset] >= 0:
+				style = heatColor(float64(distances[offset]) / float64(max(1, longestDistance))).sgr(options.Mode, true)
+			case floorVisible:
+				style = defaultPalette.floor.sgr(options.Mode, false)
+			}
+
+			if style != current {
+				if current != "" {
+					out.WriteString("\x1b[0m")
+				}
+				if style != "" {
+					fmt.Fprintf(out, "\x1b[%vm", style)
+				}
+				current = style
+			}
+			out.WriteString(cells[offset])
+		}
+		if current != "" {
+			out.WriteString("\x1b[0m")
+			current = ""
+		}
+		out.WriteString("\n")
+	}
+	return out.Flush()
+}
@@ -0,0 +1,202 @@
+package main
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Difficulty-targeted generation.
+//
+// As the comments in findEntranceAndExit() point out, a maze is at its most
+// challenging when the solution is a modest fraction of the maze and the rest
+// is devoted to long, misleading branches; a maze whose solution winds through
+// nearly every room takes a long time to traverse, but it is not difficult.
+// Rather than leaving that to chance, we can generate maze after maze from a
+// sequence of seeds until one of them has the statistics we want.
+
+// A requirement that one of the maze's metrics lie within [Min, Max].
+type Constraint struct {
+	Metric string
+	Min, Max float64
+}
+
+// Returns the value of the named metric.  The metric names are the JSON field
+// names of the Stats structure, plus a few ratios that do not depend as much
+// on the size of the maze:
+//
+// - deadEndShare:   The fraction of rooms that are dead ends.
+// - deadEndDepth:   The length of the longest dead end relative to the
+//                   length of the solution.
+// - turnShare:      The fraction of the solution's rooms where it turns.
+func metricValue(s Stats, metric string) (float64, bool) {
+	ratio := func(a, b int) float64 {
+		if b == 0 {
+			return 0
+		}
+		return float64(a) / float64(b)
+	}
+	switch metric {
+	case "rooms":
+		return float64(s.Rooms), true
+	case "deadEnds":
+		return float64(s.DeadEnds), true
+	case "junctions":
+		return float64(s.Junctions), true
+	case "riverFactor":
+		return s.RiverFactor, true
+	case "solutionLength":
+		return float64(s.SolutionLength), true
+	case "solutionShare":
+		return s.SolutionShare, true
+	case "solutionTurns":
+		return float64(s.SolutionTurns), true
+	case "longestDeadEnd":
+		return float64(s.LongestDeadEnd), true
+	case "deadEndShare":
+		return ratio(s.DeadEnds, s.Rooms), true
+	case "deadEndDepth":
+		return ratio(s.LongestDeadEnd, s.SolutionLength), true
+	case "turnShare":
+		return ratio(s.SolutionTurns, s.SolutionLength), true
+	}
+	return 0, false
+}
+
+// Named difficulty bands.  The solution's share of the maze shrinks as mazes
+// get larger, so these are expressed in terms of ratios that don't: with the
+// default wall lengths, the longest dead end typically measures 15-40% of the
+// solution, and the solution turns in about half of its rooms.  Roughly one
+// maze in four falls into each band.
+var difficultyPresets = map[string][]Constraint{
+	"easy": {
+		{Metric: "deadEndDepth", Min: 0, Max: 0.2},
+		{Metric: "turnShare", Min: 0, Max: 0.5},
+	},
+	"medium": {
+		{Metric: "deadEndDepth", Min: 0.2, Max: 0.35},
+	},
+	"hard": {
+		{Metric: "deadEndDepth", Min: 0.35, Max: math.Inf(1)},
+		{Metric: "turnShare", Min: 0.5, Max: 1},
+	},
+}
+
+// Returns the names of the difficulty presets in alphabetical order.
+func difficultyNames() []string {
+	names := []string{}
+	for name := range(difficultyPresets) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parses a constraint of the form "metric>=value", "metric<=value", or
+// "metric=value", e.g. "solutionLength>=40" or "deadEnds >= 20".
+func ParseConstraint(s string) (Constraint, error) {
+	for _, operator := range([]string{">=", "<=", "="}) {
+		i := strings.Index(s, operator)
+		if i < 0 {
+			continue
+		}
+		metric := strings.TrimSpace(s[:i])
+		if _, ok := metricValue(Stats{}, metric); !ok {
+			return Constraint{}, fmt.Errorf("unknown metric \"%v\"", metric)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(s[i + len(operator):]), 64)
+		if err != nil {
+			return Constraint{}, fmt.Errorf("could not parse the value in \"%v\": %v", s, err)
+		}
+		c := Constraint{Metric: metric, Min: math.Inf(-1), Max: math.Inf(1)}
+		switch operator {
+		case ">=":
+			c.Min = value
+		case "<=":
+			c.Max = value
+		default:
+			c.Min, c.Max = value, value
+		}
+		return c, nil
+	}
+	return Constraint{}, fmt.Errorf("\"%v\" is not of the form metric>=value, metric<=value, or metric=value", s)
+}
+
+func (c Constraint) String() string {
+	switch {
+	case c.Min == c.Max:
+		return fmt.Sprintf("%v=%v", c.Metric, c.Min)
+	case math.IsInf(c.Min, -1):
+		return fmt.Sprintf("%v<=%v", c.Metric, c.Max)
+	case math.IsInf(c.Max, 1):
+		return fmt.Sprintf("%v>=%v", c.Metric, c.Min)
+	}
+	return fmt.Sprintf("%v<=%v<=%v", c.Min, c.Metric, c.Max)
+}
+
+// Returns how far the given statistics are from satisfying the constraint,
+// relative to the size of the allowed range's nearest endpoint.  A distance of
+// 0 means that the constraint is satisfied.
+func (c Constraint) distance(s Stats) float64 {
+	value, _ := metricValue(s, c.Metric)
+	switch {
+	case value < c.Min:
+		return (c.Min - value) / math.Max(math.Abs(c.Min), 1)
+	case value > c.Max:
+		return (value - c.Max) / math.Max(math.Abs(c.Max), 1)
+	}
+	return 0
+}
+
+// Generates nested mazes (see GenerateNested()) from a sequence of seeds
+// derived from the given one until the result satisfies every constraint or
+// the attempt budget runs out.  The first attempt uses the seed itself, and
+// attempt n uses the seed with "/n" appended.
+//
+// Either way, the maze is left holding the attempt that came closest, and the
+// seed that reproduces it is available via m.Seed().  An error is returned if
+// none of the attempts satisfied all of the constraints.
+//
+// If the context is done before then, the search stops and the context's
+// error is returned instead.  The maze holds the closest attempt so far, if
+// any attempt was finished.
+func (m *Maze) GenerateWithConstraints(ctx context.Context, thicknessValues []int, seed string, constraints []Constraint, attempts int) (attemptsUsed int, err error) {
+
+	// SetSeed() picks a seed for us if we weren't given one, and we need
+	// it to derive the others.
+	m.SetSeed(seed)
+	seed = m.Seed()
+
+	var best Maze
+	bestDistance := math.Inf(1)
+	for attempt := 0; attempt < max(1, attempts); attempt++ {
+		candidateSeed := seed
+		if attempt > 0 {
+			candidateSeed = fmt.Sprintf("%v/%v", seed, attempt)
+		}
+		m.SetSeed(candidateSeed)
+		if err := m.GenerateNestedContext(ctx, thicknessValues); err != nil && ctx.Err() != nil {
+			if attempt > 0 {
+				*m = best
+			}
+			return attempt, err
+		}
+
+		stats := m.Stats()
+		distance := 0.0
+		for _, c := range(constraints) {
+			distance += c.distance(stats)
+		}
+		if distance == 0 {
+			return attempt + 1, nil
+		}
+		if distance < bestDistance {
+			best, bestDistance = NewMazeOverExisting(*m), distance
+		}
+	}
+
+	*m = best
+	return max(1, attempts), fmt.Errorf("none of the %v mazes that were generated satisfied %v", max(1, attempts), constraints)
+}
@@ -0,0 +1,403 @@
+package main
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"github.com/akamensky/argparse"
+)
+
+// Dungeons.
+//
+// GenerateDungeon() builds a roguelike level out of the same pieces as a
+// maze, so that a level can be reproduced from its seed just like a maze:
+//
+//   1. Rooms of random sizes are scattered over the grid, each one reserved
+//      as a region (see regions.go).  They never share a wall, so there is
+//      always a corridor between two rooms, and a room that would cut the
+//      rest of the level in two is skipped.
+//   2. The maze is generated around them, which fills all of the space that
+//      is left with corridors.
+//   3. Every room gets a door into the corridors, which are all one piece,
+//      so the rooms and the corridors form a spanning tree of the level.
+//      Each room then has a chance of getting extra doors, which make loops.
+//   4. Optionally, the corridors' dead ends are filled in, one room of the
+//      grid at a time, until every corridor leads to a door, an entrance or
+//      an exit.
+//
+// Any regions that were reserved beforehand (such as a fixed boss room) stay
+// where they are, and the random rooms go around them.  The level is written
+// as text like any other maze, or as a DungeonDocument: the maze's JSON form
+// (see json.go) with a list of the rooms added to it.
+
+// The parameters of a dungeon.
+type DungeonOptions struct {
+	// The number of rooms to place.  There may be fewer if they don't fit.
+	Rooms int
+
+	// The range of the rooms' widths and heights, in rooms of the maze's
+	// grid, so that the rooms line up with the corridors.
+	MinRoomSize, MaxRoomSize int
+
+	// The chance that a room gets another door, checked again after each
+	// door that it gets, up to four in all.
+	ExtraDoorChance float64
+
+	// Whether to fill in the dead ends of the corridors.
+	RemoveDeadEnds bool
+}
+
+// The number of places to try for each room before giving up on it.
+const dungeonTriesPerRoom = 50
+
+// Returns an error if the options don't make sense.
+func (o DungeonOptions) check() error {
+	switch {
+	case o.Rooms < 0:
+		return errors.New("the number of rooms can't be negative")
+	case o.MinRoomSize < 1 || o.MaxRoomSize < o.MinRoomSize:
+		return fmt.Errorf("the room sizes %v to %v are not a valid range", o.MinRoomSize, o.MaxRoomSize)
+	case o.ExtraDoorChance < 0 || o.ExtraDoorChance >= 1:
+		return errors.New("the chance of an extra door must be at least 0 and less than 1")
+	}
+	return nil
+}
+
+// A room of a dungeon, as it was placed.
+type DungeonRoom struct {
+	Name string                  `json:"name"`
+
+	// The open floor inside the room's walls.  (For a reserved region with
+	// a mask, this is the rectangle around it.)
+	X int                        `json:"x"`
+	Y int                        `json:"y"`
+	Width int                    `json:"width"`
+	Height int                   `json:"height"`
+
+	Doors []MazeRect             `json:"doors"`
+}
+
+// The JSON form of a dungeon: the maze, plus its rooms.
+type DungeonDocument struct {
+	MazeDocument
+	Rooms []DungeonRoom          `json:"rooms"`
+}
+
+// Erases the maze and generates a dungeon (see above) with the maze's
+// current thickness.  The rooms are added to the reserved regions, where they
+// stay until ClearRegions() is called.  Returns the same errors as
+// GenerateContext(), or an error if the options are invalid or the regions
+// that were already reserved can't be placed.
+func (m *Maze) GenerateDungeon(ctx context.Context, options DungeonOptions) error {
+	if err := options.check(); err != nil {
+		return err
+	}
+	thickness := max(1, m.thickness)
+	m.thickness = thickness
+	m.Clear()
+	if err := m.placeDungeonRooms(options); err != nil {
+		return err
+	}
+	if err := m.GenerateNestedContext(ctx, []int{thickness}); err != nil {
+		return err
+	}
+	if options.RemoveDeadEnds {
+		m.log().Info("filled dead ends", "rooms", m.removeDeadEnds())
+	}
+	return nil
+}
+
+// Reserves randomly placed rooms on the current grid, around the regions that
+// are already reserved.
+func (m *Maze) placeDungeonRooms(options DungeonOptions) error {
+	blocked := map[point]bool{}
+	for _, r := range(m.regions) {
+		for unit := range(regionUnits(m.regionRooms(r))) {
+			blocked[unit] = true
+		}
+	}
+	if len(m.regions) > 0 {
+		if err := m.checkFreeRooms(blocked); err != nil {
+			return err
+		}
+	}
+
+	unitWidth, unitHeight := m.unitDimensions()
+	columns, rows := (unitWidth - 1) / 2, (unitHeight - 1) / 2
+	placed := 0
+	for try := 0; try < options.Rooms * dungeonTriesPerRoom && placed < options.Rooms; try++ {
+		width := options.MinRoomSize + m.random.Intn(options.MaxRoomSize - options.MinRoomSize + 1)
+		height := options.MinRoomSize + m.random.Intn(options.MaxRoomSize - options.MinRoomSize + 1)
+		if width > columns || height > rows {
+			continue
+		}
+		column, row := m.random.Intn(columns - width + 1), m.random.Intn(rows - height + 1)
+
+		candidate := []point{}
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				candidate = append(candidate, point{2 * (column + x) + 1, 2 * (row + y) + 1})
+			}
+		}
+		units := regionUnits(candidate)
+		overlaps := false
+		for unit := range(units) {
+			overlaps = overlaps || blocked[unit]
+		}
+		if overlaps {
+			continue
+		}
+		for unit := range(units) {
+			blocked[unit] = true
+		}
+		if m.checkFreeRooms(blocked) != nil {
+			for unit := range(units) {
+				delete(blocked, unit)
+			}
+			continue
+		}
+
+		doors := 1
+		for doors < 4 && m.random.Float64() < options.ExtraDoorChance {
+			doors++
+		}
+		placed++
+		bounds := m.roomsInterior(candidate)
+		m.Reserve(Region{
+			Kind: "room",
+			Name: fmt.Sprintf("room%v", placed),
+			X: bounds.x,
+			Y: bounds.y,
+			Width: bounds.width,
+			Height: bounds.height,
+			Doors: doors,
+		})
+	}
+	if placed < options.Rooms {
+		m.log().Warn("not enough room for the rooms", "rooms", placed, "requested", options.Rooms)
+	}
+	return nil
+}
+
+// Returns the rectangle of open floor that the given rooms of the grid make
+// once the walls between them are cleared out.
+func (m *Maze) roomsInterior(rooms []point) rect {
+	interior := map[point]bool{}
+	units := regionUnits(rooms)
+	for unit := range(units) {
+		if surrounded(units, unit) {
+			interior[unit] = true
+		}
+	}
+	bounds, _ := m.unitCells(interior)
+	if m.thickness > 2 {
+		// The interior shares its outermost cells with the ring of
+		// walls.
+		bounds = rect{bounds.x + 1, bounds.y + 1, bounds.width - 2, bounds.height - 2}
+	}
+	return bounds
+}
+
+// Fills in the dead ends of the corridors, and the dead ends that doing so
+// leaves, until there are none.  The reserved regions, the rooms that lead
+// into them and the entrances and exits are left alone.  Returns the number
+// of rooms of the grid that were filled.
+func (m *Maze) removeDeadEnds() int {
+	reserved := map[point]bool{}
+	for _, r := range(m.regions) {
+		for unit := range(regionUnits(m.regionRooms(r))) {
+			reserved[unit] = true
+		}
+	}
+	filled, filledCells := 0, map[point]bool{}
+	for queue := m.rooms(); len(queue) > 0; queue = queue[1:] {
+		room := queue[0]
+		if reserved[room] || !m.isRoom(room) || m.roomDegree(room) != 1 || m.goalKind(room) != "" {
+			continue
+		}
+		for i, d := range(directions) {
+			next := point{room.x + 2 * d.x, room.y + 2 * d.y}
+			if !m.hasDoorway(room, i) || reserved[next] {
+				continue
+			}
+			units := map[point]bool{room: true, {room.x + d.x, room.y + d.y}: true}
+			if m.thickness == 1 {
+				// The walls are redrawn all at once below.
+				for unit := range(units) {
+					m.setCell(m.offset(unit.x, unit.y), m.fill)
+					filledCells[unit] = true
+				}
+			} else {
+				m.drawUnits(units)
+			}
+			queue = append(queue, next)
+			filled++
+		}
+	}
+	if len(filledCells) > 0 {
+		// Redraw the walls in and around the corridors that were
+		// filled in as one block, so that they become solid, outlined
+		// like the rest of the walls.
+		bounds, _ := m.unitCells(filledCells)
+		bounds = rect{bounds.x - 1, bounds.y - 1, bounds.width + 2, bounds.height + 2}
+		m.drawBlock(bounds, func(x, y int) bool {
+			if !m.valid(x, y) || m.cell(m.offset(x, y)) == m.floor {
+				return false
+			}
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if filledCells[point{x + dx, y + dy}] {
+						return true
+					}
+				}
+			}
+			return false
+		})
+	}
+	return filled
+}
+
+// Returns the rooms among the reserved regions, with the doors that the last
+// generation cut into them.
+func (m *Maze) DungeonRooms() []DungeonRoom {
+	result := []DungeonRoom{}
+	for i, r := range(m.regions) {
+		if r.Kind != "room" {
+			continue
+		}
+		bounds := m.roomsInterior(m.regionRooms(r))
+		room := DungeonRoom{
+			Name: r.Name,
+			X: bounds.x,
+			Y: bounds.y,
+			Width: bounds.width,
+			Height: bounds.height,
+			Doors: []MazeRect{},
+		}
+		for _, door := range(m.RegionDoors(i)) {
+			room.Doors = append(room.Doors, mazeRect(door))
+		}
+		result = append(result, room)
+	}
+	return result
+}
+
+// Returns the JSON form of the dungeon, with or without the maze's solution
+// and statistics (as for Document().)
+func (m *Maze) DungeonDocument(solution, stats bool) DungeonDocument {
+	return DungeonDocument{
+		MazeDocument: m.Document(solution, stats),
+		Rooms: m.DungeonRooms(),
+	}
+}
+
+// Writes the dungeon as an indented DungeonDocument, with its solution and
+// statistics.
+func (m *Maze) WriteDungeonJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m.DungeonDocument(true, true))
+}
+
+func dungeonMain(args []string) {
+	config, err := LoadConfig(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read the configuration file: %v.\n", err)
+		os.Exit(1)
+	}
+	parser := argparse.NewParser("maze dungeon", "Generates a roguelike level: rooms, with the space between them filled by maze corridors, and doors connecting the two.  The level is reproducible from its --seed, like a maze.  Rooms given with --room are kept, and the random rooms are placed around them.  The other arguments are the same as for \"maze\", except that there can be only one --thickness.")
+	arguments := addMazeArguments(parser, config)
+	var roomCount *int = parser.Int("", "room-count", &argparse.Options{
+		Required: false,
+		Help: "The number of random rooms to place.  There may be fewer if they don't fit",
+		Default: 8,
+	})
+	var minRoomSize *int = parser.Int("", "min-room-size", &argparse.Options{
+		Required: false,
+		Help: "The smallest width or height of a random room, in rooms of the maze's grid",
+		Default: 2,
+	})
+	var maxRoomSize *int = parser.Int("", "max-room-size", &argparse.Options{
+		Required: false,
+		Help: "The largest width or height of a random room, in rooms of the maze's grid",
+		Default: 4,
+	})
+	var extraDoors *float64 = parser.Float("", "extra-doors", &argparse.Options{
+		Required: false,
+		Help: "The chance that a room gets another door beyond its first, checked again after each one (up to four doors in all)",
+		Default: 0.3,
+	})
+	var removeDeadEnds *bool = parser.Flag("", "remove-dead-ends", &argparse.Options{
+		Required: false,
+		Help: "Fills in the corridors' dead ends, so that every corridor leads to a door, entrance or exit",
+	})
+	var printJSON *bool = parser.Flag("", "json", &argparse.Options{
+		Required: false,
+		Help: "Prints the dungeon as JSON instead of as text: the same document as \"maze --json\", plus a \"rooms\" list with each room's name, floor rectangle and doors",
+	})
+
+	err = parser.Parse(args)
+	if err != nil {
+		fmt.Print(parser.Usage(err))
+		return
+	}
+	m, ok := arguments.newMaze(parser)
+	if !ok {
+		return
+	}
+	options := DungeonOptions{
+		Rooms: *roomCount,
+		MinRoomSize: *minRoomSize,
+		MaxRoomSize: *maxRoomSize,
+		ExtraDoorChance: *extraDoors,
+		RemoveDeadEnds: *removeDeadEnds,
+	}
+	ok = false
+	switch err := options.check(); {
+	case len(arguments.thicknessValues) > 1:
+		fmt.Fprintf(os.Stderr, "A dungeon can only have one --thickness.\n")
+	case len(arguments.constraints) > 0:
+		fmt.Fprintf(os.Stderr, "The --difficulty and --require options don't apply to dungeons.\n")
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Could not use the room options: %v.\n", err)
+	default:
+		ok = true
+	}
+	if !ok {
+		fmt.Print(parser.Usage(nil))
+		return
+	}
+
+	ctx := context.Background()
+	if arguments.timeoutValue > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, arguments.timeoutValue)
+		defer cancel()
+	}
+	m.log().Info("starting", "seed", m.Seed(), "seedValue", seedValue(m.Seed()), "thickness", m.thickness)
+	err = m.GenerateDungeon(ctx, options)
+	switch {
+	case err == nil:
+		break
+	case errors.Is(err, ErrIncomplete):
+		fmt.Fprintf(os.Stderr, "Warning: %v; there was no room for an entrance and exit.\n", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Fprintf(os.Stderr, "Gave up after %v (see --timeout.)\n", arguments.timeoutValue)
+		os.Exit(1)
+	default:
+		fmt.Fprintf(os.Stderr, "Could not generate the dungeon: %v.\n", err)
+		os.Exit(1)
+	}
+
+	if *printJSON {
+		err = m.WriteDungeonJSON(os.Stdout)
+	} else {
+		err = m.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not write the dungeon: %v.\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+// Generation events.
+//
+// If the maze has an event handler (see SetEventHandler()), Generate() calls
+// it as the maze takes shape: once for the border, once for every wall it
+// places, and once each for the entrance and the exit.  Reserved regions (see
+// regions.go) are reported when they're drawn and when rooms are opened up.
+// GenerateNested() also reports the passages that it opens up between the
+// passes.  The handler is called after the cells have been updated, so it
+// can look at (or draw) the maze as it stands.
+//
+// Handlers must not modify the maze, and must not use its random number
+// generator; the maze is the same whether or not anyone is listening.
+
+type EventKind int
+const (
+	// The ring of walls around the outside of the maze.
+	BorderEvent EventKind = iota
+
+	// A wall.  The rectangle covers the whole wall.
+	WallEvent
+
+	// The entrance and exit.  The rectangle is the opening.
+	EntranceEvent
+	ExitEvent
+
+	// A passage cut between two passes of a nested maze to reconnect
+	// part of it (see connectPockets().)  The rectangle bounds the
+	// passage.
+	PassageEvent
+
+	// A reserved region: once when it is drawn as a solid block, and again
+	// for a room once it has been cleared out and its doors cut.  The
+	// rectangle bounds the region and its walls.
+	RegionEvent
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case BorderEvent:
+		return "border"
+	case WallEvent:
+		return "wall"
+	case EntranceEvent:
+		return "entrance"
+	case ExitEvent:
+		return "exit"
+	case PassageEvent:
+		return "passage"
+	case RegionEvent:
+		return "region"
+	}
+	return "unknown"
+}
+
+// Something that happened during generation.
+type Event struct {
+	Kind EventKind
+
+	// The maze that is being generated, and the thickness of the pass
+	// that is generating it.
+	Maze *Maze
+	Thickness int
+
+	// The cells affected, in character coordinates.
+	X, Y, Width, Height int
+
+	// The number of walls placed so far in this pass, including this
+	// event.
+	Walls int
+}
+
+// Sets the function that Generate() reports its progress to, or nil for
+// none.
+func (m *Maze) SetEventHandler(handler func(Event)) {
+	m.eventHandler = handler
+}
+
+// Reports an event to the handler, if there is one.
+func (m *Maze) emit(kind EventKind, x, y, width, height, walls int) {
+	if m.eventHandler == nil {
+		return
+	}
+	m.eventHandler(Event{
+		Kind: kind,
+		Maze: m,
+		Thickness: m.thickness,
+		X: x,
+		Y: y,
+		Width: width,
+		Height: height,
+		Walls: walls,
+	})
+}
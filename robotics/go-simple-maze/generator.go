@@ -0,0 +1,807 @@
+package main
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// The maze generator, as a state machine.
+//
+// Generate() and GenerateNested() run a Generator to completion, but a caller
+// that can't afford to block (a game loop, say) can run it a step at a time:
+//
+//   g := m.Generator()
+//   for g.Step() {
+//       // Look at m, draw it, wait for the next frame...
+//   }
+//
+// Each step does one visible thing: it draws the border of a pass, places a
+// single wall, cuts the entrance and exit, or (for nested mazes) reconnects
+// whatever the passes sealed off.  Between steps, State() captures
+// everything that's needed to pick up where the generator left off, even
+// in another process; see ResumeGenerator().
+//
+// Step() also returns false if the generator's context (see SetContext()) is
+// cancelled or runs out of time, even in the middle of the search for the
+// entrance and exit.  Err() then says why it stopped.
+
+type generatorPhase int
+const (
+	startingPass generatorPhase = iota
+	placingWalls
+	cuttingOpenings
+	repairing
+	finished
+)
+
+// Returned by Err() when the generator finished without cutting an entrance
+// and exit, usually because the maze is too small for its thickness.
+var ErrIncomplete = errors.New("the maze could not be completed")
+
+var horizontalDirections = []struct {x, y int}{directions[0], directions[2]}
+var verticalDirections = []struct {x, y int}{directions[1], directions[3]}
+
+type Generator struct {
+	m *Maze
+	phase generatorPhase
+
+	// The thickness of each pass, and the current pass.  Nested mazes
+	// are repaired after the last pass.
+	thicknessValues []int
+	pass int
+	nested bool
+
+	// The state of the current pass.
+	unitWidth, unitHeight int
+	biasHorizontal bool
+	numberOfUnoccupiedUnits int
+	wallCount int
+	unbiased bool
+
+	// The walls that could be started next, by direction, or nil if they
+	// haven't been worked out yet.  (See candidates.go.)  These aren't
+	// saved in the state, since they follow from the cells.
+	candidates []*weightedSet
+
+	// True once any pass has cut an entrance and exit.
+	opened bool
+
+	// True if the border should be left closed, without an entrance and
+	// exit; see SetClosed().
+	closed bool
+
+	// The thickness of the pass that drew the reserved regions, or 0 if
+	// that hasn't happened yet; see regions.go.
+	regionThickness int
+
+	ctx context.Context
+	err error
+}
+
+// Returns a generator that does the same thing as Generate().
+func (m *Maze) Generator() *Generator {
+	return &Generator{m: m, thicknessValues: []int{m.thickness}}
+}
+
+// Returns a generator that does the same thing as GenerateNested().  Like
+// GenerateNested(), this erases the maze.
+func (m *Maze) NestedGenerator(thicknessValues []int) *Generator {
+	m.Clear()
+	return &Generator{
+		m: m,
+		thicknessValues: append([]int{}, thicknessValues...),
+		nested: len(thicknessValues) > 1,
+	}
+}
+
+// Returns the maze that the generator is working on.
+func (g *Generator) Maze() *Maze { return g.m; }
+
+// Returns true once the maze is complete.
+func (g *Generator) Done() bool { return g.phase == finished; }
+
+// Sets a context that stops the generator when it is done, or nil for none.
+// A stopped generator can still be saved with State() and resumed later.
+func (g *Generator) SetContext(ctx context.Context) {
+	g.ctx = ctx
+}
+
+// Tells the generator to leave the maze's border closed instead of cutting an
+// entrance and exit.  Whoever asked for that is expected to cut their own
+// openings (see chunks.go.)  This also skips the search for the entrance and
+// exit, which is the slowest part of generating a large maze.
+func (g *Generator) SetClosed(closed bool) {
+	g.closed = closed
+}
+
+// Returns the reason that Step() returned false: the context's error if it
+// stopped the generator, ErrIncomplete if the maze was finished without an
+// entrance and exit, and nil otherwise.
+func (g *Generator) Err() error { return g.err; }
+
+// Returns true (and records the error) if the generator's context is done.
+func (g *Generator) stopped() bool {
+	if g.ctx == nil {
+		return false
+	}
+	if err := g.ctx.Err(); err != nil {
+		g.err = err
+		return true
+	}
+	return false
+}
+
+// Performs the next step of generation.  Returns false if there was nothing
+// left to do, or if the generator was stopped; see Err().
+func (g *Generator) Step() bool {
+	g.err = nil
+	for {
+		if g.phase != finished && g.stopped() {
+			return false
+		}
+		switch g.phase {
+		case startingPass:
+			switch {
+			case g.pass < len(g.thicknessValues):
+				if g.startPass() {
+					return true
+				}
+				if g.err != nil {
+					return false
+				}
+				// The maze was too small for this pass.
+				g.pass++
+			case !g.openRegions():
+				return false
+			case g.nested:
+				g.phase = repairing
+			default:
+				g.phase = finished
+			}
+		case placingWalls:
+			if g.placeWall() {
+				return true
+			}
+			if g.err != nil {
+				return false
+			}
+			g.phase = cuttingOpenings
+		case cuttingOpenings:
+			if g.pass == len(g.thicknessValues) - 1 && !g.openRegions() {
+				return false
+			}
+			if !g.closed {
+				if g.cutOpenings(); g.err != nil {
+					return false
+				}
+			}
+			g.pass++
+			g.phase = startingPass
+			return true
+		case repairing:
+			if !g.m.connectPockets(g.stopped) {
+				return false
+			}
+			g.phase = finished
+			return true
+		case finished:
+			if !g.opened && !g.closed {
+				g.err = ErrIncomplete
+			}
+			return false
+		}
+	}
+}
+
+// If we need to bias the walls of a square maze due to minWallLength being
+// large (so as to make it unicursal), then we choose the direction at random
+// at the start of each pass.
+func (g *Generator) unicursalBiasDirections() []struct {x, y int} {
+	if g.biasHorizontal {
+		return horizontalDirections
+	}
+	return verticalDirections
+}
+
+// Begins the next pass: draws the border (and, on the first pass, the
+// reserved regions) and counts the units that the walls have yet to reach.
+// Returns false if the maze is too small for the pass, or if the regions
+// couldn't be drawn (see Err().)
+func (g *Generator) startPass() bool {
+	m := g.m
+	m.thickness = g.thicknessValues[g.pass]
+	m.thicknessValues = g.thicknessValues[:g.pass + 1]
+	if m.thickness < 1 {
+		m.thickness = 1
+	}
+	g.biasHorizontal = m.random.Intn(2) > 0
+	g.wallCount, g.unbiased, g.candidates = 0, false, nil
+
+	g.unitWidth, g.unitHeight = m.unitDimensions()
+	if g.unitWidth < 3 || g.unitHeight < 3 {
+		// Too small to hold even a single corridor.
+		return false
+	}
+
+	// Draw a ring of walls around the maze.
+	//
+	// TODO: We shouldn't overwrite the border where it already exists,
+	// and that will necessitate different algorithms for thicknesses of 1
+	// and >1.
+	if m.thickness == 1 {
+		// For a thickness of 1, this looks better than a bunch of
+		// intersections.
+		m.drawRect(0, 0, g.unitWidth, g.unitHeight, m.floor)
+	} else {
+		// Iterate over the outer perimeter of the maze.
+		for _, p := range(rectPerimeter(g.unitWidth, g.unitHeight)) {
+			x, y, width, height := m.unitCoordinatesToRect(p.x, p.y)
+			m.drawRect(x, y, width, height, m.fill)
+
+		}
+	}
+	gridWidth, gridHeight := m.gridDimensions()
+	m.emit(BorderEvent, 0, 0, gridWidth, gridHeight, 0)
+	if len(m.regions) > 0 && g.regionThickness == 0 && !g.reserveRegions() {
+		return false
+	}
+
+	// The actual maze algorithm.
+	//
+	// 1. Consider only the odd coordinates of the maze.  Record which are
+	//    completely unoccupied and which are not.
+	// 2. Select, at random, an odd coordinate that is NOT completely
+	//    unoccupied, along with a cardinal direction in which a line can
+	//    be drawn from it (recall that a "unit" is a TxT square of cells,
+	//    where T is m.thickness.)  See candidates.go.
+	// 3. Draw the line.  It may have any odd length as long as it is
+	//    greater than 3 and it does not touch any other occupied units.
+	// 4. Mark the odd coordinates that the line touched as occupied.
+	// 5. If there is still at least one unoccupied coordinate, return to
+	//    step 2.
+	// 6. Punch holes through the outside walls at two randomly-chosen points
+	//    (preferably facing in different cardinal directions) to
+	//    represent to entrance and exit.
+
+	// STEP 1
+	//
+	// Count the number of odd coordinates in the map that are completely
+	// unoccupied.  Those are our targets -- where we wish to draw the
+	// maze.
+	//
+	// Suppose our thickness is 1 and we have an existing cells buffer
+	// that looks like this (clearly the result of this maze renderer's
+	// previous handiwork):
+	//
+	// +--+  +--+--+--+
+	// |..|  |..|..|..|
+	// |..|  |..|..|..|
+	// +--+  +--+--+--+
+	// |..|        |..|
+	// |..|        |..|
+	// +--+--+--+  +--+
+	// |..|..|..|  |..|
+	// |..|..|..|  |..|
+	// +--+--+--+  +--+
+	//
+	// From the perspective of the present rendering algorithm, here is
+	// the coordinate analysis:
+	//
+	//    1 2 3 4 5 6 7 8 9 10111213141516
+	//  1 * * * * .   * * * * * * * * * *
+	//  2 * * * *     * * * * * * * * * *
+	//  3 * * * * .   * * * * * * * * * *
+	//  4 * * * *     * * * * * * * * * *
+	//  5 * * * * .   .   .   .   * * * *
+	//  6 * * * *                 * * * *
+	//  7 * * * * * * * * * * .   * * * *
+	//  8 * * * * * * * * * *     * * * *
+	//  9 * * * * * * * * * * .   * * * *
+	// 10 * * * * * * * * * *     * * * *
+	//
+	// Since only odd coordinates are considered, there are 3 + 2 + 3 = 8
+	// available spaces.
+	//
+	// But drawing a line from (say) (7, 11) to (5, 11) would seal off the
+	// northwest corridor and instantly ruin the maze.  As it turns out,
+	// any unit cell without at least three liberties -- three places to
+	// draw walls to -- is unusable by the maze algorithm.  All eight of the
+	// available cells in this maze have either two liberties or one, so
+	// this maze is actually complete with 0 available spaces.
+
+	g.numberOfUnoccupiedUnits = 0
+	for unitRow := 0; unitRow < g.unitHeight; unitRow += 2 {
+		for unitColumn := 0; unitColumn < g.unitWidth; unitColumn += 2 {
+			x, y, width, height := m.unitCoordinatesToRect(unitColumn, unitRow)
+			if m.rectContains(x, y, width, height, m.floor) {
+				g.numberOfUnoccupiedUnits += 1
+			}
+		}
+	}
+	g.logOccupiedUnitsDebug()
+	g.phase = placingWalls
+	return true
+}
+
+// Helper routine for step 3.
+//
+// Returns true if a wall that has reached the given unit coordinate can be
+// extended by two more units in the given direction: both the unit in
+// between and the one after it must be clear.
+//
+// The unit in between never contains anything in a maze of a single
+// thickness, but when drawing over a thicker maze, it can overlap the edge of
+// an existing wall.  Drawing through it would then connect two walls and seal
+// off whatever lay between them.  (For thicknesses greater than 2, the
+// borders it shares with its neighbors along the direction of travel are
+// excluded, since those belong to the wall we are extending.)
+func (g *Generator) canExtend(unitColumn, unitRow, vx, vy int) bool {
+	m := g.m
+	nextUnitColumn, nextUnitRow := unitColumn + 2 * vx, unitRow + 2 * vy
+	if nextUnitColumn < 0 || nextUnitRow < 0 || nextUnitColumn >= g.unitWidth || nextUnitRow >= g.unitHeight {
+		return false
+	}
+	x, y, width, height := m.unitCoordinatesToRect(unitColumn + vx, unitRow + vy)
+	if m.thickness > 2 {
+		if vx != 0 {
+			x, width = x + 1, width - 2
+		} else {
+			y, height = y + 1, height - 2
+		}
+	}
+	if !m.rectContains(x, y, width, height, m.floor) {
+		return false
+	}
+	x, y, width, height = m.unitCoordinatesToRect(nextUnitColumn, nextUnitRow)
+	return m.rectContains(x, y, width, height, m.floor)
+}
+
+// Another helper routine.
+//
+// Logs a minimal representation of the status of occupied and unoccupied
+// unit rectangles in the maze, one string per row of units: '.' for
+// unoccupied, '#' for occupied.  I only use this as a sanity check for
+// myself.
+func (g *Generator) logOccupiedUnitsDebug() {
+	m := g.m
+	if !m.logs(slog.LevelDebug) {
+		return
+	}
+	rows := []string{}
+	for unitRow := 0; unitRow < g.unitHeight; unitRow += 2 {
+		var row strings.Builder
+		for unitColumn := 0; unitColumn < g.unitWidth; unitColumn += 2 {
+			x, y, width, height := m.unitCoordinatesToRect(unitColumn, unitRow)
+			if m.rectContains(x, y, width, height, m.floor) {
+				row.WriteByte('.')
+			} else {
+				row.WriteByte('#')
+			}
+		}
+		rows = append(rows, row.String())
+	}
+	m.log().Debug("pass",
+		"thickness", m.thickness,
+		"unitWidth", g.unitWidth,
+		"unitHeight", g.unitHeight,
+		"unoccupiedUnits", g.numberOfUnoccupiedUnits,
+		"units", rows)
+}
+
+// Steps 2 through 5: places the next wall.  Returns false if the maze is full
+// (or if no more walls will fit.)
+func (g *Generator) placeWall() bool {
+	m := g.m
+	if g.numberOfUnoccupiedUnits <= 0 {
+		return false
+	}
+	if g.candidates == nil {
+		g.indexCandidates()
+	}
+	minWallLength, maxWallLength := g.wallLengthRange()
+
+	// STEP 2
+	//
+	// Select a random occupied unit from which to begin, and a direction
+	// in which a wall will fit (see candidates.go.)
+	//
+	// If longer walls are to be had in one orientation, bias the
+	// "random" directions in favor of that orientation.
+	allowed := directions
+	switch {
+	case g.unbiased:
+		break
+	case minWallLength > g.unitWidth - 2 && minWallLength > g.unitHeight - 2 && g.unitWidth == g.unitHeight:
+		// Bias the maze in a consistent set of directions that were
+		// chosen in advance.
+		allowed = g.unicursalBiasDirections()
+	case minWallLength > g.unitHeight - 2 && (minWallLength < g.unitWidth - 2 || g.unitWidth > g.unitHeight):
+		// Bias the maze in the horizontal direction.
+		allowed = horizontalDirections
+	case minWallLength > g.unitWidth - 2 && (minWallLength < g.unitHeight - 2 || g.unitHeight > g.unitWidth):
+		// Bias the maze in the vertical direction.
+		allowed = verticalDirections
+	}
+	if len(allowed) < len(directions) {
+		// Walls in the biased directions can run out (or become so
+		// scarce that a random unit and direction would almost never
+		// find one), and when the old random tries kept missing for
+		// long enough, they gave up on the bias for the rest of the
+		// pass.  The chance of that is the chance of missing that many
+		// times in a row.
+		tries := 101 + 4 * g.unitWidth * g.unitHeight
+		hitChance := float64(g.totalWeight(allowed)) / float64(g.evenUnits() * len(allowed) * fittingWallWeight)
+		if m.random.Float64() < math.Pow(1 - hitChance, float64(tries)) {
+			m.log().Debug("giving up on the bias", "thickness", m.thickness, "walls", g.wallCount)
+			g.unbiased = true
+			allowed = directions
+		}
+	}
+	total := g.totalWeight(allowed)
+	if total == 0 {
+		m.log().Debug("no more walls will fit", "thickness", m.thickness, "unoccupiedUnits", g.numberOfUnoccupiedUnits, "walls", g.wallCount)
+		return false
+	}
+	r := m.random.Intn(total)
+	var direction int
+	for direction = range(directions) {
+		if !slices.Contains(allowed, directions[direction]) {
+			continue
+		}
+		if r < g.candidates[direction].total {
+			break
+		}
+		r -= g.candidates[direction].total
+	}
+	unit := g.candidates[direction].find(r)
+	unitRow, unitColumn := 2 * (unit / (g.unitWidth / 2 + 1)), 2 * (unit % (g.unitWidth / 2 + 1))
+	vx, vy := directions[direction].x, directions[direction].y
+	x, y, width, height := m.unitCoordinatesToRect(unitColumn, unitRow)
+
+	// STEP 3
+	//
+	// How long a wall could we draw?  (At least three units, counting the
+	// starting position, or this direction wouldn't have been chosen.)
+	potentialWallLength := g.potentialWallLength(unitColumn, unitRow, vx, vy, 0)
+
+	// Now that we know how long a wall we can draw, we choose the actual
+	// length at random.
+	minWallLength = min(potentialWallLength, max(3, minWallLength)) // 3 <= minWallLength <= potentialWallLength
+	maxWallLength = min(potentialWallLength, max(3, maxWallLength)) // 3 <= maxWallLength <= potentialWallLength
+
+	// This produces a random odd number between minWallLength and maxWallLength.
+	wallLength := minWallLength + 2 * m.random.Intn((maxWallLength - minWallLength) / 2 + 1)
+
+	m.log().Debug("wall",
+		unitAttr("from", unitColumn, unitRow),
+		unitAttr("to", unitColumn + vx * (wallLength - 1), unitRow + vy * (wallLength - 1)),
+		"length", wallLength,
+		"potentialLength", potentialWallLength,
+		"minLength", minWallLength,
+		"maxLength", maxWallLength,
+		"walls", g.wallCount + 1)
+
+	// Draw the wall.
+	if m.thickness == 1 {
+		x1, y1, _, _ := m.unitCoordinatesToRect(unitColumn, unitRow)
+		x2, y2 := x1 + vx * (wallLength - 1), y1 + vy * (wallLength - 1)
+
+		// Force (x1, y1) to be the upper left corner of the rectangle.
+		x1, y1, x2, y2 = min(x1, x2), min(y1, y2), max(x1, x2), max(y1, y2)
+
+		m.drawRect(x1, y1, x2 - x1 + 1, y2 - y1 + 1, m.fill)
+	} else {
+		currentUnitRow, currentUnitColumn := unitRow, unitColumn
+		for i := 0; i < wallLength; i++ {
+			x, y, width, height := m.unitCoordinatesToRect(currentUnitColumn, currentUnitRow)
+			m.drawRect(x, y, width, height, m.fill)
+			currentUnitColumn += vx
+			currentUnitRow += vy
+		}
+	}
+	g.updateCandidates(unitColumn, unitRow, vx, vy, wallLength)
+
+	// STEP 4
+	//
+	// We know how many empty (odd-numbered) cells we just drew over.
+	g.numberOfUnoccupiedUnits -= (wallLength - 1)/2
+	g.wallCount += 1
+	if m.eventHandler != nil {
+		x2, y2, width2, height2 := m.unitCoordinatesToRect(unitColumn + vx * (wallLength - 1), unitRow + vy * (wallLength - 1))
+		left, top := min(x, x2), min(y, y2)
+		m.emit(WallEvent, left, top, max(x + width, x2 + width2) - left, max(y + height, y2 + height2) - top, g.wallCount)
+	}
+	if m.maxWalls > 0 && g.wallCount >= m.maxWalls {
+		// The maze will almost certainly be incomplete if this number
+		// is low, but this is useful for illustration purposes.
+		g.phase = cuttingOpenings
+	}
+
+	// STEP 5: Step() calls us again while the maze is not full.
+	return true
+}
+
+// Returns the total weight of the walls in the given directions.
+func (g *Generator) totalWeight(allowed []struct{x, y int}) int {
+	total := 0
+	for direction, d := range(directions) {
+		if slices.Contains(allowed, d) {
+			total += g.candidates[direction].total
+		}
+	}
+	return total
+}
+
+// Step 6: cuts the entrance and exit (or the openings that SetOpenings()
+// asked for.)
+func (g *Generator) cutOpenings() {
+	m := g.m
+	if m.entranceSpecs != nil {
+		if g.pass == len(g.thicknessValues) - 1 {
+			g.cutRequestedOpenings()
+		}
+		return
+	}
+	entranceUnitColumn, entranceUnitRow, exitUnitColumn, exitUnitRow, solutionDistance := m.findEntranceAndExit(g.unitWidth, g.unitHeight, g.stopped)
+	if g.err != nil {
+		// Stopped by the context; we'll start the search over on the
+		// next step.
+		return
+	}
+	if solutionDistance < 0 {
+		m.log().Warn("no room for an entrance or exit", "thickness", m.thickness, "walls", g.wallCount)
+		return
+	}
+	g.opened = true
+	entrance := m.unitRect(point{entranceUnitColumn, entranceUnitRow})
+	exit := m.unitRect(point{exitUnitColumn, exitUnitRow})
+	m.entrances, m.exits = []rect{entrance}, []rect{exit}
+	m.emit(EntranceEvent, entrance.x, entrance.y, entrance.width, entrance.height, g.wallCount)
+	m.emit(ExitEvent, exit.x, exit.y, exit.width, exit.height, g.wallCount)
+	// m.drawRect(entrance.x, entrance.y, entrance.width, entrance.height, '1')
+	// m.drawRect(exit.x, exit.y, exit.width, exit.height, '2')
+	m.log().Info("entrance and exit",
+		"thickness", m.thickness,
+		rectAttr("entrance", entrance.x, entrance.y, entrance.width, entrance.height),
+		rectAttr("exit", exit.x, exit.y, exit.width, exit.height),
+		"solutionDistance", solutionDistance,
+		"walls", g.wallCount)
+}
+
+// A snapshot of a generator between steps; see State().  It can be saved
+// as JSON.
+type GeneratorState struct {
+	Width int                    `json:"width"`
+	Height int                   `json:"height"`
+
+	// The cells of the maze, one string per row, and the runes that the
+	// generator draws with.
+	Rows []string                `json:"rows"`
+	Floor string                 `json:"floor"`
+	Fill string                  `json:"fill"`
+	Intersection string          `json:"intersection"`
+	Horizontal string            `json:"horizontal"`
+	Vertical string              `json:"vertical"`
+
+	// The generation parameters.
+	MinWallLength int            `json:"minWallLength"`
+	MaxWallLength int            `json:"maxWallLength"`
+	MaxWalls int                 `json:"maxWalls"`
+	ThicknessValues []int        `json:"thicknessValues"`
+	Nested bool                  `json:"nested"`
+	Closed bool                  `json:"closed"`
+	EntranceSpecs []string       `json:"entranceSpecs,omitempty"`
+	ExitSpecs []string           `json:"exitSpecs,omitempty"`
+	Regions []Region             `json:"regions,omitempty"`
+
+	// The random number generator, as its seed and the number of values
+	// that have been drawn from it so far.
+	Seed string                  `json:"seed"`
+	Draws uint64                 `json:"draws"`
+
+	// The openings cut by the passes so far, as {x, y, width, height}.
+	// Entrance and exit are the first of each, as they were saved before
+	// there could be more than one.
+	Entrances [][4]int           `json:"entrances"`
+	Exits [][4]int               `json:"exits"`
+	Entrance [4]int              `json:"entrance"`
+	Exit [4]int                  `json:"exit"`
+
+	// Where the generator is.
+	Phase int                    `json:"phase"`
+	Pass int                     `json:"pass"`
+	Thickness int                `json:"thickness"`
+	BiasHorizontal bool          `json:"biasHorizontal"`
+	UnoccupiedUnits int          `json:"unoccupiedUnits"`
+	Walls int                    `json:"walls"`
+	Unbiased bool                `json:"unbiased"`
+	Opened bool                  `json:"opened"`
+
+	// The thickness that the reserved regions were drawn at (0 if they
+	// haven't been yet), and the doors cut into each of them, as
+	// {x, y, width, height}, once they have been.
+	RegionThickness int          `json:"regionThickness,omitempty"`
+	RegionDoors [][][4]int       `json:"regionDoors,omitempty"`
+}
+
+// Captures the generator's progress, so that generation can be resumed
+// later with ResumeGenerator().
+func (g *Generator) State() GeneratorState {
+	m := g.m
+	s := GeneratorState{
+		Width: m.width,
+		Height: m.height,
+		Floor: string(m.floor),
+		Fill: string(m.fill),
+		Intersection: string(m.intersection),
+		Horizontal: string(m.horizontal),
+		Vertical: string(m.vertical),
+		MinWallLength: m.minWallLength,
+		MaxWallLength: m.maxWallLength,
+		MaxWalls: m.maxWalls,
+		ThicknessValues: append([]int{}, g.thicknessValues...),
+		Nested: g.nested,
+		Closed: g.closed,
+		Seed: m.seed,
+		Draws: m.source.draws,
+		Entrances: [][4]int{},
+		Exits: [][4]int{},
+		Entrance: first(m.entrances).array(),
+		Exit: first(m.exits).array(),
+		Phase: int(g.phase),
+		Pass: g.pass,
+		Thickness: m.thickness,
+		BiasHorizontal: g.biasHorizontal,
+		UnoccupiedUnits: g.numberOfUnoccupiedUnits,
+		Walls: g.wallCount,
+		Unbiased: g.unbiased,
+		Opened: g.opened,
+		Regions: m.Regions(),
+		RegionThickness: g.regionThickness,
+	}
+	for _, doors := range(m.regionDoors) {
+		saved := [][4]int{}
+		for _, r := range(doors) {
+			saved = append(saved, r.array())
+		}
+		s.RegionDoors = append(s.RegionDoors, saved)
+	}
+	for _, r := range(m.entrances) {
+		s.Entrances = append(s.Entrances, r.array())
+	}
+	for _, r := range(m.exits) {
+		s.Exits = append(s.Exits, r.array())
+	}
+	for _, spec := range(m.entranceSpecs) {
+		s.EntranceSpecs = append(s.EntranceSpecs, spec.String())
+	}
+	for _, spec := range(m.exitSpecs) {
+		s.ExitSpecs = append(s.ExitSpecs, spec.String())
+	}
+	for y := 0; y < m.height; y++ {
+		row := make([]rune, m.width)
+		for x := range(row) {
+			row[x] = m.cell(m.offset(x, y))
+		}
+		s.Rows = append(s.Rows, string(row))
+	}
+	return s
+}
+
+// Returns the openings saved in a state: the list, if there is one, or else
+// the single opening that older states have.
+func savedOpenings(list [][4]int, single [4]int) []rect {
+	result := []rect{}
+	for _, a := range(list) {
+		result = append(result, rectFromArray(a))
+	}
+	if list == nil && single[2] > 0 {
+		result = append(result, rectFromArray(single))
+	}
+	return result
+}
+
+// Restores the maze to the state captured by State(), and returns a
+// generator that continues from there.  The same steps follow as if the
+// original generator had kept going.
+//
+// Only the maze's display options (such as its theme) are left alone.
+func (m *Maze) ResumeGenerator(s GeneratorState) (*Generator, error) {
+	if s.Width < 0 || s.Height < 0 || len(s.Rows) != s.Height {
+		return nil, fmt.Errorf("the state has %v rows for a height of %v", len(s.Rows), s.Height)
+	}
+	for y, row := range(s.Rows) {
+		if utf8.RuneCountInString(row) != s.Width {
+			return nil, fmt.Errorf("row %v has %v cells for a width of %v", y, utf8.RuneCountInString(row), s.Width)
+		}
+	}
+	runes := []struct{name, value string; target *rune}{
+		{"floor", s.Floor, &m.floor},
+		{"fill", s.Fill, &m.fill},
+		{"intersection", s.Intersection, &m.intersection},
+		{"horizontal", s.Horizontal, &m.horizontal},
+		{"vertical", s.Vertical, &m.vertical},
+	}
+	for _, r := range(runes) {
+		if utf8.RuneCountInString(r.value) != 1 {
+			return nil, fmt.Errorf("the %v rune, \"%v\", must be exactly one rune", r.name, r.value)
+		}
+	}
+	if s.Phase < int(startingPass) || s.Phase > int(finished) || s.Pass < 0 || s.Pass > len(s.ThicknessValues) {
+		return nil, errors.New("the generator's position is out of range")
+	}
+	entranceSpecs, err := ParseOpenings(s.EntranceSpecs)
+	if err != nil {
+		return nil, err
+	}
+	exitSpecs, err := ParseOpenings(s.ExitSpecs)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range(s.Regions) {
+		if err := r.check(); err != nil {
+			return nil, err
+		}
+	}
+	if s.RegionDoors != nil && len(s.RegionDoors) != len(s.Regions) {
+		return nil, fmt.Errorf("the state has doors for %v regions, but there are %v", len(s.RegionDoors), len(s.Regions))
+	}
+
+	for _, r := range(runes) {
+		*r.target = []rune(r.value)[0]
+	}
+	m.setSize(s.Width, s.Height)
+	m.Clear()
+	for y, row := range(s.Rows) {
+		for x, c := range([]rune(row)) {
+			m.setCell(m.offset(x, y), c)
+		}
+	}
+	m.minWallLength, m.maxWallLength, m.maxWalls = s.MinWallLength, s.MaxWallLength, s.MaxWalls
+	m.entrances, m.exits = savedOpenings(s.Entrances, s.Entrance), savedOpenings(s.Exits, s.Exit)
+	m.SetOpenings(entranceSpecs, exitSpecs)
+	m.ClearRegions()
+	for _, r := range(s.Regions) {
+		m.Reserve(r)
+	}
+	if s.RegionDoors != nil {
+		m.regionDoors = [][]rect{}
+		for _, saved := range(s.RegionDoors) {
+			doors := []rect{}
+			for _, a := range(saved) {
+				doors = append(doors, rectFromArray(a))
+			}
+			m.regionDoors = append(m.regionDoors, doors)
+		}
+	}
+	m.thickness = s.Thickness
+
+	// Wind the random number generator forward to where it was.
+	m.SetSeed(s.Seed)
+	for m.source.draws < s.Draws {
+		m.source.Int63()
+	}
+
+	g := &Generator{
+		m: m,
+		phase: generatorPhase(s.Phase),
+		thicknessValues: append([]int{}, s.ThicknessValues...),
+		pass: s.Pass,
+		nested: s.Nested,
+		closed: s.Closed,
+		biasHorizontal: s.BiasHorizontal,
+		numberOfUnoccupiedUnits: s.UnoccupiedUnits,
+		wallCount: s.Walls,
+		unbiased: s.Unbiased,
+		opened: s.Opened,
+		regionThickness: s.RegionThickness,
+	}
+	m.thicknessValues = g.thicknessValues[:min(g.pass + 1, len(g.thicknessValues))]
+	g.unitWidth, g.unitHeight = m.unitDimensions()
+	return g, nil
+}
@@ -0,0 +1,293 @@
+package main
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// The maze's passage graph, for graph-theory exercises and external tools.
+//
+// Graph() describes the maze as an undirected graph whose nodes are its rooms
+// (see passages.go) and whose edges are the doorways between them.  When it
+// is asked to contract the corridors, it keeps only the rooms where there's a
+// choice to make or nowhere left to go (junctions, dead ends, entrances and
+// exits), and each corridor between two of them becomes a single edge
+// weighted by its length.
+//
+// Nodes are named by the unit coordinates of their rooms, as "x,y".  The
+// graph can be written as Graphviz DOT, GraphML or a plain edge list (see
+// graphFormatNames()); "maze --graph" does that.
+//
+// The contracted graph is also the one to plan routes on (see routes.go),
+// since it is far smaller than the grid of cells.  Every edge remembers the
+// cells along its corridor, so a route through the graph can be followed in
+// the maze itself.
+
+// A room in the passage graph.
+type GraphNode struct {
+	// The unit coordinates of the room.
+	X, Y int
+
+	// "entrance", "exit", "junction", "deadEnd", "corridor" (for a room
+	// with two doorways) or "isolated".
+	Kind string
+
+	// The cell at the center of the room.
+	cell point
+}
+
+// A corridor between two nodes of the passage graph, given by their indices.
+type GraphEdge struct {
+	From, To int
+
+	// The number of steps from one end of the corridor to the other.  This
+	// is always 1 if the corridors weren't contracted.
+	Length int
+
+	// The cells along the corridor, from the center of the From room to
+	// the center of the To room.  Each cell is orthogonally adjacent to the
+	// next.
+	Cells []point
+
+	// The rooms along the corridor, including both ends.
+	rooms []point
+}
+
+// The passage graph of a maze; see Graph().
+type Graph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+
+	// The seed of the maze, which names the graph.
+	Seed string
+
+	// The indices of the entrance and exit nodes, or -1 if there are none.
+	// If there are several of either, these are the first, and all of them
+	// are in Entrances and Exits.
+	Entrance, Exit int
+	Entrances, Exits []int
+
+	// The indices of the edges that meet at each node.
+	adjacency [][]int
+}
+
+func graphFormatNames() []string {
+	return []string{"dot", "graphml", "edges"}
+}
+
+// Returns the kind of a room, for GraphNode.Kind.
+func (m *Maze) roomKind(room point) string {
+	if kind := m.goalKind(room); kind != "" {
+		return kind
+	}
+	switch degree := m.roomDegree(room); {
+	case degree == 0:
+		return "isolated"
+	case degree == 1:
+		return "deadEnd"
+	case degree == 2:
+		return "corridor"
+	}
+	return "junction"
+}
+
+// Returns the cell at the center of the given unit.  Every cell on the
+// straight line between the centers of two neighboring open units is open.
+func (m *Maze) unitCenter(p point) point {
+	x, y, width, height := m.unitCoordinatesToRect(p.x, p.y)
+	return point{x + width / 2, y + height / 2}
+}
+
+// Returns the cells from the center of the first of the given rooms to the
+// center of the last, passing through the center of each room in between.
+func (m *Maze) roomPathCells(rooms []point) []point {
+	cells := []point{m.unitCenter(rooms[0])}
+	for _, room := range(rooms[1:]) {
+		target := m.unitCenter(room)
+		for current := cells[len(cells) - 1]; current != target; {
+			switch {
+			case current.x < target.x:
+				current.x++
+			case current.x > target.x:
+				current.x--
+			case current.y < target.y:
+				current.y++
+			default:
+				current.y--
+			}
+			cells = append(cells, current)
+		}
+	}
+	return cells
+}
+
+// Returns the maze's passage graph: every room and every doorway between two
+// rooms, or, if contract is true, only the rooms that aren't pass-throughs,
+// connected by their corridors (see corridors().)
+func (m *Maze) Graph(contract bool) Graph {
+	g := Graph{Nodes: []GraphNode{}, Edges: []GraphEdge{}, Seed: m.seed, Entrance: -1, Exit: -1, Entrances: []int{}, Exits: []int{}}
+	index := map[point]int{}
+	for _, room := range(m.rooms()) {
+		if contract && m.isPassThrough(room) {
+			continue
+		}
+		index[room] = len(g.Nodes)
+		node := GraphNode{X: room.x, Y: room.y, Kind: m.roomKind(room), cell: m.unitCenter(room)}
+		switch node.Kind {
+		case "entrance":
+			g.Entrances = append(g.Entrances, len(g.Nodes))
+		case "exit":
+			g.Exits = append(g.Exits, len(g.Nodes))
+		}
+		g.Nodes = append(g.Nodes, node)
+	}
+	if len(g.Entrances) > 0 {
+		g.Entrance = g.Entrances[0]
+	}
+	if len(g.Exits) > 0 {
+		g.Exit = g.Exits[0]
+	}
+
+	g.adjacency = make([][]int, len(g.Nodes))
+	addEdge := func(rooms []point) {
+		e := GraphEdge{
+			From: index[rooms[0]],
+			To: index[rooms[len(rooms) - 1]],
+			Length: len(rooms) - 1,
+			Cells: m.roomPathCells(rooms),
+			rooms: rooms,
+		}
+		g.adjacency[e.From] = append(g.adjacency[e.From], len(g.Edges))
+		if e.To != e.From {
+			g.adjacency[e.To] = append(g.adjacency[e.To], len(g.Edges))
+		}
+		g.Edges = append(g.Edges, e)
+	}
+	if contract {
+		for _, corridor := range(m.corridors()) {
+			addEdge(corridor)
+		}
+		return g
+	}
+	for _, room := range(m.rooms()) {
+		for _, neighbor := range(m.roomNeighbors(room)) {
+			if index[neighbor] > index[room] {
+				addEdge([]point{room, neighbor})
+			}
+		}
+	}
+	return g
+}
+
+// Returns the indices of the edges that meet at the given node.
+func (g Graph) EdgesAt(node int) []int {
+	return g.adjacency[node]
+}
+
+// Returns the node at the other end of the given edge.
+func (g Graph) otherEnd(edge, node int) int {
+	e := g.Edges[edge]
+	if e.From == node {
+		return e.To
+	}
+	return e.From
+}
+
+// Returns the index of the node for the room whose unit coordinates are
+// given.  The second return value is false if there is no such node.
+func (g Graph) NodeAt(x, y int) (int, bool) {
+	// The nodes are in the same order as the rooms: from left to right and
+	// top to bottom.
+	return slices.BinarySearchFunc(g.Nodes, point{x, y}, func(n GraphNode, p point) int {
+		if n.Y != p.y {
+			return n.Y - p.y
+		}
+		return n.X - p.x
+	})
+}
+
+// Returns the name of a node.
+func (n GraphNode) id() string {
+	return fmt.Sprintf("%v,%v", n.X, n.Y)
+}
+
+// Writes the graph in the given format (see graphFormatNames().)
+func (g Graph) Write(w io.Writer, format string) error {
+	switch format {
+	case "dot":
+		return g.WriteDOT(w)
+	case "graphml":
+		return g.WriteGraphML(w)
+	case "edges":
+		return g.WriteEdgeList(w)
+	}
+	return fmt.Errorf("unknown graph format \"%v\"", format)
+}
+
+// Writes the graph in Graphviz's DOT language.  Each node has a "kind"
+// attribute and is pinned to its position in the maze (for neato and fdp),
+// and each edge is weighted by its length.
+func (g Graph) WriteDOT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	quote := func(s string) string {
+		return "\"" + strings.ReplaceAll(strings.ReplaceAll(s, "\\", "\\\\"), "\"", "\\\"") + "\""
+	}
+	fmt.Fprintf(out, "graph %v {\n", quote(g.Seed))
+	for _, n := range(g.Nodes) {
+		fmt.Fprintf(out, "\t%v [kind=%v, pos=\"%v,%v!\"];\n", quote(n.id()), quote(n.Kind), n.X, -n.Y)
+	}
+	for _, e := range(g.Edges) {
+		fmt.Fprintf(out, "\t%v -- %v [weight=%v];\n", quote(g.Nodes[e.From].id()), quote(g.Nodes[e.To].id()), e.Length)
+	}
+	fmt.Fprintf(out, "}\n")
+	return out.Flush()
+}
+
+// Writes the graph as GraphML, with the kind and coordinates of each node and
+// the length of each edge as data.
+func (g Graph) WriteGraphML(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	escape := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	fmt.Fprintf(out, "%v", xml.Header)
+	fmt.Fprintf(out, "<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	fmt.Fprintf(out, "  <key id=\"kind\" for=\"node\" attr.name=\"kind\" attr.type=\"string\"/>\n")
+	fmt.Fprintf(out, "  <key id=\"x\" for=\"node\" attr.name=\"x\" attr.type=\"int\"/>\n")
+	fmt.Fprintf(out, "  <key id=\"y\" for=\"node\" attr.name=\"y\" attr.type=\"int\"/>\n")
+	fmt.Fprintf(out, "  <key id=\"length\" for=\"edge\" attr.name=\"length\" attr.type=\"int\"/>\n")
+	fmt.Fprintf(out, "  <graph id=\"%v\" edgedefault=\"undirected\">\n", escape(g.Seed))
+	for _, n := range(g.Nodes) {
+		fmt.Fprintf(out, "    <node id=\"%v\"><data key=\"kind\">%v</data><data key=\"x\">%v</data><data key=\"y\">%v</data></node>\n", n.id(), n.Kind, n.X, n.Y)
+	}
+	for _, e := range(g.Edges) {
+		fmt.Fprintf(out, "    <edge source=\"%v\" target=\"%v\"><data key=\"length\">%v</data></edge>\n", g.Nodes[e.From].id(), g.Nodes[e.To].id(), e.Length)
+	}
+	fmt.Fprintf(out, "  </graph>\n</graphml>\n")
+	return out.Flush()
+}
+
+// Writes the graph as a plain edge list: one line per edge, with the names of
+// its two nodes and its length, separated by spaces.  Nodes without edges
+// are listed on lines of their own.
+func (g Graph) WriteEdgeList(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	connected := make([]bool, len(g.Nodes))
+	for _, e := range(g.Edges) {
+		connected[e.From], connected[e.To] = true, true
+	}
+	for i, n := range(g.Nodes) {
+		if !connected[i] {
+			fmt.Fprintf(out, "%v\n", n.id())
+		}
+	}
+	for _, e := range(g.Edges) {
+		fmt.Fprintf(out, "%v %v %v\n", g.Nodes[e.From].id(), g.Nodes[e.To].id(), e.Length)
+	}
+	return out.Flush()
+}
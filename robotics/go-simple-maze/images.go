@@ -0,0 +1,92 @@
+package main
+import (
+	"bufio"
+	"fmt"
+	"image/png"
+	"io"
+)
+
+// Still images of a maze, as PNG or SVG.  Both use the colors of the GIF
+// frames (see imagePalette), with each cell drawn as a square.
+
+// Options for WritePNG() and WriteSVG().
+type ImageOptions struct {
+	// The size of each cell, in pixels.
+	Scale int
+
+	// Highlights the shortest path from the entrance to the exit.
+	Solution bool
+}
+
+// Returns the solution cells to highlight for the given options, or nil.
+func (m *Maze) imageSolution(options ImageOptions) []bool {
+	if !options.Solution {
+		return nil
+	}
+	return m.solutionCells(m.cellDistances())
+}
+
+// The largest PNG, in pixels, that WritePNG() will draw.  The whole image is
+// held in memory, at a byte per pixel, while it's encoded.
+const maxImagePixels = 1 << 26
+
+// Returns true if an image of a maze of the given size, drawn at the given
+// scale, is no larger than maxImagePixels.
+func imageFits(width, height, scale int) bool {
+	scale = max(1, scale)
+	if scale > maxImagePixels / scale {
+		return false
+	}
+	return height == 0 || width <= maxImagePixels / (scale * scale) / height
+}
+
+// Writes the maze as a PNG image.
+func (m *Maze) WritePNG(w io.Writer, options ImageOptions) error {
+	if !imageFits(m.width, m.height, options.Scale) {
+		return fmt.Errorf("a %vx%v maze at a scale of %v is larger than the limit of %v pixels", m.width, m.height, options.Scale, maxImagePixels)
+	}
+	return png.Encode(w, m.mazeImage(max(1, options.Scale), m.imageSolution(options)))
+}
+
+// Writes the maze as an SVG image.  The drawing is measured in cells, and
+// the scale only sets its default size, so it stays sharp at any size.  Each
+// color is a single path made of the runs of cells in each row.
+func (m *Maze) WriteSVG(w io.Writer, options ImageOptions) error {
+	img := m.mazeImage(1, m.imageSolution(options))
+	scale := max(1, options.Scale)
+	hex := func(index int) string {
+		r, g, b, _ := imagePalette[index].RGBA()
+		return fmt.Sprintf("#%02x%02x%02x", r >> 8, g >> 8, b >> 8)
+	}
+
+	out := bufio.NewWriter(w)
+	fmt.Fprintf(out, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%v\" height=\"%v\" viewBox=\"0 0 %v %v\" shape-rendering=\"crispEdges\">\n",
+		m.width * scale, m.height * scale, m.width, m.height)
+	fmt.Fprintf(out, "<rect width=\"%v\" height=\"%v\" fill=\"%v\"/>\n", m.width, m.height, hex(0))
+	for index := 1; index < len(imagePalette); index++ {
+		started := false
+		for y := 0; y < m.height; y++ {
+			for x := 0; x < m.width; {
+				if int(img.ColorIndexAt(x, y)) != index {
+					x++
+					continue
+				}
+				run := 1
+				for x + run < m.width && int(img.ColorIndexAt(x + run, y)) == index {
+					run++
+				}
+				if !started {
+					fmt.Fprintf(out, "<path fill=\"%v\" d=\"", hex(index))
+					started = true
+				}
+				fmt.Fprintf(out, "M%v %vh%vv1h-%vz", x, y, run, run)
+				x += run
+			}
+		}
+		if started {
+			fmt.Fprintf(out, "\"/>\n")
+		}
+	}
+	fmt.Fprintf(out, "</svg>\n")
+	return out.Flush()
+}
@@ -0,0 +1,380 @@
+package main
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"unicode/utf8"
+)
+
+// JSON serialization.
+//
+// A maze is written as a single JSON object, a MazeDocument, whose format is
+// spelled out by MazeJSONSchema (and printed by "maze --json-schema".)  For a
+// small maze, it looks something like this:
+//
+//   {
+//     "version": 1,
+//     "width": 11,
+//     "height": 5,
+//     "thicknessValues": [1],
+//     "glyphs": {"floor": " ", "fill": ".", "intersection": "+", "horizontal": "-", "vertical": "|"},
+//     "rows": [
+//       "+-+-------+",
+//       "  |       |",
+//       "| | ----+ |",
+//       "|       |  ",
+//       "+-------+-+"
+//     ],
+//     "entrance": {"x": 0, "y": 1, "width": 1, "height": 1},
+//     "exit": {"x": 10, "y": 3, "width": 1, "height": 1},
+//     "entrances": [{"x": 0, "y": 1, "width": 1, "height": 1}],
+//     "exits": [{"x": 10, "y": 3, "width": 1, "height": 1}],
+//     "seed": "example",
+//     "minWallLength": 3,
+//     "solution": [[0, 1], [1, 1], ...],
+//     "stats": {...}
+//   }
+//
+// The rows are the maze's cells, one rune per cell, and the glyphs say which
+// rune is which.  Glyphs of more than one code point (see width.go) are
+// stored in the rows as placeholder runes, and "placeholders" maps those back
+// to what they stand for.  The solution and statistics follow from the rest,
+// so they are ignored when a maze is read back in.
+
+// The version of the format written by MarshalJSON().  UnmarshalJSON()
+// rejects documents from later versions.
+const mazeDocumentVersion = 1
+
+// The JSON form of a maze.  See MazeJSONSchema for what each field means.
+type MazeDocument struct {
+	Version int                  `json:"version"`
+	Width int                    `json:"width"`
+	Height int                   `json:"height"`
+	ThicknessValues []int        `json:"thicknessValues"`
+	Glyphs MazeGlyphs            `json:"glyphs"`
+	Placeholders map[string]string `json:"placeholders,omitempty"`
+	Rows []string                `json:"rows"`
+	Entrance MazeRect            `json:"entrance"`
+	Exit MazeRect                `json:"exit"`
+
+	// All of the entrances and exits.  Entrance and Exit are the first of
+	// each, as they were written before a maze could have several.
+	Entrances []MazeRect         `json:"entrances"`
+	Exits []MazeRect             `json:"exits"`
+
+	// The generation parameters.  A maximum of 0 means that there is no
+	// maximum.
+	Seed string                  `json:"seed"`
+	MinWallLength int            `json:"minWallLength"`
+	MaxWallLength int            `json:"maxWallLength,omitempty"`
+	MaxWalls int                 `json:"maxWalls,omitempty"`
+
+	// The cells from the entrance to the exit, as [x, y], and the maze's
+	// statistics.  These are optional; see Document().
+	Solution [][2]int            `json:"solution,omitempty"`
+	Stats *Stats                 `json:"stats,omitempty"`
+}
+
+// The runes that the maze's rows are drawn with.
+type MazeGlyphs struct {
+	Floor string                 `json:"floor"`
+	Fill string                  `json:"fill"`
+	Intersection string          `json:"intersection"`
+	Horizontal string            `json:"horizontal"`
+	Vertical string              `json:"vertical"`
+}
+
+// A rectangle of cells, such as the entrance or the exit.
+type MazeRect struct {
+	X int                        `json:"x"`
+	Y int                        `json:"y"`
+	Width int                    `json:"width"`
+	Height int                   `json:"height"`
+}
+
+// The JSON Schema for MazeDocument.
+const MazeJSONSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "Maze",
+  "description": "A maze generated by go-simple-maze.",
+  "type": "object",
+  "required": ["version", "width", "height", "thicknessValues", "glyphs", "rows", "entrance", "exit", "seed", "minWallLength"],
+  "properties": {
+    "version": {
+      "description": "The version of this format.",
+      "const": 1
+    },
+    "width": {
+      "description": "The width of the maze, in cells.",
+      "type": "integer",
+      "minimum": 0
+    },
+    "height": {
+      "description": "The height of the maze, in cells.",
+      "type": "integer",
+      "minimum": 0
+    },
+    "thicknessValues": {
+      "description": "The thickness of the corridors in each generation pass, from the first (thickest) to the last.",
+      "type": "array",
+      "items": {"type": "integer", "minimum": 1}
+    },
+    "glyphs": {
+      "description": "The rune that the rows use for each kind of cell.",
+      "type": "object",
+      "required": ["floor", "fill", "intersection", "horizontal", "vertical"],
+      "properties": {
+        "floor": {"$ref": "#/$defs/rune", "description": "An open cell."},
+        "fill": {"$ref": "#/$defs/rune", "description": "A cell that was filled in because it could not be reached."},
+        "intersection": {"$ref": "#/$defs/rune", "description": "A wall cell where walls meet or end."},
+        "horizontal": {"$ref": "#/$defs/rune", "description": "A horizontal wall cell."},
+        "vertical": {"$ref": "#/$defs/rune", "description": "A vertical wall cell."}
+      }
+    },
+    "placeholders": {
+      "description": "The glyphs of more than one code point, keyed by the placeholder rune that stands for them in the rows and glyphs.",
+      "type": "object",
+      "propertyNames": {"$ref": "#/$defs/rune"},
+      "additionalProperties": {"type": "string"}
+    },
+    "rows": {
+      "description": "The cells of the maze from top to bottom, one string of width runes per row.",
+      "type": "array",
+      "items": {"type": "string"}
+    },
+    "entrance": {"$ref": "#/$defs/rect", "description": "The first of the entrances."},
+    "exit": {"$ref": "#/$defs/rect", "description": "The first of the exits."},
+    "entrances": {
+      "description": "The openings where the maze starts: in the border, or rooms in the interior.  If absent, the entrance is the only one.",
+      "type": "array",
+      "items": {"$ref": "#/$defs/rect"}
+    },
+    "exits": {
+      "description": "The openings where the maze ends: in the border, or rooms in the interior.  If absent, the exit is the only one.",
+      "type": "array",
+      "items": {"$ref": "#/$defs/rect"}
+    },
+    "seed": {
+      "description": "The seed that the maze was generated from.  The same seed and parameters generate the same maze.",
+      "type": "string"
+    },
+    "minWallLength": {
+      "description": "The shortest wall that the generator would place, in units.",
+      "type": "integer"
+    },
+    "maxWallLength": {
+      "description": "The longest wall that the generator would place, in units.  Absent or 0 if there is no maximum.",
+      "type": "integer",
+      "minimum": 0
+    },
+    "maxWalls": {
+      "description": "The number of walls that the generator stopped after.  Absent or 0 if there is no maximum.",
+      "type": "integer",
+      "minimum": 0
+    },
+    "solution": {
+      "description": "The cells along the shortest path from any entrance to any exit, in order, as [x, y].",
+      "type": "array",
+      "items": {
+        "type": "array",
+        "prefixItems": [{"type": "integer"}, {"type": "integer"}],
+        "minItems": 2,
+        "maxItems": 2
+      }
+    },
+    "stats": {
+      "description": "Statistics about the maze, as printed by \"maze --stats json\".",
+      "type": "object"
+    }
+  },
+  "$defs": {
+    "rune": {
+      "type": "string",
+      "minLength": 1,
+      "maxLength": 1
+    },
+    "rect": {
+      "type": "object",
+      "required": ["x", "y", "width", "height"],
+      "properties": {
+        "x": {"type": "integer"},
+        "y": {"type": "integer"},
+        "width": {"type": "integer", "minimum": 0},
+        "height": {"type": "integer", "minimum": 0}
+      }
+    }
+  }
+}
+`
+
+// Returns the JSON form of the maze, with or without its solution and
+// statistics (which take a search of the whole maze to work out.)
+func (m *Maze) Document(solution, stats bool) MazeDocument {
+	d := MazeDocument{
+		Version: mazeDocumentVersion,
+		Width: m.width,
+		Height: m.height,
+		ThicknessValues: append([]int{}, m.thicknessValues...),
+		Glyphs: MazeGlyphs{
+			Floor: string(m.floor),
+			Fill: string(m.fill),
+			Intersection: string(m.intersection),
+			Horizontal: string(m.horizontal),
+			Vertical: string(m.vertical),
+		},
+		Rows: []string{},
+		Entrance: mazeRect(first(m.entrances)),
+		Exit: mazeRect(first(m.exits)),
+		Entrances: []MazeRect{},
+		Exits: []MazeRect{},
+		Seed: m.seed,
+		MinWallLength: m.minWallLength,
+		MaxWalls: m.maxWalls,
+	}
+	for _, r := range(m.entrances) {
+		d.Entrances = append(d.Entrances, mazeRect(r))
+	}
+	for _, r := range(m.exits) {
+		d.Exits = append(d.Exits, mazeRect(r))
+	}
+	if len(d.ThicknessValues) == 0 {
+		d.ThicknessValues = []int{max(1, m.thickness)}
+	}
+	if m.maxWallLength != math.MaxInt64 {
+		d.MaxWallLength = m.maxWallLength
+	}
+	for placeholder, glyph := range(m.glyphs) {
+		if d.Placeholders == nil {
+			d.Placeholders = map[string]string{}
+		}
+		d.Placeholders[string(placeholder)] = glyph
+	}
+	for y := 0; y < m.height; y++ {
+		row := make([]rune, m.width)
+		for x := range(row) {
+			row[x] = m.cell(m.offset(x, y))
+		}
+		d.Rows = append(d.Rows, string(row))
+	}
+
+	if solution {
+		d.Solution = [][2]int{}
+		for _, p := range(m.solutionPath(m.cellDistances())) {
+			d.Solution = append(d.Solution, [2]int{p.x, p.y})
+		}
+	}
+	if stats {
+		s := m.Stats()
+		d.Stats = &s
+	}
+	return d
+}
+
+// Implements json.Marshaler.  The document includes the solution and
+// statistics.
+func (m Maze) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Document(true, true))
+}
+
+// Restores the maze from a MazeDocument.  Like ResumeGenerator(), this leaves
+// the maze's display options (such as its box style) alone; if the maze is
+// packed (see storage.go), it stays packed.
+func (m *Maze) SetDocument(d MazeDocument) error {
+	if d.Version < 1 || d.Version > mazeDocumentVersion {
+		return fmt.Errorf("unsupported version %v", d.Version)
+	}
+	if d.Width < 0 || d.Height < 0 || len(d.Rows) != d.Height {
+		return fmt.Errorf("the maze has %v rows for a height of %v", len(d.Rows), d.Height)
+	}
+	for y, row := range(d.Rows) {
+		if utf8.RuneCountInString(row) != d.Width {
+			return fmt.Errorf("row %v has %v cells for a width of %v", y, utf8.RuneCountInString(row), d.Width)
+		}
+	}
+	runes := []struct{name, value string; target *rune}{
+		{"floor", d.Glyphs.Floor, &m.floor},
+		{"fill", d.Glyphs.Fill, &m.fill},
+		{"intersection", d.Glyphs.Intersection, &m.intersection},
+		{"horizontal", d.Glyphs.Horizontal, &m.horizontal},
+		{"vertical", d.Glyphs.Vertical, &m.vertical},
+	}
+	for _, r := range(runes) {
+		if utf8.RuneCountInString(r.value) != 1 {
+			return fmt.Errorf("the %v glyph, \"%v\", must be exactly one rune", r.name, r.value)
+		}
+	}
+	var glyphs map[rune]string
+	for placeholder, glyph := range(d.Placeholders) {
+		if utf8.RuneCountInString(placeholder) != 1 {
+			return fmt.Errorf("the placeholder \"%v\" must be exactly one rune", placeholder)
+		}
+		if glyphs == nil {
+			glyphs = map[rune]string{}
+		}
+		glyphs[[]rune(placeholder)[0]] = glyph
+	}
+	for _, t := range(d.ThicknessValues) {
+		if t < 1 {
+			return fmt.Errorf("invalid thickness %v", t)
+		}
+	}
+
+	for _, r := range(runes) {
+		*r.target = []rune(r.value)[0]
+	}
+	m.glyphs = glyphs
+	m.setSize(d.Width, d.Height)
+	m.Clear()
+	for y, row := range(d.Rows) {
+		for x, c := range([]rune(row)) {
+			m.setCell(m.offset(x, y), c)
+		}
+	}
+	m.thicknessValues = append([]int{}, d.ThicknessValues...)
+	m.thickness = 1
+	if len(d.ThicknessValues) > 0 {
+		m.thickness = d.ThicknessValues[len(d.ThicknessValues) - 1]
+	}
+	m.entrances, m.exits = documentOpenings(d.Entrances, d.Entrance), documentOpenings(d.Exits, d.Exit)
+	m.minWallLength, m.maxWallLength, m.maxWalls = d.MinWallLength, d.MaxWallLength, d.MaxWalls
+	if m.maxWallLength <= 0 {
+		m.maxWallLength = math.MaxInt64
+	}
+	m.SetSeed(d.Seed)
+	return nil
+}
+
+func mazeRect(r rect) MazeRect {
+	return MazeRect{r.x, r.y, r.width, r.height}
+}
+
+// Returns the openings in a document: the list, if there is one, or else the
+// single opening that older documents have.
+func documentOpenings(list []MazeRect, single MazeRect) []rect {
+	result := []rect{}
+	for _, r := range(list) {
+		result = append(result, rect{r.X, r.Y, r.Width, r.Height})
+	}
+	if list == nil && single.Width > 0 {
+		result = append(result, rect{single.X, single.Y, single.Width, single.Height})
+	}
+	return result
+}
+
+// Implements json.Unmarshaler.
+func (m *Maze) UnmarshalJSON(data []byte) error {
+	var d MazeDocument
+	if err := json.Unmarshal(data, &d); err != nil {
+		return err
+	}
+	return m.SetDocument(d)
+}
+
+// Writes the maze as an indented MazeDocument, with its solution and
+// statistics.
+func (m *Maze) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(m.Document(true, true))
+}
@@ -0,0 +1,80 @@
+package main
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Diagnostics.
+//
+// While it works, the generator describes what it's doing to a log/slog
+// logger (see SetLogger()): each pass, each wall it places, and how it chose
+// the entrance and exit.  By default the log goes nowhere.  The maze itself
+// is only ever written where the caller asks, so turning the log on never
+// changes what ends up on standard output.
+//
+// The levels are:
+//
+//   Info:  the seed, the thickness values and the entrance and exit.
+//   Debug: each pass, each wall, and each improved entrance candidate.
+
+// A handler that throws everything away.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool { return false; }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil; }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler { return h; }
+func (h discardHandler) WithGroup(string) slog.Handler { return h; }
+
+var discardLogger = slog.New(discardHandler{})
+
+// Sets the logger that the maze writes its diagnostics to, or nil for none.
+func (m *Maze) SetLogger(logger *slog.Logger) {
+	m.logger = logger
+}
+
+// Returns the maze's logger, which is never nil.
+func (m *Maze) log() *slog.Logger {
+	if m.logger == nil {
+		return discardLogger
+	}
+	return m.logger
+}
+
+// Returns true if the maze's logger wants messages at the given level.  Use
+// this to skip building expensive messages.
+func (m *Maze) logs(level slog.Level) bool {
+	return m.log().Enabled(context.Background(), level)
+}
+
+// Returns a log attribute for a rectangle of cells.
+func rectAttr(key string, x, y, width, height int) slog.Attr {
+	return slog.Group(key, "x", x, "y", y, "width", width, "height", height)
+}
+
+// Returns a log attribute for a unit position.
+func unitAttr(key string, unitColumn, unitRow int) slog.Attr {
+	return slog.Group(key, "column", unitColumn, "row", unitRow)
+}
+
+func logFormatNames() []string {
+	return []string{"json", "text"}
+}
+
+// Returns a logger that writes to w in the given format ("json" or "text".)
+// Each -v on the command line lowers the level by one step, from Warn (just
+// the warnings) through Info to Debug.
+func newLogger(w io.Writer, format string, verbosity int) (*slog.Logger, error) {
+	options := &slog.HandlerOptions{
+		Level: slog.LevelWarn - slog.Level(4 * min(verbosity, 2)),
+	}
+	switch format {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	}
+	return nil, fmt.Errorf("unknown log format \"%v\"; expected one of %v", format, strings.Join(logFormatNames(), ", "))
+}
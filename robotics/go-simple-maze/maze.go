@@ -1,17 +1,23 @@
 package main
 import (
 	"os"
+	"io"
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"math"
 	"math/rand"
 	"time"
+	"slices"
 	"sort"
 	"strings"
 	"strconv"
 	"encoding/binary"
 	"encoding/hex"
 	"hash/fnv"
-	"unicode/utf8"
+	"image/gif"
+	"log/slog"
 	"github.com/akamensky/argparse"
 )
 
@@ -19,16 +25,51 @@ type Maze struct {
 	width int
 	height int
 	cells []rune
+
+	// Used instead of cells if the maze is packed; see storage.go.
+	packed *packedCells
+
 	thickness int
+
+	// The thickness of each generation pass so far; see GenerateNested().
+	thicknessValues []int
+
 	intersection rune
 	horizontal rune
 	vertical rune
-	verbosity int
 	floor rune
 	fill rune
 	minWallLength, maxWallLength int
 	maxWalls int
-	entrance, exit struct{x, y, width, height int}
+
+	// The openings of the maze: usually one entrance and one exit cut into
+	// the border, but there can be any number of each, and they can be
+	// rooms in the interior as well (see openings.go.)
+	entrances, exits []rect
+
+	// The openings that generation should make, or nil for the usual
+	// entrance and exit; see SetOpenings().
+	entranceSpecs, exitSpecs []OpeningSpec
+
+	// The regions that generation sets aside, and the doors that it cut
+	// into each of them (or nil, until it has); see regions.go.
+	regions []Region
+	regionDoors [][]rect
+
+	seed string
+	random *rand.Rand
+	source *countingSource
+	boxStyle *boxStyle
+
+	// The display strings for runes that stand in for glyphs of more than
+	// one code point (see width.go.)
+	glyphs map[rune]string
+
+	// Called as generation progresses; see events.go.
+	eventHandler func(Event)
+
+	// Where diagnostics go; see logging.go.
+	logger *slog.Logger
 }
 
 // Constants used for neighbor specification.  For instance, "every neighbor
@@ -60,6 +101,7 @@ func NewMaze(width, height int) Maze {
 		maxWallLength: math.MaxInt64,
 	}
 	m.setSize(width, height)
+	m.SetSeed("")
 
 	m.Clear()
 	return m
@@ -81,14 +123,59 @@ func NewMazeOverExisting(other Maze) Maze {
 	// display runes....
 	m := other
 
-	// But take care of the reference member (the cells slice) using a
-	// deep copy.
-	m.cells = make([]rune, m.width * m.height)
-	copy(m.cells, other.cells)
+	// But take care of the reference member (the cells) using a deep
+	// copy.
+	if other.packed != nil {
+		m.packed = other.packed.clone()
+	} else {
+		m.cells = make([]rune, m.width * m.height)
+		copy(m.cells, other.cells)
+	}
 
 	return m
 }
 
+// Converts a seed string into a value for seeding the random number
+// generator.  Any string will do.
+func seedValue(seed string) int64 {
+	hashAlgorithm := fnv.New64()
+	hashAlgorithm.Write([]byte(seed))
+	return int64(hashAlgorithm.Sum64())
+}
+
+// Reseeds the maze's random number generator, so that the next call to
+// Generate() is reproducible.
+//
+// An empty seed string seeds the generator based on the current time in
+// nanoseconds; for the aid of reproducibility, the timestamp is converted to
+// a hexadecimal string which Seed() will then return.
+func (m *Maze) SetSeed(seed string) {
+	if seed == "" {
+		timestamp := time.Now().UTC().UnixNano()
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, uint64(timestamp))
+		seed = hex.EncodeToString(b)
+	}
+	m.seed = seed
+	m.source = &countingSource{source: rand.NewSource(seedValue(seed)).(rand.Source64)}
+	m.random = rand.New(m.source)
+}
+
+// A random number source that counts the values drawn from it, so that a
+// generator's state can be saved and restored (see generator.go.)
+type countingSource struct {
+	source rand.Source64
+	draws uint64
+}
+
+func (s *countingSource) Int63() int64 { s.draws++; return s.source.Int63(); }
+func (s *countingSource) Uint64() uint64 { s.draws++; return s.source.Uint64(); }
+func (s *countingSource) Seed(seed int64) { s.draws = 0; s.source.Seed(seed); }
+
+// Gets the seed string that the random number generator was last seeded
+// with.
+func (m *Maze) Seed() string { return m.seed; }
+
 func max(a, b int) int {
 	if a > b {
 		return a
@@ -113,8 +200,8 @@ func abs(n int) int {
 // Alters the maze's dimensions.  The maze will need re-rendering after the
 // call.
 func (m *Maze) setSize(newWidth, newHeight int) {
-	m.width = newWidth;
-	m.height = newHeight;
+	m.width = max(0, newWidth);
+	m.height = max(0, newHeight);
 
 	// TODO: What are we going to do about m.cells?  The right thing would
 	// be to copy the existing cells into the upper-left corner of the
@@ -124,6 +211,10 @@ func (m *Maze) setSize(newWidth, newHeight int) {
 // Erases the contents of the maze, overwriting it with the m.floor rune.
 // You should call this if m.floor changes.
 func (m *Maze) Clear() {
+	if m.packed != nil {
+		m.packed = newPackedCells(m.cellCount(), m.floor)
+		return
+	}
 	m.cells = make([]rune, m.width * m.height)
 	for index := range(m.cells) {
 		m.cells[index] = m.floor
@@ -176,16 +267,16 @@ func (m *Maze) hasNeighbor(x, y int, mask mask, neighborValue rune) bool {
 		// Missing receiver.
 		return false
 	}
-	if (mask & left) != 0 && m.valid(x - 1, y) && m.cells[m.offset(x - 1, y)] != neighborValue {
+	if (mask & left) != 0 && m.valid(x - 1, y) && m.cell(m.offset(x - 1, y)) != neighborValue {
 		return false
 	}
-	if (mask & up) != 0 && m.valid(x, y - 1) && m.cells[m.offset(x, y - 1)] != neighborValue {
+	if (mask & up) != 0 && m.valid(x, y - 1) && m.cell(m.offset(x, y - 1)) != neighborValue {
 		return false
 	}
-	if (mask & right) != 0 && m.valid(x + 1, y) && m.cells[m.offset(x + 1, y)] != neighborValue {
+	if (mask & right) != 0 && m.valid(x + 1, y) && m.cell(m.offset(x + 1, y)) != neighborValue {
 		return false
 	}
-	if (mask & down) != 0 && m.valid(x, y + 1) && m.cells[m.offset(x, y + 1)] != neighborValue {
+	if (mask & down) != 0 && m.valid(x, y + 1) && m.cell(m.offset(x, y + 1)) != neighborValue {
 		return false
 	}
 	return true
@@ -284,7 +375,7 @@ func (m *Maze) drawRect(x, y, width, height int, fill rune) {
 			switch {
 			case (column == x || column == x + width - 1) && (row == y || row == y + height - 1):
 				proposedCell = m.intersection
-				if (height == 1 || width == 1) && m.cells[m.offset(column, row)] == m.floor {
+				if (height == 1 || width == 1) && m.cell(m.offset(column, row)) == m.floor {
 					// Minor optimization for walls of
 					// thickness 1.  This end of the line
 					// isn't touching anything, so replace
@@ -325,7 +416,7 @@ func (m *Maze) drawRect(x, y, width, height int, fill rune) {
 				proposedCell = fill
 			}
 
-			m.cells[m.offset(column, row)] = proposedCell
+			m.setCell(m.offset(column, row), proposedCell)
 		}
 	}
 }
@@ -341,7 +432,7 @@ func (m *Maze) rectContains(x, y, width, height int, cell rune) bool {
 	x, y, width, height = m.clipRect(x, y, width, height)
 	for row := y; row < y + height; row++ {
 		for column := x; column < x + width; column++ {
-			if m.cells[m.offset(column, row)] != cell {
+			if m.cell(m.offset(column, row)) != cell {
 				return false
 			}
 		}
@@ -349,6 +440,35 @@ func (m *Maze) rectContains(x, y, width, height int, cell rune) bool {
 	return true
 }
 
+// A rectangle of cells, such as an entrance or an exit.
+type rect struct {
+	x, y, width, height int
+}
+
+// Returns the rectangle as {x, y, width, height}, as it's saved in JSON.
+func (r rect) array() [4]int {
+	return [4]int{r.x, r.y, r.width, r.height}
+}
+func rectFromArray(a [4]int) rect {
+	return rect{a[0], a[1], a[2], a[3]}
+}
+
+// Returns true if the given cell lies within the rectangle, such as one of
+// m.entrances or m.exits.
+func inRect(x, y int, r rect) bool {
+	return x >= r.x && y >= r.y && x < r.x + r.width && y < r.y + r.height
+}
+
+// Returns true if the given cell lies within any of the rectangles.
+func inAnyRect(x, y int, rects []rect) bool {
+	for _, r := range(rects) {
+		if inRect(x, y, r) {
+			return true
+		}
+	}
+	return false
+}
+
 // Returns true if the given rectangle represents a passageway: its center (if
 // it has one) solely consists of floor runes, and walls do not hem the
 // center in on all four sides.
@@ -361,7 +481,7 @@ func (m *Maze) rectIsPassage(x, y, width, height int) bool {
 	wallOpening := false
 	for row := y; row < y + height; row++ {
 		for column := x; column < x + width; column++ {
-			c := m.cells[m.offset(column, row)]
+			c := m.cell(m.offset(column, row))
 
 			if column > x && column < x + width - 1 && row > y && row < y + height - 1 {
 				// Interior cell.
@@ -400,6 +520,73 @@ func (m *Maze) unitCoordinatesToRect(unitColumn, unitRow int) (x, y, width, heig
 	}
 }
 
+// Like unitCoordinatesToRect(), but returns a rect.
+func (m *Maze) unitRect(p point) rect {
+	x, y, width, height := m.unitCoordinatesToRect(p.x, p.y)
+	return rect{x, y, width, height}
+}
+
+// The inverse of unitCoordinatesToRect(): returns the unit coordinate whose
+// rectangle has the given cell as its upper-left corner.
+func (m *Maze) cellToUnitCoordinates(x, y int) (unitColumn, unitRow int) {
+	switch m.thickness {
+	case 1:
+		return x, y
+	case 2:
+		return x / 2, y / 2
+	default:
+		return x / (m.thickness - 1), y / (m.thickness - 1)
+	}
+}
+
+// Returns the width and height of the maze in unit coordinates.
+//
+// The dimensions must be such that they can fit in an odd number of cells of
+// size TxT, where T is the thickness, so both values are always odd.
+func (m *Maze) unitDimensions() (unitWidth, unitHeight int) {
+	unitWidth, unitHeight = m.width, m.height
+	if m.thickness == 2 {
+		unitWidth = m.width / 2
+		unitHeight = m.height / 2
+	} else if m.thickness > 1 {
+		unitWidth = (m.width - 1) / (m.thickness - 1)
+		unitHeight = (m.height - 1) / (m.thickness - 1)
+	}
+	if unitWidth % 2 == 0 {
+		unitWidth--
+	}
+	if unitHeight % 2 == 0 {
+		unitHeight--
+	}
+	return unitWidth, unitHeight
+}
+
+// Returns the width and height, in cells, of the part of the maze that the
+// units cover.  Any cells to the right of or below this are left over from
+// dimensions that didn't fit the unit grid exactly.
+func (m *Maze) gridDimensions() (width, height int) {
+	unitWidth, unitHeight := m.unitDimensions()
+	if unitWidth < 1 || unitHeight < 1 {
+		return 0, 0
+	}
+	x, y, width, height := m.unitCoordinatesToRect(unitWidth - 1, unitHeight - 1)
+	return min(m.width, x + width), min(m.height, y + height)
+}
+
+// Returns true if the unit rectangle at the given unit coordinate is open
+// floor rather than wall.
+//
+// For thicknesses of 1 and 2, the unit rectangles do not overlap, so the whole
+// rectangle must be floor.  For larger thicknesses, neighboring unit
+// rectangles share their borders, so only the interior is examined.
+func (m *Maze) unitIsOpen(unitColumn, unitRow int) bool {
+	x, y, width, height := m.unitCoordinatesToRect(unitColumn, unitRow)
+	if m.thickness > 2 {
+		x, y, width, height = x + 1, y + 1, width - 2, height - 2
+	}
+	return m.rectContains(x, y, width, height, m.floor)
+}
+
 // Utility function for findEntranceAndExit().  Provides all the points around
 // the perimeter of the given rectangle.
 //
@@ -415,6 +602,10 @@ func (m *Maze) unitCoordinatesToRect(unitColumn, unitRow int) (x, y, width, heig
 // iterator.
 func rectPerimeter(width, height int) []struct{x, y int} {
 	result := []struct{x, y int}{}
+	if width == 1 && height == 1 {
+		// The loop below would skip the only cell.
+		return append(result, struct{x, y int}{0, 0})
+	}
 
 	for i := 0; i < 2 * (width - 1 + height - 1); i++ {
 		switch {
@@ -468,7 +659,10 @@ func rectPerimeter(width, height int) []struct{x, y int} {
 //
 // Returns the unit coordinates of the entrance and exit, such that you can simply
 // punch holes in those positions to complete the maze.
-func (m *Maze) findEntranceAndExit(unitWidth, unitHeight int) (entranceUnitColumn, entranceUnitRow, exitUnitColumn, exitUnitRow, solutionLength int) {
+//
+// Since this can take a long time, stopped() is called before each flood
+// fill; if it returns true, we give up and return a solution length of -1.
+func (m *Maze) findEntranceAndExit(unitWidth, unitHeight int, stopped func() bool) (entranceUnitColumn, entranceUnitRow, exitUnitColumn, exitUnitRow, solutionLength int) {
 
 	type Point struct {
 		x, y int
@@ -581,6 +775,9 @@ func (m *Maze) findEntranceAndExit(unitWidth, unitHeight int) (entranceUnitColum
 	finalCandidates := []Point{}
 	for _, p := range(rectPerimeter(unitWidth - 2, unitHeight - 2)) {
 		var unitColumn, unitRow int = p.x + 1, p.y + 1
+		if stopped() {
+			return 0, 0, 0, 0, -1
+		}
 
 		x, y, width, height := m.unitCoordinatesToRect(unitColumn, unitRow)
 		if !m.rectIsPassage(x, y, width, height) {
@@ -605,11 +802,15 @@ func (m *Maze) findEntranceAndExit(unitWidth, unitHeight int) (entranceUnitColum
 			entranceUnitRow = unitRow
 			longestDistance = visited.longestDistance
 			finalCandidates = visited.furthestPoints
-			if m.verbosity > 1 {
-				fmt.Printf("[>] Distance from entrance (%v) to exit (%v): %v\n",
-					Point{x: entranceUnitColumn, y: entranceUnitRow},
-					finalCandidates,
-					longestDistance)
+			if m.logs(slog.LevelDebug) {
+				exits := []slog.Attr{}
+				for i, p := range(finalCandidates) {
+					exits = append(exits, unitAttr(strconv.Itoa(i), p.x, p.y))
+				}
+				m.log().Debug("entrance candidate",
+					unitAttr("entrance", entranceUnitColumn, entranceUnitRow),
+					slog.Attr{Key: "exits", Value: slog.GroupValue(exits...)},
+					"distance", longestDistance)
 			}
 		} else if visited.longestDistance == longestDistance {
 			// fmt.Printf("[=] Distance from entrance (%v) to exit (%v): %v\n",
@@ -619,9 +820,36 @@ func (m *Maze) findEntranceAndExit(unitWidth, unitHeight int) (entranceUnitColum
 		}
 	}
 
-	i := rand.Intn(len(finalCandidates))
+	if len(finalCandidates) == 0 {
+		// Every unit along the outer corridor is walled in (this can
+		// happen when drawing over an existing maze), so there's
+		// nowhere to put an entrance.
+		return 0, 0, 0, 0, -1
+	}
+
+	i := m.random.Intn(len(finalCandidates))
 	exitUnitColumn, exitUnitRow = finalCandidates[i].x, finalCandidates[i].y
 
+	// Moves the given outer corridor position to the outer wall next to
+	// it.  The edges are checked in the given order, which only matters for
+	// the corners of the outer corridor.
+	leftEdge, rightEdge, topEdge, bottomEdge := 0, 1, 2, 3
+	toOuterWall := func(p Point, edges []int) (wall Point, horizontal bool) {
+		for _, edge := range(edges) {
+			switch {
+			case edge == leftEdge && p.x == 1:
+				return Point{x: p.x - 1, y: p.y}, false
+			case edge == rightEdge && p.x == unitWidth - 2:
+				return Point{x: p.x + 1, y: p.y}, false
+			case edge == topEdge && p.y == 1:
+				return Point{x: p.x, y: p.y - 1}, true
+			case edge == bottomEdge && p.y == unitHeight - 2:
+				return Point{x: p.x, y: p.y + 1}, true
+			}
+		}
+		return p, false
+	}
+
 	for i := 0; i < 2; i++ {
 		var p Point
 		switch i {
@@ -631,23 +859,31 @@ func (m *Maze) findEntranceAndExit(unitWidth, unitHeight int) (entranceUnitColum
 			p = Point{x: exitUnitColumn, y: exitUnitRow}
 		}
 
-		var horizontal bool
-
 		// The entrance and exit actually need to be on the outer walls, not
 		// the outer corridors.
-		switch {
-		case p.x == 1:              // On left edge of maze.
-			p.x--
-			horizontal = false
-		case p.x == unitWidth - 2:  // On right edge of maze.
-			p.x++
-			horizontal = false
-		case p.y == 1:              // On top edge of maze.
-			p.y--
-			horizontal = true
-		case p.y == unitHeight - 2: // On bottom edge of maze.
-			p.y++
-			horizontal = true
+		p, horizontal := toOuterWall(p, []int{leftEdge, rightEdge, topEdge, bottomEdge})
+
+		// If nothing else in the outer corridor can be reached (in a
+		// maze with only one room, say), the exit can end up on top of
+		// the entrance.  For a thickness of 1, it can also end up next
+		// to the entrance with nothing between them but a stub of wall
+		// that isn't attached to anything.  Try the edges in the
+		// opposite order so that the two end up on different sides.
+		crowded := false
+		if i == 1 {
+			entrance := Point{x: entranceUnitColumn, y: entranceUnitRow}
+			sameEdge := p.x == entrance.x || p.y == entrance.y
+			switch distance := abs(p.x - entrance.x) + abs(p.y - entrance.y); {
+			case distance == 0:
+				crowded = true
+			case distance == 2 && sameEdge && m.thickness == 1:
+				stub := Point{x: (p.x + entrance.x) / 2, y: (p.y + entrance.y) / 2}
+				inside := Point{x: min(max(stub.x, 1), unitWidth - 2), y: min(max(stub.y, 1), unitHeight - 2)}
+				crowded = m.cell(m.offset(inside.x, inside.y)) == m.floor
+			}
+		}
+		if crowded {
+			p, horizontal = toOuterWall(Point{x: exitUnitColumn, y: exitUnitRow}, []int{bottomEdge, topEdge, rightEdge, leftEdge})
 		}
 
 		// Update the entrance and exit positions post-adjustment.
@@ -659,556 +895,779 @@ func (m *Maze) findEntranceAndExit(unitWidth, unitHeight int) (entranceUnitColum
 		}
 
 		// Knock out the entrance/exit itself.
-		if m.thickness == 1 {
-			m.cells[m.offset(p.x, p.y)] = m.floor
-		} else {
-
-			x, y, width, height := m.unitCoordinatesToRect(p.x, p.y)
+		m.cutBorderOpening(point{p.x, p.y}, horizontal, unitWidth, unitHeight)
+	}
 
-			if m.thickness != 2 {
-				// Only cut the interior of the entrance and
-				// exit; leave the borders on the sides.
-				if horizontal {
-					x, width = x + 1, width - 2
-				} else {
-					y, height = y + 1, height - 2
-				}
-			}
+	return entranceUnitColumn, entranceUnitRow, exitUnitColumn, exitUnitRow, longestDistance + 2
+}
 
-			for row := y; row < y + height; row++ {
-				for column := x; column < x + width; column++ {
-					m.cells[m.offset(column, row)] = m.floor
-				}
-			}
+// Cuts an opening through the border wall at the given unit, which lies in
+// the top or bottom border if horizontal is true, and in the left or right
+// border otherwise.
+func (m *Maze) cutBorderOpening(p point, horizontal bool, unitWidth, unitHeight int) {
+	x, y, width, height := m.unitCoordinatesToRect(p.x, p.y)
+	if m.thickness > 2 {
+		// Only cut the interior of the opening; leave the borders on
+		// the sides.
+		if horizontal {
+			x, width = x + 1, width - 2
+		} else {
+			y, height = y + 1, height - 2
 		}
 	}
 
-	return entranceUnitColumn, entranceUnitRow, exitUnitColumn, exitUnitRow, longestDistance + 2
+	// The unit grid doesn't always reach the right and bottom edges of the
+	// maze.  When drawing over a thicker maze whose grid reaches further,
+	// its border wall is still out there, so we need to cut through that,
+	// too.
+	switch {
+	case p.x == unitWidth - 1:
+		width = m.width - x
+	case p.y == unitHeight - 1:
+		height = m.height - y
+	}
+	m.clearRect(x, y, width, height)
+	m.touchUpWalls(x, y, width, height)
 }
 
 
-// Generates a maze by drawing it on top of any existing runes in m.cells that
+// Generates a maze by drawing it on top of any existing runes in the maze that
 // are already present.  Only the blank cells (with a value equal to m.floor)
 // will be overwritten.  (This is the key to getting the maze-within-a-maze
 // effect to work.)
 //
-// This is the main generation function.
+// This is the main generation function.  The algorithm itself lives in
+// generator.go, so that it can also be run a step at a time.
 func (m *Maze) Generate() {
+	m.GenerateContext(context.Background())
+}
 
-	// If we need to bias the walls of a square maze due to minWallLength
-	// being large (so as to make it unicursal), then we choose the
-	// direction at random before rendering.
-	horizontalDirections := []struct {x, y int}{directions[0], directions[2]}
-	verticalDirections := []struct {x, y int}{directions[1], directions[3]}
-	var unicursalBiasDirections []struct {x, y int}
-	if rand.Intn(2) > 0 {
-		unicursalBiasDirections = horizontalDirections
-	} else {
-		unicursalBiasDirections = verticalDirections
-	}
+// Like Generate(), but gives up when the context is done, returning the
+// context's error and leaving the maze half-drawn.  Returns ErrIncomplete if
+// there was no room for an entrance and exit.
+func (m *Maze) GenerateContext(ctx context.Context) error {
+	return m.run(ctx, m.Generator())
+}
 
+// Erases the maze and generates a cumulative maze, using each successive
+// thickness to make smaller and smaller walls.  The thicknesses should be in
+// descending order.
+//
+// Afterward, any part of the maze that the later passes sealed off is
+// reconnected (see repair.go.)
+func (m *Maze) GenerateNested(thicknessValues []int) {
+	m.GenerateNestedContext(context.Background(), thicknessValues)
+}
 
-	// The dimensions must be such that they can fit in an odd number of
-	// cells of size TxT, where T is the thickness.
-	unitWidth, unitHeight := m.width, m.height
-	if m.thickness == 2 {
-		unitWidth = m.width / 2
-		unitHeight = m.height / 2
-	} else if m.thickness > 1 {
-		unitWidth = (m.width - 1) / (m.thickness - 1)
-		unitHeight = (m.height - 1) / (m.thickness - 1)
-	}
-	if unitWidth % 2 == 0 {
-		unitWidth--
+// Like GenerateNested(), but with the same cancellation and errors as
+// GenerateContext().
+func (m *Maze) GenerateNestedContext(ctx context.Context, thicknessValues []int) error {
+	return m.run(ctx, m.NestedGenerator(thicknessValues))
+}
+
+// Runs the generator to completion or until the context is done.
+func (m *Maze) run(ctx context.Context, g *Generator) error {
+	g.SetContext(ctx)
+	for g.Step() {
 	}
-	if unitHeight % 2 == 0 {
-		unitHeight--
+	return g.Err()
+}
+
+func  (m *Maze) Set(x, y int, cell rune) {
+	if m != nil && m.valid(x, y) {
+		m.setCell(m.offset(x, y), cell)
 	}
+}
 
-	// Draw a ring of walls around the maze.
-	//
-	// TODO: We shouldn't overwrite the border where it already exists,
-	// and that will necessitate different algorithms for thicknesses of 1
-	// and >1.
-	if m.thickness == 1 {
-		// For a thickness of 1, this looks better than a bunch of
-		// intersections.
-		m.drawRect(0, 0, unitWidth, unitHeight, m.floor)
-	} else {
-		// Iterate over the outer perimeter of the maze.
-		for _, p := range(rectPerimeter(unitWidth, unitHeight)) {
-			x, y, width, height := m.unitCoordinatesToRect(p.x, p.y)
-			m.drawRect(x, y, width, height, m.fill)
+func  (m *Maze) Get(x, y int) rune {
+	if m != nil && m.valid(x, y) {
+		return m.cell(m.offset(x, y))
+	}
+	return 0
+}
 
-		}
+// Replaces the maze with one read from text, such as the output of Print().
+// The display runes and thickness must already match the ones the maze was
+// drawn with.  So must the box-drawing style, if there was one; its glyphs
+// are read back as the walls they were drawn for (see unjoinedCell().)
+// Short lines are padded with m.floor.
+//
+// Since the text doesn't say where the entrance and exit are, they are taken
+// to be the first two openings in the border wall, going clockwise from the
+// upper-left corner.
+func (m *Maze) Load(r io.Reader) error {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimRight(scanner.Text(), "\r"))
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for len(lines) > 0 && lines[len(lines) - 1] == "" {
+		lines = lines[:len(lines) - 1]
 	}
 
-	// The actual maze algorithm.
-	//
-	// 1. Consider only the odd coordinates of the maze.  Record which are
-	//    completely unoccupied and which are not.
-	// 2. Select an odd coordinate at random that is NOT completely
-	//    unoccupied.
-	// 3. Attempt to draw a line in a randomly-chosen cardinal direction
-	//    (recall that a "unit" is a TxT square of cells, where T is
-	//    m.thickness.)  The line may have any odd length as long as it is
-	//    greater than 3 and it does not touch any other occupied units.
-	// 4. If the drawing attempt succeeds, mark the odd coordinates that
-	//    the line touched as occupied.
-	// 5. If there is still at least one unoccupied coordinate, return to
-	//    step 2.
-	// 6. Punch holes through the outside walls at two randomly-chosen points
-	//    (preferably facing in different cardinal directions) to
-	//    represent to entrance and exit.
-
-	// STEP 1
-	//
-	// Count the number of odd coordinates in the map that are completely
-	// unoccupied.  Those are our targets -- where we wish to draw the
-	// maze.
-	//
-	// Suppose our thickness is 1 and we have an existing cells buffer
-	// that looks like this (clearly the result of this maze renderer's
-	// previous handiwork):
-	//
-	// +--+  +--+--+--+
-	// |..|  |..|..|..|
-	// |..|  |..|..|..|
-	// +--+  +--+--+--+
-	// |..|        |..|
-	// |..|        |..|
-	// +--+--+--+  +--+
-	// |..|..|..|  |..|
-	// |..|..|..|  |..|
-	// +--+--+--+  +--+
-	//
-	// From the perspective of the present rendering algorithm, here is
-	// the coordinate analysis:
-	//
-	//    1 2 3 4 5 6 7 8 9 10111213141516
-	//  1 * * * * .   * * * * * * * * * *
-	//  2 * * * *     * * * * * * * * * *
-	//  3 * * * * .   * * * * * * * * * *
-	//  4 * * * *     * * * * * * * * * *
-	//  5 * * * * .   .   .   .   * * * *
-	//  6 * * * *                 * * * *
-	//  7 * * * * * * * * * * .   * * * *
-	//  8 * * * * * * * * * *     * * * *
-	//  9 * * * * * * * * * * .   * * * *
-	// 10 * * * * * * * * * *     * * * *
-	//
-	// Since only odd coordinates are considered, there are 3 + 2 + 3 = 8
-	// available spaces.
-	//
-	// But drawing a line from (say) (7, 11) to (5, 11) would seal off the
-	// northwest corridor and instantly ruin the maze.  As it turns out,
-	// any unit cell without at least three liberties -- three places to
-	// draw walls to -- is unusable by the maze algorithm.  All eight of the
-	// available cells in this maze have either two liberties or one, so
-	// this maze is actually complete with 0 available spaces.
-
-	numberOfUnoccupiedUnits := 0
-	for unitRow := 0; unitRow < unitHeight; unitRow += 2 {
-		for unitColumn := 0; unitColumn < unitWidth; unitColumn += 2 {
-			x, y, width, height := m.unitCoordinatesToRect(unitColumn, unitRow)
-			if m.rectIsPassage(x, y, width, height) {
-				numberOfUnoccupiedUnits += 1
-			}
+	rows := [][]rune{}
+	width := 0
+	for _, line := range(lines) {
+		rows = append(rows, m.parseGlyphs(line))
+		width = max(width, len(rows[len(rows) - 1]))
+	}
+	if width == 0 {
+		return fmt.Errorf("the maze is empty")
+	}
+	m.setSize(width, len(lines))
+	m.Clear()
+	for y, row := range(rows) {
+		for x, c := range(row) {
+			m.setCell(m.offset(x, y), m.unjoinedCell(c))
 		}
 	}
 
-	// Another helper routine.
-	//
-	// Prints a minimal representation of the status of occupied and
-	// unoccupied unit rectangles in the maze.  I only use this as a
-	// sanity check for myself.
-	printOccupiedUnitsDebug := func() {
-		if m.verbosity < 1 {
-			return
-		}
-		for unitRow := 0; unitRow < unitHeight; unitRow += 2 {
-			for unitColumn := 0; unitColumn < unitWidth; unitColumn += 2 {
-				x, y, width, height := m.unitCoordinatesToRect(unitColumn, unitRow)
-				unitUnoccupied := m.rectContains(x, y, width, height, m.floor)
-				fmt.Printf("%5v ", unitUnoccupied)
-			}
-			fmt.Printf("\n")
+	// There's no telling which opening is which, so the first one is the
+	// entrance and the rest are exits.
+	m.entrances, m.exits = nil, nil
+	for i, p := range(m.borderOpenings()) {
+		r := m.unitRect(p)
+		if i == 0 {
+			m.entrances = append(m.entrances, r)
+		} else {
+			m.exits = append(m.exits, r)
 		}
 	}
-	printOccupiedUnitsDebug()
+	return nil
+}
 
-	misses := 0
-	wallCount := 0
-	for numberOfUnoccupiedUnits > 0 {
+func (m *Maze) Print() {
+	m.WriteText(os.Stdout)
+}
 
-		// STEP 2
-		//
-		// Select a random position in which to begin.
-		//
-		// The unit width and height are odd, so round the range up.
-		//
-		// For instance, if the unit width was 5, unitRow would be one
-		// of 0, 2, or 4.
-		unitRow := 2 * rand.Intn(unitHeight / 2 + 1)
-		unitColumn := 2 * rand.Intn(unitWidth / 2 + 1)
-
-		// Note that the actual wall length will always be between 3
-		// and potentialWallLength, regardless of what minWallLength and
-		// maxWallLength are set to.  They are _guidelines_, not rigid
-		// constraints.
-		var (
-			minWallLength int = m.minWallLength
-			maxWallLength int = m.maxWallLength
-		)
-		minWallLength += (minWallLength + 1) % 2                        // Round even minimum lengths up to the next highest odd number
-		maxWallLength -= (maxWallLength + 1) % 2                        // Round even maximum lengths down to the next lowest odd number
-		if maxWallLength < minWallLength {
-			maxWallLength = minWallLength // minWallLength <= maxWallLength
+// Writes the maze as Print() does.  The rows are rendered one at a time, so
+// this works for mazes far too big to render all at once.
+func (m *Maze) WriteText(w io.Writer) error {
+	out := bufio.NewWriter(w)
+	for y := 0; y < m.height; y++ {
+		for _, s := range(m.renderedRow(y)) {
+			out.WriteString(s)
 		}
+		if err := out.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	return out.Flush()
+}
 
-		randomDirection := directions[rand.Intn(4)]
-
-		// If longer walls are to be had in one orientation, bias the
-		// "random" directions slightly in favor of that orientation.
-		switch {
-		case minWallLength > unitWidth - 2 && minWallLength > unitHeight - 2 && unitWidth == unitHeight:
-			// Bias the maze in a consistent set of directions
-			// that were chosen in advance.
-			randomDirection = unicursalBiasDirections[rand.Intn(2)]
-		case minWallLength > unitHeight - 2 && (minWallLength < unitWidth - 2 || unitWidth > unitHeight):
-			// Bias the maze in the horizontal direction.
-			if rand.Intn(5) >= 0 {
-				randomDirection = horizontalDirections[rand.Intn(2)]
+// Parses thickness arguments, each of which is a comma-separated list, into
+// a single list.  The values must be unique, and they are sorted in
+// descending order.
+func parseThicknessValues(lists []string) ([]int, error) {
+	thicknessValues := []int{}
+	for _, values := range(lists) {
+		for _, value := range(strings.Split(values, ",")) {
+			value := strings.TrimSpace(value)
+			if value == "" {
+				continue
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("token \"%v\" of \"%v\" could not be converted to an integer", value, values)
+			}
+			if n < 1 {
+				return nil, fmt.Errorf("invalid value %v in \"%v\": the minimum thickness is 1", n, values)
 			}
-		case minWallLength > unitWidth - 2 && (minWallLength < unitHeight - 2 || unitHeight > unitWidth):
-			// Bias the maze in the vertical direction.
-			if rand.Intn(5) >= 0 {
-				randomDirection = verticalDirections[rand.Intn(2)]
+			if slices.Contains(thicknessValues, n) {
+				return nil, fmt.Errorf("duplicate value %v in \"%v\"", n, values)
 			}
+			thicknessValues = append(thicknessValues, n)
 		}
+	}
+	sort.Slice(thicknessValues, func(i, j int) bool {
+		return thicknessValues[i] >= thicknessValues[j]
+	})
+	return thicknessValues, nil
+}
 
-		vx, vy :=  randomDirection.x, randomDirection.y
+// The command-line arguments that describe the maze to generate.  These are
+// shared by main() and by the subcommands.
+type mazeArguments struct {
+	width, height *int
+	thickness *[]string
+	config *Config
+	configFile *string
+	theme *string
+	floor, fill, intersection, horizontal, vertical *string
+	boxDrawing *string
+	verbosity *int
+	logFormat *string
+	minWallLength, maxWallLength *int
+	seed *string
+	maxWalls *int
+	entrances, exits *[]string
+	rooms, solids *[]string
+	difficulty *string
+	requirements *[]string
+	attempts *int
+	timeout *string
+	packed *bool
+
+	// Filled in by newMaze().
+	thicknessValues []int
+	constraints []Constraint
+	timeoutValue time.Duration
+}
 
-		x, y, width, height := m.unitCoordinatesToRect(unitColumn, unitRow)
-		if m.rectContains(x, y, width, height, m.floor) {
-			// The randomly-selected unit was not occupied, so we
-			// can't make a wall here.  Try again!
-			misses++
-			continue
-		}
+// The defaults come from the configuration file, if there is one (see
+// LoadConfig().)
+func addMazeArguments(parser *argparse.Parser, config *Config) *mazeArguments {
+	m := NewMaze(79, 25)
+	a := &mazeArguments{config: config}
+	a.configFile = parser.String("", "config", &argparse.Options{
+		Required: false,
+		Help: "A JSON or TOML file with default values for these arguments and additional themes.  The default is config.toml or config.json in the simple-maze directory of the user's configuration directory, if either exists",
+		Default: "",
+	})
+	a.width = parser.Int("W", "width", &argparse.Options{
+		Required: false,
+		Help: "The width of the maze, in characters",
+		Default: config.intDefault("width", m.width),
+	})
+	a.height = parser.Int("H", "height", &argparse.Options{
+		Required: false,
+		Help: "The height of the maze, in characters",
+		Default: config.intDefault("height", m.height),
+	})
+	a.thickness = parser.StringList("t", "thickness", &argparse.Options{
+		Required: false,
+		Help: "The thickness of the maze walls (or, equivalently, the size of the maze cells) in characters; the minimum value is 1.  Providing a comma-separated list of unique integers will produce nested mazes",
+		Default: []string{config.stringDefault("thickness", strconv.Itoa(m.thickness))},
+	})
+	a.theme = parser.String("T", "theme", &argparse.Options{
+		Required: false,
+		Help: fmt.Sprintf("The set of characters to draw the maze with (one of %v.)  The options for individual characters override the theme", strings.Join(themeNames(config), ", ")),
+		Default: config.stringDefault("theme", "ascii"),
+	})
+	a.floor = parser.String("f", "floor", &argparse.Options{
+		Required: false,
+		Help: "The character to use for empty corridor spaces.  The default comes from the theme",
+		Default: config.stringDefault("floor", ""),
+	})
+	a.fill = parser.String("F", "fill", &argparse.Options{
+		Required: false,
+		Help: "The character to use between walls when thickness > 2.  The default comes from the theme",
+		Default: config.stringDefault("fill", ""),
+	})
+	a.intersection = parser.String("i", "intersection", &argparse.Options{
+		Required: false,
+		Help: "The character to use for junctions between maze walls.  The default comes from the theme",
+		Default: config.stringDefault("intersection", ""),
+	})
+	a.horizontal = parser.String("x", "horizontal", &argparse.Options{
+		Required: false,
+		Help: "The character to use for horizontal maze walls.  The default comes from the theme",
+		Default: config.stringDefault("horizontal", ""),
+	})
+	a.vertical = parser.String("y", "vertical", &argparse.Options{
+		Required: false,
+		Help: "The character to use for vertical maze walls.  The default comes from the theme",
+		Default: config.stringDefault("vertical", ""),
+	})
+	a.boxDrawing = parser.String("b", "box-drawing", &argparse.Options{
+		Required: false,
+		Help: fmt.Sprintf("Draws the walls with Unicode box-drawing characters that join up properly at corners and junctions, in the given style (one of %v, or \"none\".)  This replaces --intersection, --horizontal and --vertical in the output.  The default comes from the theme", strings.Join(boxStyleNames(), ", ")),
+		Default: config.stringDefault("box-drawing", ""),
+	})
+	a.verbosity = parser.FlagCounter("v", "verbose", &argparse.Options{
+		Required: false,
+		Help: "Verboseness (logs auxiliary information about the maze to standard error.)  Repeat twice to log every wall as well",
+	})
+	a.logFormat = parser.String("", "log-format", &argparse.Options{
+		Required: false,
+		Help: "The format of the -v log: \"json\" (one object per line) or \"text\" (key=value pairs)",
+		Default: config.stringDefault("log-format", "json"),
+	})
+	a.minWallLength = parser.Int("m", "min", &argparse.Options{
+		Required: false,
+		Help: "The desired minimum wall length, in cells.  This is normally a guideline rather than a constraint, but if this value exceeds the maximum horizontal or vertical wall length, all walls will have the maximum length",
+		Default: config.intDefault("min", m.minWallLength),
+	})
+	a.maxWallLength = parser.Int("M", "max", &argparse.Options{
+		Required: false,
+		Help: "The desired maximum wall length, in cells.  This is a guideline, not a constraint, and will be met on a best-effort basis",
+		Default: config.intDefault("max", m.maxWallLength),
+	})
+	a.seed = parser.String("s", "seed", &argparse.Options{
+		Required: false,
+		Help: "A seed value for the random number generator.  You can use any string.  The default is an empty string, which seeds the generator based on the current time in nanoseconds",
+		Default: config.stringDefault("seed", ""),
+	})
+	a.maxWalls = parser.Int("", "max-walls", &argparse.Options{
+		Required: false,
+		Help: "If this is greater than 0, then maze generation will end after this many walls are placed.  Low values will result in an incomplete maze, which can be useful to illustrate the algorithm",
+		Default: config.intDefault("max-walls", m.maxWalls),
+	})
+	a.entrances = parser.StringList("", "entrance", &argparse.Options{
+		Required: false,
+		Help: "Where to put the entrance instead of as far from the exit as possible: \"left:5\" for the opening in the left border at cell row 5 (or \"right\", or \"top\" or \"bottom\" with a cell column), \"left:*\" or \"*\" for wherever on that side or anywhere in the border is farthest from the other openings, \"center\" for the room in the middle, or \"x,y\" for the room containing that cell.  May be repeated for several entrances",
+	})
+	a.exits = parser.StringList("", "exit", &argparse.Options{
+		Required: false,
+		Help: "Where to put the exit, in the same form as --entrance.  May be repeated for several exits; the solution leads to the nearest",
+	})
+	a.rooms = parser.StringList("", "room", &argparse.Options{
+		Required: false,
+		Help: "Sets aside an open room, walled off from the rest of the maze except for its doors, as \"x,y,widthxheight\" in cells, optionally preceded by \"name=\" and followed by \":doors\" (1 by default.)  May be repeated",
+	})
+	a.solids = parser.StringList("", "solid", &argparse.Options{
+		Required: false,
+		Help: "Sets aside a solid block that the maze goes around, as \"x,y,widthxheight\" in cells.  May be repeated",
+	})
+	a.difficulty = parser.String("d", "difficulty", &argparse.Options{
+		Required: false,
+		Help: fmt.Sprintf("Keeps generating mazes from seeds derived from --seed until one falls within the given difficulty band (one of %v), then reports the seed that produced it", strings.Join(difficultyNames(), ", ")),
+		Default: config.stringDefault("difficulty", ""),
+	})
+	a.requirements = parser.StringList("r", "require", &argparse.Options{
+		Required: false,
+		Help: "Like --difficulty, but with an explicit constraint on one of the metrics reported by --stats, such as \"solutionLength>=40\" or \"deadEnds>=20\".  May be repeated",
+	})
+	a.attempts = parser.Int("", "attempts", &argparse.Options{
+		Required: false,
+		Help: "For --difficulty and --require: the maximum number of mazes to generate before settling for the closest one",
+		Default: config.intDefault("attempts", 1000),
+	})
+	a.timeout = parser.String("", "timeout", &argparse.Options{
+		Required: false,
+		Help: "Gives up if generating the maze takes longer than this (for example, \"500ms\" or \"10s\".)  The default, 0, means no limit",
+		Default: config.stringDefault("timeout", "0"),
+	})
+	a.packed = parser.Flag("", "packed", &argparse.Options{
+		Required: false,
+		Help: "Stores the maze in as few bits per cell as its runes need instead of four bytes: two for a maze of thickness 1, four once the fill rune appears, and 8, 16 or 32 if more runes (such as a solution drawn in) come along.  This is slower, but it makes very large mazes fit in memory",
+	})
+	return a
+}
 
-		// STEP 3
-		//
-		// Will we be able to plop down at least three units (counting
-		// the starting position)?
-		//
-		// Yes, the potential wall length starts at -1.  Remember that
-		// it increments in units of two, and that wall lengths must
-		// always be odd.
-		potentialWallLength := -1
-		currentUnitRow, currentUnitColumn := unitRow, unitColumn
-		for {
-			x, y, width, height := m.unitCoordinatesToRect(currentUnitColumn, currentUnitRow)
-			if potentialWallLength == -1 || m.rectContains(x, y, width, height, m.floor) {
-				// This spot's clear.  (Or we're in our
-				// starting position, which is always valid.)
-				//
-				// Notice how we advance in groups of two
-				// units in order to keep the coordinates
-				// odd.
-				potentialWallLength += 2
-				currentUnitColumn += 2 * vx
-				currentUnitRow += 2 * vy
-
-				// If we just moved off-screen, we're done.
-				// (This also indicates that we started on a
-				// border wall and our random direction vector
-				// pointed outward -- our potentialWallLength
-				// right now is exactly +1.)
-				if currentUnitColumn < 0 || currentUnitRow < 0 || currentUnitColumn >= unitWidth || currentUnitRow >= unitHeight {
-					break
-				}
-			} else {
-				// This spot's occupied, so we're done.
-				break
-			}
-		}
-		if potentialWallLength < 3 {
-			// Can't draw a wall in this direction from this
-			// position.
-			continue
-		}
+// Checks the parsed arguments and returns an empty maze with the requested
+// dimensions, display runes and generation parameters.  Call generate() to
+// fill it in.
+//
+// If the arguments are invalid, this prints an explanation along with the
+// parser's usage message, and the second return value is false.
+func (a *mazeArguments) newMaze(parser *argparse.Parser) (Maze, bool) {
+	badCharacterMessage := func(charType, value string) {
+		fmt.Fprintf(os.Stderr,
+			"The %v argument, \"%v\", is not a single character.  It must be one character or emoji.\n",
+			charType,
+			value)
+		fmt.Print(parser.Usage(nil))
+	}
+	switch {
+	case *a.fill != "" && !isSingleGlyph(*a.fill):
+		badCharacterMessage("fill", *a.fill)
+		return Maze{}, false
+	case *a.floor != "" && !isSingleGlyph(*a.floor):
+		badCharacterMessage("floor", *a.floor)
+		return Maze{}, false
+	case *a.intersection != "" && !isSingleGlyph(*a.intersection):
+		badCharacterMessage("intersection", *a.intersection)
+		return Maze{}, false
+	case *a.horizontal != "" && !isSingleGlyph(*a.horizontal):
+		badCharacterMessage("horizontal", *a.horizontal)
+		return Maze{}, false
+	case *a.vertical != "" && !isSingleGlyph(*a.vertical):
+		badCharacterMessage("vertical", *a.vertical)
+		return Maze{}, false
+	}
 
-		// Now that we know how long a wall we can draw, we choose the
-		// actual length at random.
-		minWallLength = min(potentialWallLength, max(3, minWallLength)) // 3 <= minWallLength <= potentialWallLength
-		maxWallLength = min(potentialWallLength, max(3, maxWallLength)) // 3 <= maxWallLength <= potentialWallLength
-
-		if potentialWallLength > maxWallLength {
-			// We're drawing a wall that's too short or too long.
-			// We'll allow it...some of the time.  (This
-			// time-wasting strategy will make the maze provide
-			// longer walls more often.)
-			if rand.Intn(50) > 0 {
-				misses++
-				continue
-			}
+	thicknessValues, err := parseThicknessValues(*a.thickness)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not use --thickness: %v.\n", err)
+		fmt.Print(parser.Usage(nil))
+		return Maze{}, false
+	}
+	a.thicknessValues = thicknessValues
+
+	a.constraints = []Constraint{}
+	if *a.difficulty != "" {
+		preset, ok := difficultyPresets[*a.difficulty]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown difficulty \"%v\"; expected one of %v.\n", *a.difficulty, strings.Join(difficultyNames(), ", "))
+			fmt.Print(parser.Usage(nil))
+			return Maze{}, false
 		}
-
-		// This produces a random odd number between minWallLength and maxWallLength.
-		wallLength := minWallLength + 2 * rand.Intn((maxWallLength - minWallLength) / 2 + 1)
-
-		if m.verbosity > 1 {
-			fmt.Printf("I was able to draw a wall from (%v, %v) to (%v, %v) -- %v units long.  Actually chose %v units (%v <= %v <= %v).\n",
-				unitColumn, unitRow,
-				unitColumn + vx * (potentialWallLength - 1), unitRow + vy * (potentialWallLength - 1),
-				potentialWallLength,
-				wallLength,
-				minWallLength, wallLength, maxWallLength)
+		a.constraints = append(a.constraints, preset...)
+	}
+	for _, requirement := range(*a.requirements) {
+		c, err := ParseConstraint(requirement)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not parse requirement: %v.\n", err)
+			fmt.Print(parser.Usage(nil))
+			return Maze{}, false
 		}
+		a.constraints = append(a.constraints, c)
+	}
 
-		// Draw the wall.
-		currentUnitRow, currentUnitColumn = unitRow, unitColumn
-		if m.thickness == 1 {
-			x1, y1, _, _ := m.unitCoordinatesToRect(currentUnitColumn, currentUnitRow)
-			x2, y2 := x1 + vx * (wallLength - 1), y1 + vy * (wallLength - 1)
-
-			// Force (x1, y1) to be the upper left corner of the rectangle.
-			x1, y1, x2, y2 = min(x1, x2), min(y1, y2), max(x1, x2), max(y1, y2)
+	entrances, err := ParseOpenings(*a.entrances)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not use --entrance: %v.\n", err)
+		fmt.Print(parser.Usage(nil))
+		return Maze{}, false
+	}
+	exits, err := ParseOpenings(*a.exits)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not use --exit: %v.\n", err)
+		fmt.Print(parser.Usage(nil))
+		return Maze{}, false
+	}
 
-			m.drawRect(x1, y1, x2 - x1 + 1, y2 - y1 + 1, m.fill)
-		} else {
-			for i := 0; i < wallLength; i++ {
-				x, y, width, height := m.unitCoordinatesToRect(currentUnitColumn, currentUnitRow)
-				m.drawRect(x, y, width, height, m.fill)
-				currentUnitColumn += vx
-				currentUnitRow += vy
+	regions := []Region{}
+	for _, list := range([]struct{kind string; values []string}{{"room", *a.rooms}, {"solid", *a.solids}}) {
+		for _, s := range(list.values) {
+			r, err := ParseRegion(list.kind, s)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not use --%v: %v.\n", list.kind, err)
+				fmt.Print(parser.Usage(nil))
+				return Maze{}, false
 			}
+			regions = append(regions, r)
 		}
+	}
 
-		// STEP 4
-		//
-		// We know how many empty (odd-numbered) cells we just drew over.
-		numberOfUnoccupiedUnits -= (wallLength - 1)/2
-		wallCount += 1
-		if m.maxWalls > 0 && wallCount >= m.maxWalls {
-			// The maze will almost certainly be incomplete if
-			// this number is low, but this is useful for
-			// illustration purposes.
-			break
-		}
-
-	} // end (while the maze is not full) [STEP 5]
+	a.timeoutValue, err = time.ParseDuration(*a.timeout)
+	if err != nil || a.timeoutValue < 0 {
+		fmt.Fprintf(os.Stderr, "Could not parse --timeout \"%v\"; expected a duration such as \"10s\".\n", *a.timeout)
+		fmt.Print(parser.Usage(nil))
+		return Maze{}, false
+	}
 
-	entranceUnitColumn, entranceUnitRow, exitUnitColumn, exitUnitRow, solutionDistance := m.findEntranceAndExit(unitWidth, unitHeight)
-	m.entrance.x, m.entrance.y, m.entrance.width, m.entrance.height = m.unitCoordinatesToRect(entranceUnitColumn, entranceUnitRow)
-	m.exit.x, m.exit.y, m.exit.width, m.exit.height = m.unitCoordinatesToRect(exitUnitColumn, exitUnitRow)
-	// m.drawRect(m.entrance.x, m.entrance.y, m.entrance.width, m.entrance.height, '1')
-	// m.drawRect(m.exit.x, m.exit.y, m.exit.width, m.exit.height, '2')
-	if m.verbosity > 0 {
-		fmt.Printf("Maze solution distance: %v.  Walls: %v.  Misses: %v.\n", solutionDistance, wallCount, misses)
+	// Start with the theme, and then apply the individual runes on top
+	// of it.
+	theme, err := findTheme(a.config, *a.theme)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not use --theme: %v.\n", err)
+		fmt.Print(parser.Usage(nil))
+		return Maze{}, false
+	}
+	for _, r := range([]struct{value string; target *string}{
+		{*a.floor, &theme.Floor},
+		{*a.fill, &theme.Fill},
+		{*a.intersection, &theme.Intersection},
+		{*a.horizontal, &theme.Horizontal},
+		{*a.vertical, &theme.Vertical},
+	}) {
+		if r.value != "" {
+			*r.target = r.value
+		}
+	}
+	switch *a.boxDrawing {
+	case "":
+		break
+	case "none":
+		theme.BoxDrawing = ""
+	default:
+		theme.BoxDrawing = *a.boxDrawing
 	}
-}
 
-func  (m *Maze) Set(x, y int, cell rune) {
-	if m != nil && m.valid(x, y) {
-		m.cells[m.offset(x, y)] = cell
+	var m Maze
+	if *a.packed {
+		m = NewPackedMaze(*a.width, *a.height)
+	} else {
+		m = NewMaze(*a.width, *a.height)
+	}
+	if err := m.SetTheme(theme); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not use the theme: %v.\n", err)
+		fmt.Print(parser.Usage(nil))
+		return Maze{}, false
 	}
+	logger, err := newLogger(os.Stderr, *a.logFormat, *a.verbosity)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not use --log-format: %v.\n", err)
+		fmt.Print(parser.Usage(nil))
+		return Maze{}, false
+	}
+	m.SetLogger(logger)
+	m.minWallLength = *a.minWallLength
+	m.maxWallLength = *a.maxWallLength
+	m.maxWalls = *a.maxWalls
+	m.SetOpenings(entrances, exits)
+	for _, r := range(regions) {
+		m.Reserve(r)
+	}
+	if len(a.thicknessValues) > 0 {
+		m.thickness = a.thicknessValues[len(a.thicknessValues) - 1]
+	}
+	m.SetSeed(*a.seed)
+	m.Clear()
+	return m, true
 }
 
-func  (m *Maze) Get(x, y int) rune {
-	if m != nil && m.valid(x, y) {
-		return m.cells[m.offset(x, y)]
+// Generates a cumulative maze, using each successive thickness to make
+// smaller and smaller walls, and searching for a seed that satisfies the
+// --difficulty and --require constraints if there are any.
+//
+// Returns false (after explaining why) if --timeout ran out first.
+func (a *mazeArguments) generate(m *Maze) bool {
+	m.log().Info("starting", "seed", m.Seed(), "seedValue", seedValue(m.Seed()), "thickness", a.thicknessValues)
+
+	ctx := context.Background()
+	if a.timeoutValue > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.timeoutValue)
+		defer cancel()
 	}
-	return 0
-}
 
-func (m *Maze) Print() {
-	for index, y := 0, 0; y < m.height; y++ {
-		for x := 0; x < m.width; x, index = x+1, index+1 {
-			fmt.Printf("%c", m.cells[index])
+	var err error
+	if len(a.constraints) == 0 {
+		err = m.GenerateNestedContext(ctx, a.thicknessValues)
+	} else {
+		var attemptsUsed int
+		attemptsUsed, err = m.GenerateWithConstraints(ctx, a.thicknessValues, m.Seed(), a.constraints, *a.attempts)
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v; using the closest maze instead.\n", err)
+			err = nil
+		}
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "Seed \"%v\" produced the maze after %v attempt(s).\n", m.Seed(), attemptsUsed)
 		}
-		fmt.Printf("\n")
 	}
+
+	switch {
+	case err == nil:
+		break
+	case errors.Is(err, ErrIncomplete):
+		fmt.Fprintf(os.Stderr, "Warning: %v; there was no room for an entrance and exit.\n", err)
+	case errors.Is(err, context.DeadlineExceeded):
+		fmt.Fprintf(os.Stderr, "Gave up after %v (see --timeout.)\n", a.timeoutValue)
+		return false
+	default:
+		fmt.Fprintf(os.Stderr, "Could not generate the maze: %v.\n", err)
+		return false
+	}
+	return true
 }
 
 func main() {
 
-	m := NewMaze(79, 25)
-	parser := argparse.NewParser("maze", "Generates a maze out of Unicode characters with an entrance and an exit.")
-	var w *int = parser.Int("W", "width", &argparse.Options{
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			validateMain(os.Args[1:])
+			return
+		case "play":
+			playMain(os.Args[1:])
+			return
+		case "chunks":
+			chunksMain(os.Args[1:])
+			return
+		case "batch":
+			batchMain(os.Args[1:])
+			return
+		case "serve":
+			serveMain(os.Args[1:])
+			return
+		case "dungeon":
+			dungeonMain(os.Args[1:])
+			return
+		}
+	}
+
+	config, err := LoadConfig(os.Args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read the configuration file: %v.\n", err)
+		return
+	}
+	parser := argparse.NewParser("maze", "Generates a maze out of Unicode characters with an entrance and an exit.  Run \"maze validate --help\" for the maze validator, \"maze play --help\" to walk through a maze in the terminal, \"maze chunks --help\" to generate a huge maze in chunks, \"maze batch --help\" to generate many mazes at once, \"maze serve --help\" to serve mazes over HTTP, or \"maze dungeon --help\" to generate a roguelike level of rooms and corridors.")
+	arguments := addMazeArguments(parser, config)
+	var statsFormat *string = parser.String("", "stats", &argparse.Options{
 		Required: false,
-		Help: "The width of the maze, in characters",
-		Default: m.width,
+		Help: "Prints statistics about the generated maze (dead ends, junctions, corridor lengths, solution length and so on) instead of the maze itself.  The format may be \"text\" or \"json\"",
+		Default: "",
 	})
-	var h *int = parser.Int("H", "height", &argparse.Options{
+	var printJSON *bool = parser.Flag("", "json", &argparse.Options{
 		Required: false,
-		Help: "The height of the maze, in characters",
-		Default: m.height,
+		Help: "Prints the maze as JSON, along with its solution and statistics, instead of as text.  Run with --json-schema for the format",
 	})
-	var t *[]string = parser.StringList("t", "thickness", &argparse.Options{
+	var printSchema *bool = parser.Flag("", "json-schema", &argparse.Options{
 		Required: false,
-		Help: "The thickness of the maze walls (or, equivalently, the size of the maze cells) in characters; the minimum value is 1.  Providing a comma-separated list of unique integers will produce nested mazes",
-		Default: []string{strconv.Itoa(m.thickness)},
+		Help: "Prints the JSON Schema for --json and exits",
 	})
-	var floor *string = parser.String("f", "floor", &argparse.Options{
+	var graphFormat *string = parser.String("", "graph", &argparse.Options{
 		Required: false,
-		Help: "The character to use for empty corridor spaces",
-		Default: string(m.floor),
+		Help: fmt.Sprintf("Prints the maze's passage graph (rooms and the doorways between them) instead of the maze itself.  The format may be %v", strings.Join(graphFormatNames(), ", ")),
+		Default: "",
 	})
-	var fill *string = parser.String("F", "fill", &argparse.Options{
+	var contract *bool = parser.Flag("", "contract", &argparse.Options{
 		Required: false,
-		Help: "The character to use between walls when thickness > 2",
-		Default: string(m.fill),
+		Help: "For --graph: keeps only the junctions, dead ends, entrance and exit, and turns the corridors between them into edges weighted by their length",
 	})
-	var intersection *string = parser.String("i", "intersection", &argparse.Options{
+	var colorName *string = parser.String("", "color", &argparse.Options{
 		Required: false,
-		Help: "The character to use for junctions between maze walls",
-		Default: string(m.intersection),
+		Help: "Colors the walls, fill, floor (unless it's blank), entrance and exit.  May be \"auto\" (only when printing to a terminal, and only if NO_COLOR is not set), \"never\", \"16\", \"256\" or \"truecolor\"",
+		Default: config.stringDefault("color", "auto"),
 	})
-	var horizontal *string = parser.String("x", "horizontal", &argparse.Options{
+	var heatMap *bool = parser.Flag("", "heat-map", &argparse.Options{
 		Required: false,
-		Help: "The character to use for horizontal maze walls",
-		Default: string(m.horizontal),
+		Help: "When printing in color, shades each floor cell by its distance from the entrance",
 	})
-	var vertical *string = parser.String("y", "vertical", &argparse.Options{
+	var showSolution *bool = parser.Flag("", "solution", &argparse.Options{
 		Required: false,
-		Help: "The character to use for vertical maze walls",
-		Default: string(m.vertical),
+		Help: "When printing in color, highlights the shortest path from the entrance to the exit",
 	})
-	var verbosity *int = parser.FlagCounter("v", "verbose", &argparse.Options{
+	var animate *bool = parser.Flag("", "animate", &argparse.Options{
 		Required: false,
-		Help: "Verboseness (prints auxiliary information in addition to the maze itself.)  Repeat twice for maximum verboseness.",
+		Help: "Redraws the maze in the terminal after each wall is placed, to show how the algorithm works",
 	})
-	var minWallLength *int = parser.Int("m", "min", &argparse.Options{
+	var delay *int = parser.Int("", "delay", &argparse.Options{
 		Required: false,
-		Help: "The desired minimum wall length, in cells.  This is normally a guideline rather than a constraint, but if this value exceeds the maximum horizontal or vertical wall length, all walls will have the maximum length",
-		Default: m.minWallLength,
+		Help: "For --animate and --gif: the time between frames, in milliseconds",
+		Default: 40,
 	})
-	var maxWallLength *int = parser.Int("M", "max", &argparse.Options{
+	var gifFile *string = parser.String("", "gif", &argparse.Options{
 		Required: false,
-		Help: "The desired maximum wall length, in cells.  This is a guideline, not a constraint, and will be met on a best-effort basis",
-		Default: m.maxWallLength,
+		Help: "If given, also writes an animated GIF of the maze being generated to this file",
+		Default: "",
 	})
-	var seed *string = parser.String("s", "seed", &argparse.Options{
+	var gifScale *int = parser.Int("", "gif-scale", &argparse.Options{
 		Required: false,
-		Help: "A seed value for the random number generator.  You can use any string.  The default is an empty string, which seeds the generator based on the current time in nanoseconds",
+		Help: "For --gif: the size of each character of the maze, in pixels",
+		Default: 4,
+	})
+	var dxfFile *string = parser.String("", "dxf", &argparse.Options{
+		Required: false,
+		Help: "If given, also writes the maze's wall segments to this file as a DXF drawing, for building a physical maze",
 		Default: "",
 	})
-	var maxWalls *int = parser.Int("", "max-walls", &argparse.Options{
+	var bomFile *string = parser.String("", "bom", &argparse.Options{
 		Required: false,
-		Help: "If this is greater than 0, then maze generation will end after this many walls are placed.  Low values will result in an incomplete maze, which can be useful to illustrate the algorithm",
-		Default: m.maxWalls,
+		Help: "If given, also writes a bill of materials (the lengths and counts of the wall panels to cut) to this file",
+		Default: "",
+	})
+	var pitch *float64 = parser.Float("", "pitch", &argparse.Options{
+		Required: false,
+		Help: "For --dxf and --bom: the distance between the centers of neighboring corridors, in millimeters",
+		Default: 300.0,
+	})
+	var wallThickness *float64 = parser.Float("", "wall-thickness", &argparse.Options{
+		Required: false,
+		Help: "For --dxf and --bom: the thickness of the wall panels, in millimeters",
+		Default: 18.0,
 	})
 
-	err := parser.Parse(os.Args)
+	err = parser.Parse(os.Args)
 	if err != nil {
 		fmt.Print(parser.Usage(err))
 		return
 	}
-	badCharacterMessage := func(charType, value string) {
-		fmt.Fprintf(os.Stderr,
-			"The %v argument, \"%v\", has too many characters.  String length must be 1.\n",
-			charType,
-			value)
-		fmt.Print(parser.Usage(err))
-	}
-	switch {
-	case utf8.RuneCountInString(*fill) > 1:
-		badCharacterMessage("fill", *fill)
-		return
-	case utf8.RuneCountInString(*floor) > 1:
-		badCharacterMessage("floor", *floor)
+	if *printSchema {
+		fmt.Print(MazeJSONSchema)
 		return
-	case utf8.RuneCountInString(*intersection) > 1:
-		badCharacterMessage("intersection", *intersection)
+	}
+	m, ok := arguments.newMaze(parser)
+	if !ok {
 		return
-	case utf8.RuneCountInString(*horizontal) > 1:
-		badCharacterMessage("horizontal", *horizontal)
+	}
+	colorMode, err := ParseColorMode(*colorName, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not use --color: %v.\n", err)
+		fmt.Print(parser.Usage(nil))
 		return
-	case utf8.RuneCountInString(*vertical) > 1:
-		badCharacterMessage("vertical", *vertical)
+	}
+	if *gifScale < 1 {
+		fmt.Fprintf(os.Stderr, "The --gif-scale must be at least 1.\n")
+		fmt.Print(parser.Usage(nil))
 		return
 	}
-
-	// Ensure that the thickness values are unique, and sort them in
-	// descending order.
-	thicknessValues := []int{}
-	for _, values := range(*t) {
-		for _, value := range(strings.Split(values, ",")) {
-			value := strings.TrimSpace(value)
-			if value == "" {
-				continue
-			}
-			n, err := strconv.Atoi(value)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Could not parse thickness argument \"%v\": token \"%v\" could not be converted to an integer.\n", values, value)
-				fmt.Print(parser.Usage(nil))
-				return
-			}
-			for i := range(thicknessValues) {
-				if thicknessValues[i] == n {
-					fmt.Fprintf(os.Stderr, "Duplicate value %v found in thickness argument \"%v\".\n", n, values)
-					fmt.Print(parser.Usage(nil))
-					return
-				}
-			}
-			thicknessValues = append(thicknessValues, n)
+	if *dxfFile != "" || *bomFile != "" {
+		if err := checkBuildDimensions(*pitch, *wallThickness); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not use --pitch and --wall-thickness: %v.\n", err)
+			fmt.Print(parser.Usage(nil))
+			return
 		}
 	}
-	sort.Slice(thicknessValues, func(i, j int) bool {
-		return thicknessValues[i] >= thicknessValues[j]
-	})
-	fmt.Fprintf(os.Stderr, "Thickness values: %v\n", thicknessValues)
-
-	// Seed the random number generator reproducibly.
-	hashAlgorithm := fnv.New64()
-	if *seed == "" {
-		// For the aid of reproducibility, convert the current
-		// timestamp to a hexadecimal string.
-		timestamp := time.Now().UTC().UnixNano()
-		b := make([]byte, 8)
-		binary.BigEndian.PutUint64(b, uint64(timestamp))
-		*seed = hex.EncodeToString(b)
+	if *statsFormat != "" && *statsFormat != "text" && *statsFormat != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown statistics format \"%v\"; expected \"text\" or \"json\".\n", *statsFormat)
+		fmt.Print(parser.Usage(nil))
+		os.Exit(1)
+	}
+	if *graphFormat != "" && !slices.Contains(graphFormatNames(), *graphFormat) {
+		fmt.Fprintf(os.Stderr, "Unknown --graph format \"%v\"; expected one of %v.\n", *graphFormat, strings.Join(graphFormatNames(), ", "))
+		fmt.Print(parser.Usage(nil))
+		return
 	}
-	hashAlgorithm.Write([]byte(*seed))
-	seedValue := int64(hashAlgorithm.Sum64())
-	switch *verbosity {
-	case 0:
-		break
-	case 1:
-		fmt.Fprintf(os.Stderr, "Random number seed: \"%v\"\n", *seed)
-	default:
-		fmt.Fprintf(os.Stderr, "Random number seed: \"%v\" (%v)\n", *seed, seedValue)
-	}
-	rand.Seed(seedValue)
-
-	// Generate a cumulative maze, using each successive thickness to make
-	// smaller and smaller walls.
-	m = NewMaze(*w, *h);
-	m.fill = ([]rune(*fill))[0]
-	m.floor = ([]rune(*floor))[0]
-	m.vertical = ([]rune(*vertical)[0])
-	m.verbosity = *verbosity;
-	m.horizontal = ([]rune(*horizontal))[0]
-	m.intersection = ([]rune(*intersection)[0])
-	m.minWallLength = *minWallLength
-	m.maxWallLength = *maxWallLength
-	m.maxWalls = *maxWalls
-	m.Clear()
-	// m.fill = '█'; m.vertical = '▒'; m.horizontal = '▒'; m.intersection = '▒'; m.floor = '░'
-	// ./simple_maze -F █ -y ▒ -x ▒ -i ▒ -f ░
 
-	for _, thickness := range thicknessValues {
-		m.thickness = thickness
-	m.Generate()
+	a := &animator{
+		delay: time.Duration(max(0, *delay)) * time.Millisecond,
+		colorMode: colorMode,
+		scale: *gifScale,
+	}
+	if *animate {
+		a.terminal = os.Stdout
+		fmt.Print("\x1b[2J")
+	}
+	if *gifFile != "" {
+		a.gif = &gif.GIF{}
+	}
+	if a.terminal != nil || a.gif != nil {
+		m.SetEventHandler(a.handle)
+	}
+	ok = arguments.generate(&m)
+	m.SetEventHandler(nil)
+	if *animate {
+		// The maze itself replaces the last frame.
+		fmt.Print("\x1b[H\x1b[J")
+	}
+	if !ok {
+		os.Exit(1)
+	}
+	if *gifFile != "" {
+		if err := a.writeGIF(&m, *gifFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write \"%v\": %v\n", *gifFile, err)
+		}
 	}
 
 
 	// x, y, width, height := m.unitCoordinatesToRect(2, 2)
 	// m.drawRect(x, y, (width - 1) * 2 + 1, (height - 1) * 2 + 1, m.floor)
 
-	m.Print()
+	switch *statsFormat {
+	case "":
+		if *graphFormat != "" {
+			m.Graph(*contract).Write(os.Stdout, *graphFormat)
+		} else if *printJSON {
+			m.WriteJSON(os.Stdout)
+		} else if colorMode == NoColor {
+			if *heatMap || *showSolution {
+				fmt.Fprintf(os.Stderr, "Warning: --heat-map and --solution have no effect without color.\n")
+			}
+			m.Print()
+		} else {
+			m.WriteColor(os.Stdout, ColorOptions{Mode: colorMode, Solution: *showSolution, HeatMap: *heatMap})
+		}
+	case "text":
+		m.Stats().WriteText(os.Stdout)
+	case "json":
+		m.Stats().WriteJSON(os.Stdout)
+	}
+
+	writeFile := func(path string, write func(f *os.File) error) {
+		if path == "" {
+			return
+		}
+		f, err := os.Create(path)
+		if err == nil {
+			err = write(f)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not write \"%v\": %v\n", path, err)
+		}
+	}
+	writeFile(*dxfFile, func(f *os.File) error { return m.WriteDXF(f, *pitch, *wallThickness) })
+	writeFile(*bomFile, func(f *os.File) error { return m.WriteBillOfMaterials(f, *pitch, *wallThickness) })
+
 	//n := NewMazeOverExisting(m);
 	//n.Generate()
 	//n.Print()
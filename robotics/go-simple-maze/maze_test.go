@@ -0,0 +1,1926 @@
+package main
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The invariants that every call to GenerateNested() should uphold, no matter
+// what it is given:
+//
+//   1. It terminates, and it doesn't panic.
+//   2. If the maze is big enough to hold at least one room at the finest
+//      thickness, it passes Validate(): every open unit is connected to the
+//      entrance, and the exit can be reached.  Mazes of a single thickness
+//      are also perfect.
+//   3. The same seed always produces the same maze.
+
+// The parameters for a single maze.
+type mazeParameters struct {
+	width, height int
+	thicknessValues []int
+	minWallLength, maxWallLength int
+	seed string
+}
+
+func (p mazeParameters) String() string {
+	return fmt.Sprintf("-W %v -H %v -t %v -m %v -M %v -s %q", p.width, p.height, p.thicknessValues, p.minWallLength, p.maxWallLength, p.seed)
+}
+
+// Produces random parameters, including the degenerate ones that main() has
+// accepted in the past: tiny or even dimensions, and thicknesses of zero or
+// less.
+func randomMazeParameters(r *rand.Rand) mazeParameters {
+	p := mazeParameters{
+		width: r.Intn(70),
+		height: r.Intn(40),
+		minWallLength: r.Intn(12) - 2,
+		seed: fmt.Sprintf("%x", r.Int63()),
+	}
+	p.maxWallLength = p.minWallLength + r.Intn(20)
+	if r.Intn(4) == 0 {
+		p.maxWallLength = r.Intn(10)
+	}
+
+	// Thickness lists are sorted in descending order and free of
+	// duplicates, just as main() would give them to us.
+	for i := r.Intn(4); i >= 0; i-- {
+		n := r.Intn(12) - 2
+		if !slices.Contains(p.thicknessValues, n) {
+			p.thicknessValues = append(p.thicknessValues, n)
+		}
+	}
+	slices.Sort(p.thicknessValues)
+	slices.Reverse(p.thicknessValues)
+	return p
+}
+
+// Returns true if the finest pass of the given maze has room for a maze at
+// all.  Anything smaller is left blank.
+func (p mazeParameters) roomy() bool {
+	m := NewMaze(p.width, p.height)
+	m.thickness = max(1, p.thicknessValues[len(p.thicknessValues) - 1])
+	unitWidth, unitHeight := m.unitDimensions()
+	return unitWidth >= 3 && unitHeight >= 3
+}
+
+// Generates a maze with the given parameters, failing the test if generation
+// panics or takes too long.
+func generate(t testing.TB, p mazeParameters) *Maze {
+	t.Helper()
+	done := make(chan *Maze)
+	failure := make(chan interface{})
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				failure <- r
+			}
+		}()
+		m := NewMaze(p.width, p.height)
+		m.minWallLength = p.minWallLength
+		m.maxWallLength = p.maxWallLength
+		m.SetSeed(p.seed)
+		m.GenerateNested(p.thicknessValues)
+		done <- &m
+	}()
+
+	select {
+	case m := <-done:
+		return m
+	case r := <-failure:
+		t.Fatalf("%v: generation panicked: %v", p, r)
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%v: generation did not terminate", p)
+	}
+	return nil
+}
+
+// Checks invariants 2 and 3 for the given parameters.
+func checkInvariants(t testing.TB, p mazeParameters) {
+	t.Helper()
+	m := generate(t, p)
+	if p.roomy() {
+		// Validate() checks reachability unit by unit.  The rooms
+		// of a nested maze don't always line up with its doorways,
+		// so we can only follow the solution from room to room when
+		// there's a single thickness.
+		perfect := len(p.thicknessValues) == 1
+		if err := m.Validate(perfect); err != nil {
+			t.Errorf("%v: the maze is invalid:\n%v", p, err)
+		}
+		if perfect && m.solutionRooms() == nil {
+			t.Errorf("%v: there is no solution from room to room", p)
+		}
+	}
+
+	// An empty seed is replaced by one based on the time, so we use
+	// whatever seed the maze ended up with.
+	p.seed = m.Seed()
+	other := generate(t, p)
+	if !slices.Equal(m.cells, other.cells) || !slices.Equal(m.entrances, other.entrances) || !slices.Equal(m.exits, other.exits) {
+		t.Errorf("%v: the same seed produced two different mazes", p)
+	}
+}
+
+func TestGenerateInvariants(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	iterations := 500
+	if testing.Short() {
+		iterations = 50
+	}
+	for i := 0; i < iterations; i++ {
+		checkInvariants(t, randomMazeParameters(r))
+	}
+}
+
+// Thickness lists that once hung or left sealed pockets.
+func TestGenerateNestedRegressions(t *testing.T) {
+	for _, thicknessValues := range([][]int{{9, 5, 1}, {5, 3, 1}, {7, 3}, {4, 2}, {6, 3, 2, 1}}) {
+		for seed := 0; seed < 10; seed++ {
+			checkInvariants(t, mazeParameters{
+				width: 61,
+				height: 31,
+				thicknessValues: thicknessValues,
+				minWallLength: 3,
+				maxWallLength: 1 << 30,
+				seed: fmt.Sprint(seed),
+			})
+		}
+	}
+
+	// A nested pass whose thickness doesn't divide the one before it
+	// can leave a pocket of a single unit that can only be reached once
+	// some other pocket has been connected.
+	for _, seed := range([]string{"9est9c", "100", "156", "236"}) {
+		checkInvariants(t, mazeParameters{
+			width: 61,
+			height: 31,
+			thicknessValues: []int{5, 4},
+			minWallLength: 1,
+			maxWallLength: 7,
+			seed: seed,
+		})
+	}
+}
+
+// Dimensions too small to hold a maze should produce a blank one, not a
+// panic.
+func TestGenerateDegenerate(t *testing.T) {
+	for width := -1; width <= 6; width++ {
+		for height := -1; height <= 6; height++ {
+			for _, thickness := range([]int{-1, 0, 1, 2, 3, 4}) {
+				checkInvariants(t, mazeParameters{
+					width: width,
+					height: height,
+					thicknessValues: []int{thickness},
+					minWallLength: 3,
+					maxWallLength: 1 << 30,
+					seed: "degenerate",
+				})
+			}
+		}
+	}
+}
+
+func FuzzGenerate(f *testing.F) {
+	f.Add(61, 31, 1, 0, 3, 1 << 30, "seed")
+	f.Add(61, 31, 9, 5, 1, 7, "nested")
+	f.Add(2, 2, 0, -1, 0, 0, "")
+	f.Fuzz(func(t *testing.T, width, height, thickness, outerThickness, minWallLength, maxWallLength int, seed string) {
+
+		// Keep the mazes small enough that each input runs quickly.
+		p := mazeParameters{
+			width: abs(width % 64),
+			height: abs(height % 32),
+			thicknessValues: []int{thickness % 12},
+			minWallLength: minWallLength % 50,
+			maxWallLength: maxWallLength,
+			seed: seed,
+		}
+		if outer := outerThickness % 12; outer > p.thicknessValues[0] {
+			p.thicknessValues = append([]int{outer}, p.thicknessValues...)
+		}
+		checkInvariants(t, p)
+	})
+}
+
+// Saving a generator's state at any step and resuming it in a fresh maze
+// should produce the same maze as running it straight through.
+func TestGeneratorResume(t *testing.T) {
+	for _, thicknessValues := range([][]int{{1}, {3}, {5, 1}, {6, 3, 2, 1}}) {
+		p := mazeParameters{
+			width: 41,
+			height: 21,
+			thicknessValues: thicknessValues,
+			minWallLength: 3,
+			maxWallLength: 1 << 30,
+			seed: "resume",
+		}
+		expected := generate(t, p)
+
+		for stop := 0; ; stop++ {
+			m := NewMaze(p.width, p.height)
+			m.SetSeed(p.seed)
+			g := m.NestedGenerator(p.thicknessValues)
+			for i := 0; i < stop && g.Step(); i++ {
+			}
+			if g.Done() {
+				break
+			}
+			data, err := json.Marshal(g.State())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var state GeneratorState
+			if err := json.Unmarshal(data, &state); err != nil {
+				t.Fatal(err)
+			}
+
+			other := NewMaze(0, 0)
+			resumed, err := other.ResumeGenerator(state)
+			if err != nil {
+				t.Fatalf("%v: could not resume after %v steps: %v", p, stop, err)
+			}
+			for resumed.Step() {
+			}
+			if !slices.Equal(expected.cells, other.cells) || !slices.Equal(expected.entrances, other.entrances) || !slices.Equal(expected.exits, other.exits) {
+				t.Fatalf("%v: resuming after %v steps produced a different maze", p, stop)
+			}
+		}
+	}
+}
+
+// A deadline should stop even a huge maze promptly, and the errors should say
+// what happened.
+func TestGenerateContext(t *testing.T) {
+	m := NewMaze(2001, 1001)
+	m.minWallLength, m.maxWallLength = 999, 1999
+	m.SetSeed("context")
+	ctx, cancel := context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := m.GenerateNestedContext(ctx, []int{1}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2 * time.Second {
+		t.Errorf("generation took %v to notice the deadline", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	m = NewMaze(41, 21)
+	if err := m.GenerateContext(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a cancelled context to stop generation, got %v", err)
+	}
+
+	if err := m.GenerateNestedContext(context.Background(), []int{1}); err != nil {
+		t.Errorf("expected a 41x21 maze to succeed, got %v", err)
+	}
+	m = NewMaze(2, 2)
+	if err := m.GenerateNestedContext(context.Background(), []int{1}); !errors.Is(err, ErrIncomplete) {
+		t.Errorf("expected a 2x2 maze to be incomplete, got %v", err)
+	}
+}
+
+// A context that is cancelled after its Err() method has been called a
+// given number of times.
+type countdownContext struct {
+	context.Context
+	calls int
+}
+
+func (c *countdownContext) Err() error {
+	if c.calls--; c.calls < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+// A context that runs out while the pockets of a nested maze are being
+// connected should stop the repairs partway, and they should carry on when
+// the generator is resumed.
+func TestRepairContext(t *testing.T) {
+	generator := func() (*Generator, *int) {
+		m := NewMaze(61, 31)
+		m.minWallLength, m.maxWallLength = 1, 7
+		m.SetSeed("9est9c")
+		passages := 0
+		m.SetEventHandler(func(e Event) {
+			if e.Kind == PassageEvent {
+				passages++
+			}
+		})
+		return m.NestedGenerator([]int{5, 4}), &passages
+	}
+
+	// Count the checks, the last of which is made by the repairs once
+	// there's nothing left to repair.
+	counter := &countdownContext{Context: context.Background(), calls: 1 << 30}
+	g, passages := generator()
+	g.SetContext(counter)
+	for g.Step() {
+	}
+	checks, total, expected := 1 << 30 - counter.calls, *passages, g.Maze()
+	if g.Err() != nil || total == 0 {
+		t.Fatalf("expected some repairs, got %v: %v", total, g.Err())
+	}
+
+	g, passages = generator()
+	g.SetContext(&countdownContext{Context: context.Background(), calls: checks - 1})
+	for g.Step() {
+	}
+	if !errors.Is(g.Err(), context.Canceled) || g.phase != repairing || *passages != total {
+		t.Fatalf("expected the repairs to stop after %v passages, got %v in phase %v: %v", total, *passages, g.phase, g.Err())
+	}
+	g.SetContext(nil)
+	for g.Step() {
+	}
+	if g.Err() != nil || !g.Done() {
+		t.Fatalf("the repairs didn't resume: %v", g.Err())
+	}
+	if err := g.Maze().Validate(false); err != nil {
+		t.Errorf("the resumed maze is invalid: %v", err)
+	}
+	if !slices.Equal(g.Maze().cells, expected.cells) {
+		t.Errorf("the resumed maze differs from the one that wasn't interrupted")
+	}
+}
+
+// The candidate weights that are kept up to date as walls go in should
+// always match the ones we'd get by starting from scratch.
+func TestCandidateUpdates(t *testing.T) {
+	for _, p := range([]mazeParameters{
+		{width: 41, height: 21, thicknessValues: []int{1}, minWallLength: 3, maxWallLength: 1 << 30},
+		{width: 41, height: 21, thicknessValues: []int{1}, minWallLength: 5, maxWallLength: 9},
+		{width: 61, height: 31, thicknessValues: []int{5, 3, 1}, minWallLength: 3, maxWallLength: 7},
+		{width: 61, height: 31, thicknessValues: []int{6, 3, 2, 1}, minWallLength: 1, maxWallLength: 3},
+		{width: 31, height: 31, thicknessValues: []int{1}, minWallLength: 99, maxWallLength: 1 << 30},
+	}) {
+		p.seed = "candidates"
+		m := NewMaze(p.width, p.height)
+		m.minWallLength, m.maxWallLength = p.minWallLength, p.maxWallLength
+		m.SetSeed(p.seed)
+		for g := m.NestedGenerator(p.thicknessValues); g.Step(); {
+			if g.candidates == nil {
+				continue
+			}
+			fresh := *g
+			fresh.indexCandidates()
+			for direction := range(directions) {
+				if !slices.Equal(g.candidates[direction].weights, fresh.candidates[direction].weights) {
+					t.Fatalf("%v: the weights drifted after %v walls of thickness %v", p, g.wallCount, m.thickness)
+				}
+			}
+		}
+	}
+}
+
+// A weighted set should find the same indexes with 32-bit and 64-bit sums,
+// and each index should own exactly its weight's share of the total.
+func TestWeightedSet(t *testing.T) {
+	narrow := newWeightedSet(1000)
+	wide := &weightedSet{weights: make([]uint8, 1000), wideTree: make([]int64, 1001)}
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		index, weight := random.Intn(1000), []int{0, longWallWeight, fittingWallWeight}[random.Intn(3)]
+		narrow.set(index, weight)
+		wide.set(index, weight)
+	}
+	if narrow.total != wide.total {
+		t.Fatalf("the totals differ: %v and %v", narrow.total, wide.total)
+	}
+	r := 0
+	for index, weight := range(narrow.weights) {
+		for i := 0; i < int(weight); i++ {
+			if found := narrow.find(r); found != index || wide.find(r) != index {
+				t.Fatalf("%v belongs to index %v, not %v (or %v)", r, index, found, wide.find(r))
+			}
+			r++
+		}
+	}
+}
+
+// Measures placing the walls of a large maze, but not finding its entrance
+// and exit, which takes longer still.
+func BenchmarkPlaceWalls(b *testing.B) {
+	for _, p := range([]mazeParameters{
+		{width: 401, height: 201, minWallLength: 3, maxWallLength: 1 << 30},
+		{width: 401, height: 201, minWallLength: 3, maxWallLength: 5},
+	}) {
+		b.Run(fmt.Sprintf("max=%v", p.maxWallLength), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m := NewMaze(p.width, p.height)
+				m.minWallLength, m.maxWallLength = p.minWallLength, p.maxWallLength
+				m.SetSeed(fmt.Sprint(i))
+				for g := m.Generator(); g.Step() && g.numberOfUnoccupiedUnits > 0; {
+				}
+			}
+		})
+	}
+}
+
+// Packed storage should be invisible: the same seed draws the same maze, and
+// runes beyond the usual few still read back correctly.
+func TestPackedStorage(t *testing.T) {
+	for _, thicknessValues := range([][]int{{1}, {3}, {5, 3, 1}}) {
+		p := mazeParameters{
+			width: 61,
+			height: 31,
+			thicknessValues: thicknessValues,
+			minWallLength: 3,
+			maxWallLength: 1 << 30,
+			seed: "packed",
+		}
+		expected := generate(t, p)
+
+		m := NewPackedMaze(p.width, p.height)
+		m.SetSeed(p.seed)
+		m.GenerateNested(p.thicknessValues)
+		for y := 0; y < m.height; y++ {
+			for x := 0; x < m.width; x++ {
+				if m.Get(x, y) != expected.Get(x, y) {
+					t.Fatalf("%v: the packed maze differs at (%v, %v)", p, x, y)
+				}
+			}
+		}
+		if !slices.Equal(m.entrances, expected.entrances) || !slices.Equal(m.exits, expected.exits) {
+			t.Fatalf("%v: the packed maze has a different entrance or exit", p)
+		}
+		if bits := m.packed.bits; (len(thicknessValues) == 1 && thicknessValues[0] == 1 && bits != 2) || bits > 4 {
+			t.Errorf("%v: the packed maze uses %v bits per cell", p, bits)
+		}
+
+		m.SetPacked(false)
+		if !slices.Equal(m.cells, expected.cells) {
+			t.Errorf("%v: unpacking the maze changed it", p)
+		}
+	}
+
+	m := NewPackedMaze(40, 10)
+	for i := 0; i < 300; i++ {
+		m.Set(i % 40, i / 40, rune('A' + i % 200))
+	}
+	for i := 0; i < 300; i++ {
+		if c := m.Get(i % 40, i / 40); c != rune('A' + i % 200) {
+			t.Fatalf("cell %v reads back as %q after widening to %v bits", i, c, m.packed.bits)
+		}
+	}
+
+	// More runes than 8 and then 16 bits can tell apart.
+	for _, count := range([]int{300, 70000}) {
+		m := NewPackedMaze(300, 300)
+		for i := 0; i < m.cellCount(); i++ {
+			m.setCell(i, rune(0x4E00 + i % count))
+		}
+		for i := 0; i < m.cellCount(); i++ {
+			if c := m.cell(i); c != rune(0x4E00 + i % count) {
+				t.Fatalf("%v runes: cell %v reads back as %q after widening to %v bits", count, i, c, m.packed.bits)
+			}
+		}
+		copied := NewMazeOverExisting(m)
+		copied.SetPacked(false)
+		for i := 0; i < m.cellCount(); i++ {
+			if copied.cells[i] != m.cell(i) {
+				t.Fatalf("%v runes: cell %v changed when the maze was unpacked", count, i)
+			}
+		}
+	}
+}
+
+// A grid of chunks should stitch together into a single perfect maze, and
+// the streamed text should match the stitched maze.  Each chunk should come
+// out the same on its own as it does in the grid.
+func TestChunkGrid(t *testing.T) {
+	for _, thickness := range([]int{1, 2, 3}) {
+		template := NewMaze(25, 15)
+		template.thickness = thickness
+		template.SetSeed("chunks")
+		grid, err := NewChunkGrid(template, 3, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stitched, err := grid.Stitch(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stitched.Validate(true); err != nil {
+			t.Errorf("thickness %v: the stitched maze is invalid:\n%v", thickness, err)
+		}
+
+		var expected, streamed bytes.Buffer
+		stitched.WriteText(&expected)
+		if err := grid.WriteText(context.Background(), &streamed); err != nil {
+			t.Fatal(err)
+		}
+		if expected.String() != streamed.String() {
+			t.Errorf("thickness %v: the streamed maze differs from the stitched one", thickness)
+		}
+
+		width, height := grid.ChunkDimensions()
+		strideX, strideY := grid.stride()
+		chunk, err := grid.Chunk(context.Background(), 1, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for y := 1; y < height - 1; y++ {
+			for x := 1; x < width - 1; x++ {
+				if chunk.Get(x, y) != stitched.Get(strideX + x, 2 * strideY + y) {
+					t.Fatalf("thickness %v: chunk (1, 2) differs from the stitched maze at (%v, %v)", thickness, x, y)
+				}
+			}
+		}
+	}
+}
+
+// A batch should come out the same no matter how many workers generate it,
+// and each maze should match the one generated on its own from its seed.
+// The other formats should each be written to a file of their own kind.
+func TestRunBatch(t *testing.T) {
+	template := NewMaze(31, 15)
+	options := BatchOptions{
+		Count: 6,
+		SeedPrefix: "batch",
+		Format: "text",
+		ThicknessValues: []int{3, 1},
+	}
+	manifests := []string{}
+	for _, jobs := range([]int{1, 4}) {
+		options.Directory = t.TempDir()
+		options.Jobs = jobs
+		entries, err := RunBatch(context.Background(), template, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var manifest bytes.Buffer
+		WriteManifestJSON(&manifest, entries)
+		manifests = append(manifests, manifest.String())
+
+		for _, e := range(entries) {
+			if e.Error != "" || e.File == "" {
+				t.Fatalf("maze %v: %v", e.Index, e.Error)
+			}
+			data, err := os.ReadFile(filepath.Join(options.Directory, e.File))
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := NewMaze(31, 15)
+			m.SetSeed(e.Seed)
+			m.GenerateNested(options.ThicknessValues)
+			var expected bytes.Buffer
+			m.WriteText(&expected)
+			if string(data) != expected.String() {
+				t.Errorf("maze %v doesn't match seed %q", e.Index, e.Seed)
+			}
+		}
+	}
+	if manifests[0] != manifests[1] {
+		t.Errorf("the manifest depends on the number of workers")
+	}
+
+	// Every format goes to a file with its own extension.
+	options.Count, options.Jobs, options.Scale = 1, 1, 4
+	for format, prefix := range(map[string]string{
+		"json": "{",
+		"svg": "<svg",
+		"png": "\x89PNG",
+		"dxf": "  0\nSECTION",
+	}) {
+		options.Directory, options.Format = t.TempDir(), format
+		options.Pitch, options.WallThickness = 300, 18
+		entries, err := RunBatch(context.Background(), template, options)
+		if err != nil || entries[0].Error != "" {
+			t.Fatalf("%v: %v %v", format, err, entries[0].Error)
+		}
+		data, err := os.ReadFile(filepath.Join(options.Directory, "maze-0." + format))
+		if err != nil || !strings.HasPrefix(string(data), prefix) {
+			t.Errorf("%v: %q... (%v)", format, data[:min(len(data), 16)], err)
+		}
+		if format == "json" {
+			var document MazeDocument
+			if err := json.Unmarshal(data, &document); err != nil {
+				t.Errorf("json: %v", err)
+			}
+		}
+	}
+}
+
+// Every subcommand's arguments should fit together: argparse panics when two
+// of them share a name, before any of them are even parsed.
+func TestSubcommandArguments(t *testing.T) {
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = devNull
+	defer func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}()
+
+	for name, run := range(map[string]func(args []string){
+		"validate": validateMain,
+		"play": playMain,
+		"chunks": chunksMain,
+		"batch": batchMain,
+		"serve": serveMain,
+		"dungeon": dungeonMain,
+	}) {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("maze %v: %v", name, r)
+				}
+			}()
+			// An unknown argument makes each of them print its
+			// usage and return.
+			run([]string{name, "--no-such-argument"})
+		}()
+	}
+}
+
+// Every built-in theme should draw a maze that reads back as the same maze,
+// and a theme whose floor can't be told apart from the walls should be
+// rejected.
+func TestThemes(t *testing.T) {
+	for _, name := range(themeNames(&Config{})) {
+		m := NewMaze(41, 21)
+		if err := m.SetTheme(themes[name]); err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		m.SetSeed("themes")
+		m.GenerateNested([]int{3})
+		if err := m.Validate(false); err != nil {
+			t.Errorf("%v: the maze is invalid:\n%v", name, err)
+		}
+	}
+
+	ascii := themes["ascii"]
+	for _, theme := range([]Theme{
+		{Floor: " ", Fill: " ", Intersection: "+", Horizontal: "-", Vertical: "|"},
+		{Floor: "-", Fill: ".", Intersection: "+", Horizontal: "-", Vertical: "|"},
+		{Floor: "", Fill: ".", Intersection: "+", Horizontal: "-", Vertical: "|"},
+		{Floor: "ab", Fill: ".", Intersection: "+", Horizontal: "-", Vertical: "|"},
+		{Floor: " ", Fill: ".", Intersection: "+", Horizontal: "-", Vertical: "|", BoxDrawing: "wavy"},
+	}) {
+		m := NewMaze(5, 5)
+		if err := m.SetTheme(theme); err == nil {
+			t.Errorf("accepted the theme %+v", theme)
+		}
+		if m.floor != []rune(ascii.Floor)[0] || m.fill != []rune(ascii.Fill)[0] {
+			t.Errorf("the rejected theme %+v changed the maze", theme)
+		}
+	}
+}
+
+// Each glyph should be recognized as one glyph (or not) and measured
+// correctly, whether it's ASCII, wide, or several code points long.
+func TestGlyphWidths(t *testing.T) {
+	for _, test := range([]struct{glyph string; single bool; width int}{
+		{"a", true, 1},
+		{"#", true, 1},
+		{"\u2500", true, 1},                     // A box-drawing line
+		{"\uFF71", true, 1},                     // Halfwidth katakana
+		{"e\u0301", true, 1},                    // A combining accent
+		{"\u2764", true, 1},                     // A heart, drawn as text
+		{"\u2764\uFE0F", true, 2},               // The same heart as an emoji
+		{"\u4E2D", true, 2},                     // CJK
+		{"\uAC00", true, 2},                     // Hangul
+		{"\U0001F9F1", true, 2},                 // An emoji
+		{"\U0001F44D\U0001F3FD", true, 2},       // An emoji with a skin tone
+		{"\U0001F469\u200D\U0001F680", true, 2}, // Zero-width-joined emoji
+		{"\U0001F1EF\U0001F1F5", true, 2},       // A flag
+		{"\u0301", false, 0},                    // A combining accent on its own
+		{"\u200B", false, 0},                    // A zero-width space
+		{"", false, 0},
+		{"ab", false, 1},
+		{"\t", false, -1},                       // A control character
+	}) {
+		if single := isSingleGlyph(test.glyph); single != test.single {
+			t.Errorf("isSingleGlyph(%q) = %v", test.glyph, single)
+		}
+		if width := glyphWidth(test.glyph); width != test.width {
+			t.Errorf("glyphWidth(%q) = %v, not %v", test.glyph, width, test.width)
+		}
+	}
+
+	for _, theme := range([]Theme{
+		{Floor: "\u4E2D", Fill: "#", Intersection: "+", Horizontal: "-", Vertical: "|"},
+		{Floor: " ", Fill: ".", Intersection: "\U0001F9F1", Horizontal: "=", Vertical: "\U0001F469\u200D\U0001F680"},
+		{Floor: " ", Fill: ".", Intersection: "+", Horizontal: "-", Vertical: "|"},
+	}) {
+		m := NewMaze(21, 11)
+		if err := m.SetTheme(theme); err != nil {
+			t.Fatal(err)
+		}
+		m.SetSeed("wide")
+		m.GenerateNested([]int{3, 1})
+
+		// Narrow glyphs are padded: the floor and fill with themselves,
+		// walls that carry on to the right with a horizontal wall, and
+		// everything else with a space.
+		wide := m.hasWideGlyphs()
+		carriesHorizontal := func(x, y int) bool {
+			c := m.Get(x, y)
+			return m.valid(x, y) && (c == m.horizontal || c == m.intersection)
+		}
+		cells := m.renderedCells()
+		for y := 0; y < m.height; y++ {
+			for x := 0; x < m.width; x++ {
+				c := m.Get(x, y)
+				expected := m.glyph(c)
+				switch {
+				case !wide || glyphWidth(expected) == 2:
+					break
+				case c == m.floor || c == m.fill:
+					expected += expected
+				case carriesHorizontal(x, y) && carriesHorizontal(x + 1, y):
+					expected += m.glyph(m.horizontal)
+				default:
+					expected += " "
+				}
+				if cells[m.offset(x, y)] != expected {
+					t.Fatalf("%v: (%v, %v) is rendered as %q, not %q", theme, x, y, cells[m.offset(x, y)], expected)
+				}
+			}
+		}
+
+		// The padding should come back out when the maze is loaded.
+		var text bytes.Buffer
+		m.WriteText(&text)
+		n := NewMaze(1, 1)
+		if err := n.SetTheme(theme); err != nil {
+			t.Fatal(err)
+		}
+		if err := n.Load(&text); err != nil {
+			t.Fatal(err)
+		}
+		if n.width != m.width || n.height != m.height {
+			t.Fatalf("%v: loaded a %vx%v maze from a %vx%v one", theme, n.width, n.height, m.width, m.height)
+		}
+		for y := 0; y < m.height; y++ {
+			for x := 0; x < m.width; x++ {
+				if n.Get(x, y) != m.Get(x, y) {
+					t.Fatalf("%v: (%v, %v) changed from %q to %q when the maze was loaded", theme, x, y, m.glyph(m.Get(x, y)), n.glyph(n.Get(x, y)))
+				}
+			}
+		}
+	}
+}
+
+// The subset of TOML that configuration files use.
+func TestParseTOML(t *testing.T) {
+	document, err := parseTOML(`
+# A comment.
+[defaults]
+width = 61            # Another comment.
+thickness = [3, 1]
+seed = "a \"quoted\" seed"
+ratio = 0.5
+packed = true
+"quoted-key" = 'literal \n'
+
+[themes.mine]
+fill = "█"
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := json.Marshal(document)
+	expected := `{"defaults":{"packed":true,"quoted-key":"literal \\n","ratio":0.5,"seed":"a \"quoted\" seed","thickness":[3,1],"width":61},"themes":{"mine":{"fill":"█"}}}`
+	if string(data) != expected {
+		t.Errorf("parsed %s; expected %s", data, expected)
+	}
+
+	for _, text := range([]string{
+		"[defaults",
+		"[defaults] x",
+		"width",
+		"width = ",
+		"width = 1\nwidth = 2",
+		"width = 1 2",
+		"seed = \"unterminated",
+		"thickness = [1, 2",
+		"a b = 1",
+		"width = 1\n[width]",
+	}) {
+		if _, err := parseTOML(text); err == nil {
+			t.Errorf("parsed %q", text)
+		}
+	}
+}
+
+// Configuration files may be JSON or TOML, and their defaults and themes
+// should be checked as they are read.
+func TestLoadConfig(t *testing.T) {
+	directory := t.TempDir()
+	write := func(name, text string) string {
+		path := filepath.Join(directory, name)
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	for _, path := range([]string{
+		write("config.json", `{"defaults": {"width": 61, "thickness": [3, 1], "theme": "mine"}, "themes": {"mine": {"fill": "#"}}}`),
+		write("config.toml", "[defaults]\nwidth = 61\nthickness = \"3,1\"\ntheme = \"mine\"\n[themes.mine]\nfill = \"#\"\n"),
+	}) {
+		for _, args := range([][]string{{"maze", "--config", path}, {"maze", "--config=" + path}}) {
+			config, err := LoadConfig(args)
+			if err != nil {
+				t.Fatalf("%v: %v", path, err)
+			}
+			if config.intDefault("width", 0) != 61 || config.stringDefault("thickness", "") != "3,1" || config.stringDefault("height", "none") != "none" {
+				t.Errorf("%v: the defaults are %v", path, config.Defaults)
+			}
+			theme, err := findTheme(config, "mine")
+			if err != nil || theme.Fill != "#" || theme.Floor != themes["ascii"].Floor {
+				t.Errorf("%v: the theme is %+v (%v)", path, theme, err)
+			}
+		}
+	}
+
+	for _, text := range([]string{
+		`{"settings": {}}`,
+		`{"defaults": []}`,
+		`{"defaults": {"colour": "never"}}`,
+		`{"defaults": {"width": "wide"}}`,
+		`{"defaults": {"width": 1.5}}`,
+		`{"defaults": {"thickness": ["a"]}}`,
+		`{"themes": {"bad": {"fill": " "}}}`,
+		`{"themes": {"bad": {"glow": "*"}}}`,
+		`{"themes": {"bad": {"fill": 1}}}`,
+		`not json`,
+	}) {
+		path := write("bad.json", text)
+		if _, err := LoadConfig([]string{"maze", "--config", path}); err == nil {
+			t.Errorf("loaded %v", text)
+		}
+	}
+	if _, err := LoadConfig([]string{"maze", "--config", filepath.Join(directory, "missing.json")}); err == nil {
+		t.Errorf("loaded a missing file")
+	}
+}
+
+// Color output should color a visible floor but not a blank one, and
+// without color it should be the same as the plain text.
+func TestWriteColor(t *testing.T) {
+	m := NewMaze(21, 11)
+	m.SetSeed("color")
+	m.Generate()
+	floorStyle := "\x1b[" + defaultPalette.floor.sgr(TrueColor, false) + "m"
+
+	var plain, uncolored, blank bytes.Buffer
+	m.WriteText(&plain)
+	m.WriteColor(&uncolored, ColorOptions{Mode: NoColor})
+	if uncolored.String() != plain.String() {
+		t.Errorf("without color, the output differs from the text")
+	}
+	m.WriteColor(&blank, ColorOptions{Mode: TrueColor})
+	if strings.Contains(blank.String(), floorStyle) {
+		t.Errorf("a blank floor was colored")
+	}
+
+	m = NewMaze(21, 11)
+	if err := m.SetTheme(Theme{Floor: ".", Fill: " ", Intersection: "+", Horizontal: "-", Vertical: "|"}); err != nil {
+		t.Fatal(err)
+	}
+	m.SetSeed("color")
+	m.Generate()
+	var dotted bytes.Buffer
+	m.WriteColor(&dotted, ColorOptions{Mode: TrueColor})
+	if !strings.Contains(dotted.String(), floorStyle + ".") {
+		t.Errorf("a visible floor wasn't colored:\n%v", dotted.String())
+	}
+}
+
+// Each wall should be drawn with the box-drawing glyph that joins it to
+// exactly the walls around it.
+func TestBoxDrawing(t *testing.T) {
+	for _, test := range([]struct{name, style string; walls, expected []string}{
+		{"corners, tees and a cross", "light", []string{
+			"+-+-+",
+			"| | |",
+			"+-+-+",
+			"| | |",
+			"+-+-+",
+		}, []string{
+			"┌─┬─┐",
+			"│ │ │",
+			"├─┼─┤",
+			"│ │ │",
+			"└─┴─┘",
+		}},
+		{"rounded corners", "rounded", []string{
+			"+-+",
+			"| |",
+			"+-+",
+		}, []string{
+			"╭─╮",
+			"│ │",
+			"╰─╯",
+		}},
+		{"isolated pillars and end caps", "heavy", []string{
+			"+ --+",
+			"    |",
+			"- | +",
+		}, []string{
+			"• ╺━┓",
+			"    ┃",
+			"━ ┃ ╹",
+		}},
+		{"parallel walls", "light", []string{
+			"-----",
+			"-----",
+			"||  |",
+			"|| ||",
+		}, []string{
+			"╶───╴",
+			"╶───╴",
+			"╷╷  ╷",
+			"╵╵ │╵",
+		}},
+		{"thick walls", "double", []string{
+			"+-+-+",
+			"|.|.|",
+			"+-+-+",
+			"  |.|",
+			"  +-+",
+		}, []string{
+			"╔═╦═╗",
+			"║.║.║",
+			"╚═╬═╣",
+			"  ║.║",
+			"  ╚═╝",
+		}},
+	}) {
+		m := NewMaze(1, 1)
+		if err := m.Load(strings.NewReader(strings.Join(test.walls, "\n"))); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.SetBoxStyle(test.style); err != nil {
+			t.Fatal(err)
+		}
+		cells := m.joinedCells()
+		for y, expected := range(test.expected) {
+			if row := string(cells[y * m.width:(y + 1) * m.width]); row != expected {
+				t.Errorf("%v: row %v is %q, not %q", test.name, y, row, expected)
+			}
+		}
+	}
+
+	// A maze printed with box drawing (as by -b or a box theme) should
+	// load and validate.
+	for _, thickness := range([]int{1, 3}) {
+		for _, theme := range([]string{"ascii", "box-light", "box-heavy"}) {
+			m := NewMaze(41, 21)
+			m.SetTheme(themes[theme])
+			if theme == "ascii" {
+				m.SetBoxStyle("double")
+			}
+			m.SetSeed("box")
+			m.GenerateNested([]int{thickness})
+			var text bytes.Buffer
+			m.WriteText(&text)
+
+			n := NewMaze(1, 1)
+			n.SetTheme(themes[theme])
+			n.boxStyle = m.boxStyle
+			n.thickness = thickness
+			if err := n.Load(&text); err != nil {
+				t.Fatal(err)
+			}
+			if err := n.Validate(true); err != nil {
+				t.Errorf("%v, thickness %v: the loaded maze is invalid:\n%v", theme, thickness, err)
+			}
+			for offset := 0; offset < m.cellCount(); offset++ {
+				if (m.cell(offset) == m.floor) != (n.cell(offset) == n.floor) || (m.cell(offset) == m.fill) != (n.cell(offset) == n.fill) {
+					t.Fatalf("%v, thickness %v: cell %v changed from %q to %q", theme, thickness, offset, m.cell(offset), n.cell(offset))
+				}
+			}
+		}
+	}
+}
+
+// The events of a nested generation should come in order: each pass starts
+// with its border, places its walls one at a time and then cuts the
+// entrance and exit, and the repairs come last.  Listening shouldn't change
+// the maze.
+func TestEvents(t *testing.T) {
+	thicknessValues := []int{5, 4}
+	events := []Event{}
+	counts := map[EventKind]int{}
+	m := NewMaze(61, 31)
+	m.minWallLength, m.maxWallLength = 1, 7
+	m.SetSeed("events")
+	m.SetEventHandler(func(e Event) {
+		events = append(events, e)
+		counts[e.Kind]++
+	})
+	m.GenerateNested(thicknessValues)
+
+	pass, walls := -1, 0
+	for i, e := range(events) {
+		if e.X < 0 || e.Y < 0 || e.Width <= 0 || e.Height <= 0 || e.X + e.Width > m.width || e.Y + e.Height > m.height {
+			t.Errorf("event %v (%v) is outside the maze: %v,%v %vx%v", i, e.Kind, e.X, e.Y, e.Width, e.Height)
+		}
+		previous := BorderEvent
+		if i > 0 {
+			previous = events[i - 1].Kind
+		}
+		switch e.Kind {
+		case BorderEvent:
+			pass++
+			walls = 0
+			if i > 0 && previous != ExitEvent {
+				t.Fatalf("pass %v started after a %v", pass, previous)
+			}
+		case WallEvent:
+			walls++
+			if e.Walls != walls || (previous != BorderEvent && previous != WallEvent) {
+				t.Fatalf("wall %v of pass %v came as wall %v, after a %v", walls, pass, e.Walls, previous)
+			}
+		case EntranceEvent:
+			if e.Walls != walls || (previous != BorderEvent && previous != WallEvent) {
+				t.Fatalf("the entrance of pass %v came after %v walls and a %v, out of %v", pass, e.Walls, previous, walls)
+			}
+		case ExitEvent:
+			if previous != EntranceEvent {
+				t.Fatalf("the exit of pass %v came after a %v", pass, previous)
+			}
+		case PassageEvent:
+			if pass != len(thicknessValues) - 1 || (previous != ExitEvent && previous != PassageEvent) {
+				t.Fatalf("a passage was cut in pass %v, after a %v", pass, previous)
+			}
+		default:
+			t.Fatalf("unexpected %v event", e.Kind)
+		}
+		if pass < 0 || e.Thickness != thicknessValues[pass] {
+			t.Fatalf("a %v event in pass %v has a thickness of %v", e.Kind, pass, e.Thickness)
+		}
+	}
+	passes := len(thicknessValues)
+	if counts[BorderEvent] != passes || counts[EntranceEvent] != passes || counts[ExitEvent] != passes || counts[WallEvent] == 0 || counts[PassageEvent] == 0 {
+		t.Errorf("expected %v passes with walls, and some passages: %v", passes, counts)
+	}
+	if last := events[len(events) - 1].Kind; last != PassageEvent {
+		t.Errorf("the last event was a %v", last)
+	}
+
+	quiet := NewMaze(61, 31)
+	quiet.minWallLength, quiet.maxWallLength = 1, 7
+	quiet.SetSeed("events")
+	quiet.GenerateNested(thicknessValues)
+	var expected, actual bytes.Buffer
+	quiet.WriteText(&expected)
+	m.WriteText(&actual)
+	if actual.String() != expected.String() {
+		t.Errorf("listening for events changed the maze")
+	}
+}
+
+// A game should stop the player at walls, lead the player to the exit with
+// its hint, notice the win, and reveal the fog as the player goes.
+func TestGame(t *testing.T) {
+	m := NewMaze(21, 11)
+	m.SetSeed("play")
+	m.Generate()
+	g, err := newGame(&m, "@", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newGame(&m, "\U0001F600", 0); err == nil {
+		t.Errorf("accepted a wide player in a narrow maze")
+	}
+	if g.won() {
+		t.Fatalf("won at the entrance")
+	}
+
+	shortest := g.toExit[m.offset(g.player.x, g.player.y)]
+	for !g.won() {
+		// Every way but the floor is blocked.
+		start, moves := g.player, g.moves
+		for _, d := range(directions) {
+			x, y := start.x + d.x, start.y + d.y
+			if x >= 0 && y >= 0 && x < g.gridWidth && y < g.gridHeight && m.cell(m.offset(x, y)) == m.floor {
+				continue
+			}
+			if g.move(d.x, d.y); g.player != start || g.moves != moves {
+				t.Fatalf("moved from %v into the wall at (%v, %v)", start, x, y)
+			}
+		}
+
+		// The hint is a path of the right length, so taking its
+		// first step gets the player one step closer.
+		path := g.pathToExit()
+		length := 0
+		for offset, onPath := range(path) {
+			if onPath {
+				length++
+				if m.cell(offset) != m.floor {
+					t.Fatalf("the hint from %v goes through a wall", start)
+				}
+			}
+		}
+		distance := g.toExit[m.offset(start.x, start.y)]
+		if length != distance {
+			t.Fatalf("the hint from %v is %v cells long, not %v", start, length, distance)
+		}
+		for _, d := range(directions) {
+			x, y := start.x + d.x, start.y + d.y
+			if m.valid(x, y) && path[m.offset(x, y)] && g.toExit[m.offset(x, y)] == distance - 1 {
+				g.move(d.x, d.y)
+				break
+			}
+		}
+		if g.player == start || g.moves != moves + 1 {
+			t.Fatalf("couldn't follow the hint from %v", start)
+		}
+
+		// The fog lifts around the player and stays lifted.
+		for y := 0; y < m.height; y++ {
+			for x := 0; x < m.width; x++ {
+				if abs(x - g.player.x) <= g.fog && abs(y - g.player.y) <= g.fog && !g.seen[m.offset(x, y)] {
+					t.Fatalf("(%v, %v) is hidden from the player at %v", x, y, g.player)
+				}
+				if abs(x - start.x) <= g.fog && abs(y - start.y) <= g.fog && !g.seen[m.offset(x, y)] {
+					t.Fatalf("(%v, %v) was hidden again", x, y)
+				}
+			}
+		}
+	}
+	if !inAnyRect(g.player.x, g.player.y, m.exits) {
+		t.Errorf("won at %v, which isn't on the exit", g.player)
+	}
+	if g.moves != shortest {
+		t.Errorf("following the hint took %v moves, not %v", g.moves, shortest)
+	}
+	seen := 0
+	for _, s := range(g.seen) {
+		if s {
+			seen++
+		}
+	}
+	if seen == len(g.seen) {
+		t.Errorf("the fog lifted everywhere")
+	}
+}
+
+// The server should serve each seed's maze with caching headers that also
+// depend on its theme, and turn away requests that are too large or in a format it can't write.
+func TestServe(t *testing.T) {
+	template := NewMaze(21, 11)
+	s := &mazeServer{
+		template: template,
+		defaults: mazeRequest{
+			width: 21,
+			height: 11,
+			thicknessValues: []int{1},
+			minWallLength: template.minWallLength,
+			maxWallLength: template.maxWallLength,
+			format: "txt",
+			scale: 8,
+		},
+		timeout: 10 * time.Second,
+		maxCells: 20000,
+		cache: newMazeCache(4),
+		now: time.Now,
+	}
+	server := httptest.NewServer(s.routes())
+	defer server.Close()
+	get := func(path string, header http.Header) (*http.Response, string) {
+		request, _ := http.NewRequest("GET", server.URL + path, nil)
+		for k, v := range(header) {
+			request.Header[k] = v
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		body, _ := io.ReadAll(response.Body)
+		return response, string(body)
+	}
+
+	first, body := get("/maze?seed=serve&w=31", nil)
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("status %v: %v", first.StatusCode, body)
+	}
+	m := NewMaze(31, 11)
+	m.SetSeed("serve")
+	m.Generate()
+	var expected bytes.Buffer
+	m.WriteText(&expected)
+	if body != expected.String() {
+		t.Errorf("the served maze doesn't match its seed")
+	}
+	etag := first.Header.Get("ETag")
+	if etag == "" || first.Header.Get("X-Maze-Seed") != "serve" {
+		t.Errorf("missing headers: %v", first.Header)
+	}
+	if again, _ := get("/maze?seed=serve&w=31", http.Header{"If-None-Match": {etag}}); again.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match: got status %v", again.StatusCode)
+	}
+	if other, _ := get("/solve?seed=serve&w=31", http.Header{"If-None-Match": {etag}}); other.StatusCode != http.StatusOK {
+		t.Errorf("/solve shares the ETag of /maze")
+	}
+	if unseeded, _ := get("/maze", nil); unseeded.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("an unseeded maze may be cached")
+	}
+	if big, _ := get("/maze?w=1001&h=1001", nil); big.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("too large: got status %v", big.StatusCode)
+	}
+	if bad, _ := get("/maze?format=gif", nil); bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown format: got status %v", bad.StatusCode)
+	}
+	for _, format := range([]string{"png", "svg"}) {
+		if bad, _ := get("/stats?format=" + format, nil); bad.StatusCode != http.StatusBadRequest {
+			t.Errorf("/stats?format=%v: got status %v", format, bad.StatusCode)
+		}
+	}
+	if stats, body := get("/stats?seed=serve&format=json", nil); stats.StatusCode != http.StatusOK || !json.Valid([]byte(body)) {
+		t.Errorf("/stats?format=json: got status %v: %v", stats.StatusCode, body)
+	}
+	if png, _ := get("/maze?seed=serve&format=png&scale=64", nil); png.StatusCode != http.StatusOK || png.Header.Get("Content-Type") != "image/png" {
+		t.Errorf("png: got status %v", png.StatusCode)
+	}
+	if big, _ := get("/maze?w=129&h=129&format=png&scale=64", nil); big.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("too many pixels: got status %v", big.StatusCode)
+	}
+	if svg, _ := get("/maze?w=129&h=129&format=svg&scale=64", nil); svg.StatusCode != http.StatusOK {
+		t.Errorf("svg: got status %v", svg.StatusCode)
+	}
+
+	// The same parameters drawn with another theme are another maze.
+	other := *s
+	if err := other.template.SetTheme(themes["blocks"]); err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := s.parseRequest(httptest.NewRequest("GET", "/maze?seed=serve", nil), serveFormatNames())
+	blocks, _ := other.parseRequest(httptest.NewRequest("GET", "/maze?seed=serve", nil), serveFormatNames())
+	if plain.key() == blocks.key() || plain.etag("/maze") == blocks.etag("/maze") {
+		t.Errorf("the theme doesn't change the key %v", plain.key())
+	}
+
+	m = NewMaze(129, 129)
+	if err := m.WritePNG(io.Discard, ImageOptions{Scale: 64}); err == nil {
+		t.Errorf("WritePNG() drew %v pixels", 129 * 129 * 64 * 64)
+	}
+}
+
+// A maze should come through JSON unchanged, packed or not, and SetDocument()
+// should reject a document that's damaged or too new.
+func TestMazeJSON(t *testing.T) {
+	m := NewMaze(41, 21)
+	if err := m.SetTheme(Theme{Floor: " ", Fill: ".", Intersection: "🧱", Horizontal: "🧱", Vertical: "👩‍🚀"}); err != nil {
+		t.Fatal(err)
+	}
+	m.SetSeed("json")
+	m.GenerateNested([]int{3, 1})
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, packed := range([]bool{false, true}) {
+		n := NewMaze(1, 1)
+		n.SetPacked(packed)
+		if err := json.Unmarshal(data, &n); err != nil {
+			t.Fatal(err)
+		}
+		if n.Packed() != packed {
+			t.Errorf("unmarshaling changed the storage")
+		}
+		again, _ := json.Marshal(n)
+		if string(again) != string(data) {
+			t.Errorf("packed=%v: the maze changed in a round trip", packed)
+		}
+		var expected, actual bytes.Buffer
+		m.WriteText(&expected)
+		n.WriteText(&actual)
+		if actual.String() != expected.String() {
+			t.Errorf("packed=%v: the maze prints differently after a round trip", packed)
+		}
+	}
+
+	var d MazeDocument
+	json.Unmarshal(data, &d)
+	if !slices.Equal(d.ThicknessValues, []int{3, 1}) || len(d.Solution) == 0 || d.Stats == nil || len(d.Placeholders) != 1 {
+		t.Errorf("incomplete document: %+v", d)
+	}
+	e := d.Entrance
+	if first := d.Solution[0]; first[0] < e.X || first[0] >= e.X + e.Width || first[1] < e.Y || first[1] >= e.Y + e.Height {
+		t.Errorf("the solution starts at %v, outside of the entrance %+v", first, e)
+	}
+
+	broken := d
+	broken.Rows = append([]string{}, d.Rows...)
+	broken.Rows[3] = broken.Rows[3][:10]
+	n := NewMaze(1, 1)
+	if err := n.SetDocument(broken); err == nil {
+		t.Errorf("accepted a short row")
+	}
+	broken = d
+	broken.Version = mazeDocumentVersion + 1
+	if err := n.SetDocument(broken); err == nil {
+		t.Errorf("accepted a later version")
+	}
+}
+
+// Contracting the passage graph should fold each corridor into a single edge
+// whose length counts the doorways along it.
+func TestPassageGraph(t *testing.T) {
+	for _, thicknessValues := range([][]int{{1}, {3, 1}}) {
+		m := NewMaze(61, 31)
+		m.SetSeed("graph")
+		m.GenerateNested(thicknessValues)
+		full, contracted := m.Graph(false), m.Graph(true)
+		if len(full.Nodes) != len(m.rooms()) {
+			t.Errorf("%v: %v nodes for %v rooms", thicknessValues, len(full.Nodes), len(m.rooms()))
+		}
+
+		// Every doorway between two rooms belongs to exactly one corridor.
+		total := 0
+		for _, e := range(contracted.Edges) {
+			total += e.Length
+			if len(e.rooms) != e.Length + 1 {
+				t.Errorf("%v: an edge of length %v has %v rooms", thicknessValues, e.Length, len(e.rooms))
+			}
+			for _, n := range([]GraphNode{contracted.Nodes[e.From], contracted.Nodes[e.To]}) {
+				if n.Kind == "corridor" {
+					t.Errorf("%v: the contracted graph kept the pass-through %v", thicknessValues, n.id())
+				}
+			}
+		}
+		if total != len(full.Edges) {
+			t.Errorf("%v: the corridors have a total length of %v, but there are %v doorways", thicknessValues, total, len(full.Edges))
+		}
+
+		var edges bytes.Buffer
+		contracted.WriteEdgeList(&edges)
+		if lines := bytes.Count(edges.Bytes(), []byte("\n")); lines != len(contracted.Edges) {
+			t.Errorf("%v: %v lines for %v edges", thicknessValues, lines, len(contracted.Edges))
+		}
+	}
+}
+
+// A* should find the solution, and A* and Dijkstra should agree with each
+// other and with the uncontracted graph.
+func TestRoutes(t *testing.T) {
+	for _, thicknessValues := range([][]int{{1}, {2}, {3, 1}, {4}}) {
+		m := NewMaze(61, 31)
+		m.SetSeed("routes")
+		m.GenerateNested(thicknessValues)
+		g, full := m.Graph(true), m.Graph(false)
+		if g.Entrance < 0 || g.Exit < 0 {
+			t.Fatalf("%v: no entrance or exit in the graph", thicknessValues)
+		}
+
+		route, ok := g.AStar(g.Entrance, g.Exit)
+		if !ok || route.Length != len(m.solutionRooms()) - 1 {
+			t.Errorf("%v: A* found a route of length %v; the solution has %v rooms", thicknessValues, route.Length, len(m.solutionRooms()))
+		}
+		for i, c := range(route.Cells) {
+			if m.Get(c.x, c.y) != m.floor {
+				t.Fatalf("%v: the route passes through %q at %v", thicknessValues, m.Get(c.x, c.y), c)
+			}
+			if i > 0 && max(c.x - route.Cells[i - 1].x, route.Cells[i - 1].x - c.x) + max(c.y - route.Cells[i - 1].y, route.Cells[i - 1].y - c.y) != 1 {
+				t.Fatalf("%v: the route jumps from %v to %v", thicknessValues, route.Cells[i - 1], c)
+			}
+		}
+		if last := route.Cells[len(route.Cells) - 1]; last != g.Nodes[g.Exit].cell {
+			t.Errorf("%v: the route ends at %v, not at the exit", thicknessValues, last)
+		}
+
+		// A* and Dijkstra agree with each other, and with the full graph.
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 20; i++ {
+			from, to := r.Intn(len(g.Nodes)), r.Intn(len(g.Nodes))
+			a, _ := g.AStar(from, to)
+			d, _ := g.Dijkstra(from, to)
+			fullFrom, _ := full.NodeAt(g.Nodes[from].X, g.Nodes[from].Y)
+			fullTo, _ := full.NodeAt(g.Nodes[to].X, g.Nodes[to].Y)
+			f, _ := full.Dijkstra(fullFrom, fullTo)
+			if a.Length != d.Length || a.Length != f.Length || len(a.Cells) != len(f.Cells) {
+				t.Errorf("%v: from %v to %v, A* found %v, Dijkstra %v and the full graph %v", thicknessValues, from, to, a.Length, d.Length, f.Length)
+			}
+		}
+	}
+}
+
+// Explicit entrances and exits should end up where they were asked for, lead
+// to the nearer exit, and survive resuming the generator.
+func TestOpenings(t *testing.T) {
+	for _, thicknessValues := range([][]int{{1}, {3}, {5, 1}}) {
+		m := NewMaze(41, 21)
+		m.SetSeed("openings")
+		entrances, err := ParseOpenings([]string{"left:7"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		exits, err := ParseOpenings([]string{"right:*", "center"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.SetOpenings(entrances, exits)
+		if err := m.GenerateNestedContext(context.Background(), thicknessValues); err != nil {
+			t.Fatalf("%v: %v", thicknessValues, err)
+		}
+		if err := m.Validate(len(thicknessValues) == 1); err != nil {
+			t.Errorf("%v: the maze is invalid:\n%v", thicknessValues, err)
+		}
+		if len(m.entrances) != 1 || len(m.exits) != 2 {
+			t.Fatalf("%v: %v entrances and %v exits", thicknessValues, len(m.entrances), len(m.exits))
+		}
+		if e := m.entrances[0]; e.x != 0 || !inRect(0, 7, e) {
+			t.Errorf("%v: the entrance %+v is not at left:7", thicknessValues, e)
+		}
+		unitWidth, unitHeight := m.unitDimensions()
+		if center := m.openingUnit(m.exits[1]); center != (point{unitWidth / 2 | 1, unitHeight / 2 | 1}) {
+			t.Errorf("%v: the center exit is at unit %v", thicknessValues, center)
+		}
+
+		// The solution leads to whichever exit is nearer.
+		g := m.Graph(true)
+		route, ok := g.Solve()
+		if !ok || len(g.Exits) != 2 || route.Length != len(m.solutionRooms()) - 1 {
+			t.Fatalf("%v: the route has length %v; the solution has %v rooms", thicknessValues, route.Length, len(m.solutionRooms()))
+		}
+		for _, exit := range(g.Exits) {
+			if r, _ := g.Dijkstra(g.Entrance, exit); r.Length < route.Length {
+				t.Errorf("%v: the exit %v is nearer than the solution's", thicknessValues, g.Nodes[exit].id())
+			}
+		}
+
+		// The openings survive saving and resuming the generator.
+		n := NewMaze(41, 21)
+		n.SetSeed("openings")
+		n.SetOpenings(entrances, exits)
+		gen := n.NestedGenerator(thicknessValues)
+		for i := 0; i < 50 && gen.Step(); i++ {
+		}
+		data, _ := json.Marshal(gen.State())
+		var state GeneratorState
+		json.Unmarshal(data, &state)
+		other := NewMaze(0, 0)
+		resumed, err := other.ResumeGenerator(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for resumed.Step() {
+		}
+		if !slices.Equal(m.entrances, other.entrances) || !slices.Equal(m.exits, other.exits) || !slices.Equal(m.cells, other.cells) {
+			t.Errorf("%v: resuming produced different openings", thicknessValues)
+		}
+	}
+
+	for _, s := range([]string{"left", "middle:3", "top:x", "3", "1,", "-1,2"}) {
+		if _, err := ParseOpening(s); err == nil {
+			t.Errorf("accepted the opening \"%v\"", s)
+		}
+	}
+	m := NewMaze(41, 21)
+	m.SetOpenings([]OpeningSpec{{Side: "left", Position: 100}}, nil)
+	if err := m.GenerateNestedContext(context.Background(), []int{1}); err == nil {
+		t.Errorf("placed an entrance beyond the bottom of the maze")
+	}
+}
+
+// Reserved rooms should be open with the doors asked for, solid blocks should
+// stay solid, and both should survive resuming the generator.
+func TestRegions(t *testing.T) {
+	vault := [][]bool{
+		{true, true, true, true, true, true, true, true},
+		{true, true, true, true, true, true, true, true},
+		{false, false, false, false, true, true, true, true},
+		{false, false, false, false, true, true, true, true},
+	}
+	for _, thickness := range([]int{1, 3}) {
+		m := NewMaze(61, 31)
+		m.SetSeed("regions")
+		for _, r := range([]Region{
+			{Kind: "room", Name: "boss", X: 20, Y: 8, Width: 14, Height: 9, Doors: 3},
+			{Kind: "solid", X: 2, Y: 20, Width: 9, Height: 6},
+			{Kind: "room", Name: "vault", X: 44, Y: 20, Width: 8, Height: 4, Mask: vault},
+		}) {
+			if err := m.Reserve(r); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := m.GenerateNestedContext(context.Background(), []int{thickness}); err != nil {
+			t.Fatalf("thickness %v: %v", thickness, err)
+		}
+		if err := m.Validate(false); err != nil {
+			t.Errorf("thickness %v: the maze is invalid:\n%v", thickness, err)
+		}
+
+		// The rooms are open inside, with the doors that were asked
+		// for, and the solid block is solid.
+		for i, r := range(m.Regions()) {
+			rooms := m.regionRooms(r)
+			units := regionUnits(rooms)
+			for unit := range(units) {
+				open := m.unitIsOpen(unit.x, unit.y)
+				if r.Kind == "solid" && open {
+					t.Errorf("thickness %v: the %v is open at unit %v", thickness, r, unit)
+				}
+				if r.Kind == "room" && surrounded(units, unit) && !open {
+					t.Errorf("thickness %v: the %v is walled in at unit %v", thickness, r, unit)
+				}
+			}
+			if doors := m.RegionDoors(i); len(doors) != r.Doors {
+				t.Errorf("thickness %v: the %v has %v doors", thickness, r, len(doors))
+			}
+		}
+		if rooms := m.regionRooms(m.regions[2]); thickness == 1 && (len(rooms) != 6 || slices.Contains(rooms, point{45, 23})) {
+			t.Errorf("the mask covers the rooms %v", rooms)
+		}
+
+		// The regions survive saving and resuming the generator.
+		n := NewMaze(61, 31)
+		n.SetSeed("regions")
+		for _, r := range(m.Regions()) {
+			n.Reserve(r)
+		}
+		g := n.NestedGenerator([]int{thickness})
+		for i := 0; i < 10 && g.Step(); i++ {
+		}
+		data, _ := json.Marshal(g.State())
+		var state GeneratorState
+		json.Unmarshal(data, &state)
+		other := NewMaze(0, 0)
+		resumed, err := other.ResumeGenerator(state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for resumed.Step() {
+		}
+		if !slices.Equal(m.cells, other.cells) || !slices.Equal(m.RegionDoors(0), other.RegionDoors(0)) {
+			t.Errorf("thickness %v: resuming produced a different maze", thickness)
+		}
+	}
+
+	for _, regions := range([][]Region{
+		{{Kind: "solid", X: 20, Y: 0, Width: 3, Height: 31}},
+		{{Kind: "room", X: 10, Y: 10, Width: 5, Height: 5}, {Kind: "solid", X: 12, Y: 12, Width: 5, Height: 5}},
+		{{Kind: "room", X: 100, Y: 100, Width: 5, Height: 5}},
+	}) {
+		m := NewMaze(61, 31)
+		for _, r := range(regions) {
+			m.Reserve(r)
+		}
+		if err := m.GenerateNestedContext(context.Background(), []int{1}); err == nil {
+			t.Errorf("generated a maze around %v", regions)
+		}
+	}
+	for _, s := range([]string{"1,2", "1,2,3", "1,2,3x", "1,2,0x4", "1,2,3x4:0"}) {
+		if _, err := ParseRegion("room", s); err == nil {
+			t.Errorf("accepted the region \"%v\"", s)
+		}
+	}
+}
+
+// A dungeon should have open rooms with doors, no dead ends outside them,
+// the same layout for the same seed, and reject bad options.
+func TestDungeon(t *testing.T) {
+	options := DungeonOptions{Rooms: 6, MinRoomSize: 2, MaxRoomSize: 3, ExtraDoorChance: 0.5, RemoveDeadEnds: true}
+	for _, thickness := range([]int{1, 3}) {
+		m := NewMaze(79, 31)
+		m.thickness = thickness
+		m.SetSeed("dungeon")
+		m.Reserve(Region{Kind: "room", Name: "boss", X: 2, Y: 2, Width: 9, Height: 7, Doors: 2})
+		if err := m.GenerateDungeon(context.Background(), options); err != nil {
+			t.Fatalf("thickness %v: %v", thickness, err)
+		}
+		if err := m.Validate(false); err != nil {
+			t.Errorf("thickness %v: the dungeon is invalid:\n%v", thickness, err)
+		}
+
+		// The fixed room comes first, and every room is open and has
+		// a door.
+		rooms := m.DungeonRooms()
+		if len(rooms) < 2 || len(rooms) > 7 || rooms[0].Name != "boss" {
+			t.Fatalf("thickness %v: the rooms are %v", thickness, rooms)
+		}
+		for _, room := range(rooms) {
+			for y := room.Y; y < room.Y + room.Height; y++ {
+				for x := room.X; x < room.X + room.Width; x++ {
+					if m.Get(x, y) != m.floor {
+						t.Errorf("thickness %v: the %v has a wall at (%v, %v)", thickness, room.Name, x, y)
+					}
+				}
+			}
+			if len(room.Doors) < 1 || len(room.Doors) > 4 {
+				t.Errorf("thickness %v: the %v has %v doors", thickness, room.Name, len(room.Doors))
+			}
+		}
+
+		// Outside of the rooms, only the rooms just inside their doors
+		// may be dead ends.
+		reserved := map[point]bool{}
+		for _, r := range(m.Regions()) {
+			for unit := range(regionUnits(m.regionRooms(r))) {
+				reserved[unit] = true
+			}
+		}
+		for _, room := range(m.rooms()) {
+			if reserved[room] || m.roomDegree(room) != 1 || m.goalKind(room) != "" {
+				continue
+			}
+			neighbors := m.roomNeighbors(room)
+			if len(neighbors) != 1 || !reserved[neighbors[0]] {
+				t.Errorf("thickness %v: (%v, %v) is a dead end", thickness, room.x, room.y)
+			}
+		}
+
+		// The same seed makes the same dungeon, and the JSON form has
+		// the rooms.
+		n := NewMaze(79, 31)
+		n.thickness = thickness
+		n.SetSeed("dungeon")
+		n.Reserve(Region{Kind: "room", Name: "boss", X: 2, Y: 2, Width: 9, Height: 7, Doors: 2})
+		n.GenerateDungeon(context.Background(), options)
+		if !slices.Equal(m.cells, n.cells) {
+			t.Errorf("thickness %v: the same seed made a different dungeon", thickness)
+		}
+		var buffer bytes.Buffer
+		if err := m.WriteDungeonJSON(&buffer); err != nil {
+			t.Fatal(err)
+		}
+		var d DungeonDocument
+		if err := json.Unmarshal(buffer.Bytes(), &d); err != nil {
+			t.Fatal(err)
+		}
+		if len(d.Rooms) != len(rooms) || d.Rooms[1].Name != "room1" || len(d.Rows) != 31 {
+			t.Errorf("thickness %v: the document has %v rooms and %v rows", thickness, len(d.Rooms), len(d.Rows))
+		}
+		other := NewMaze(0, 0)
+		if err := other.SetDocument(d.MazeDocument); err != nil || !slices.Equal(m.cells, other.cells) {
+			t.Errorf("thickness %v: could not read the dungeon back: %v", thickness, err)
+		}
+	}
+
+	for _, o := range([]DungeonOptions{
+		{Rooms: -1, MinRoomSize: 1, MaxRoomSize: 1},
+		{Rooms: 3, MinRoomSize: 0, MaxRoomSize: 2},
+		{Rooms: 3, MinRoomSize: 3, MaxRoomSize: 2},
+		{Rooms: 3, MinRoomSize: 1, MaxRoomSize: 2, ExtraDoorChance: 1},
+	}) {
+		m := NewMaze(79, 31)
+		if err := m.GenerateDungeon(context.Background(), o); err == nil {
+			t.Errorf("generated a dungeon with %+v", o)
+		}
+	}
+}
+
+// A small maze should be cut into the panels worked out by hand, and the DXF
+// drawing and bill of materials should agree with them.
+func TestBuildPlans(t *testing.T) {
+	m := NewMaze(1, 1)
+	if err := m.Load(strings.NewReader(strings.Join([]string{
+		"+---+",
+		"    |",
+		"+-+ +",
+		"|   |",
+		"+ +-+",
+	}, "\n"))); err != nil {
+		t.Fatal(err)
+	}
+
+	// With a pitch of 100 the units are 50 apart, and the walls stick
+	// out 5 past the centers of the units at either end.
+	expected := []struct{
+		segment WallSegment
+		length float64
+	}{
+		{WallSegment{Kind: HorizontalWall, X1: 0, Y1: 0, X2: 4, Y2: 0}, 210},
+		{WallSegment{Kind: HorizontalWall, X1: 0, Y1: 2, X2: 2, Y2: 2}, 110},
+		{WallSegment{Kind: HorizontalWall, X1: 2, Y1: 4, X2: 4, Y2: 4}, 110},
+		{WallSegment{Kind: VerticalWall, X1: 0, Y1: 3, X2: 0, Y2: 4, TrimStart: true}, 100},
+		{WallSegment{Kind: VerticalWall, X1: 4, Y1: 1, X2: 4, Y2: 3, TrimStart: true, TrimEnd: true}, 190},
+	}
+	segments := m.WallSegments()
+	if len(segments) != len(expected) {
+		t.Fatalf("%v segments, not %v: %+v", len(segments), len(expected), segments)
+	}
+	for i, e := range(expected) {
+		if segments[i] != e.segment {
+			t.Errorf("segment %v is %+v, not %+v", i, segments[i], e.segment)
+		}
+		if length := segments[i].Length(100, 10); length != e.length {
+			t.Errorf("segment %v is %v long, not %v", i, length, e.length)
+		}
+	}
+
+	// Each segment is a rectangle of four lines, and the Y axis is
+	// flipped so that the top row is at 200.
+	var dxf bytes.Buffer
+	if err := m.WriteDXF(&dxf, 100, 10); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(dxf.String(), "\n")
+	if count := strings.Count(dxf.String(), "  0\nLINE\n"); count != 4 * len(expected) {
+		t.Errorf("%v LINE entities, not %v", count, 4 * len(expected))
+	}
+	first := strings.Join(lines[4:20], "\n")
+	if wanted := "  0\nLINE\n  8\nWALLS\n 10\n-5.000\n 20\n205.000\n 30\n0.0\n 11\n205.000\n 21\n205.000\n 31\n0.0"; first != wanted {
+		t.Errorf("the first LINE is\n%v\nnot\n%v", first, wanted)
+	}
+
+	var bom bytes.Buffer
+	if err := m.WriteBillOfMaterials(&bom, 100, 10); err != nil {
+		t.Fatal(err)
+	}
+	for _, wanted := range([]string{
+		"     1       210.0  panel\n     1       190.0  panel\n     2       110.0  panel\n     1       100.0  panel\n",
+		"Total pieces: 5.  Total length: 720.0.\n",
+	}) {
+		if !strings.Contains(bom.String(), wanted) {
+			t.Errorf("the bill of materials is missing %q:\n%v", wanted, bom.String())
+		}
+	}
+
+	for _, dimensions := range([][2]float64{{0, 10}, {-100, 10}, {100, 0}, {100, 100}, {100, 150}}) {
+		var buffer bytes.Buffer
+		if err := m.WriteDXF(&buffer, dimensions[0], dimensions[1]); err == nil || buffer.Len() > 0 {
+			t.Errorf("WriteDXF() accepted a pitch of %v and a thickness of %v", dimensions[0], dimensions[1])
+		}
+		if err := m.WriteBillOfMaterials(&buffer, dimensions[0], dimensions[1]); err == nil || buffer.Len() > 0 {
+			t.Errorf("WriteBillOfMaterials() accepted a pitch of %v and a thickness of %v", dimensions[0], dimensions[1])
+		}
+	}
+}
+
+// The statistics should agree with the passage graph: the rooms with one
+// doorway (counting the openings in the border) are the dead ends, every room is counted by its degree, and the
+// solution is as long as the shortest route from the entrance to the exit.
+func TestStats(t *testing.T) {
+	for _, thickness := range([]int{1, 3}) {
+		m := NewMaze(61, 31)
+		m.SetSeed("stats")
+		m.GenerateNested([]int{thickness})
+		stats := m.Stats()
+		g := m.Graph(false)
+
+		degrees := make([]int, len(g.Nodes))
+		for _, e := range(g.Edges) {
+			degrees[e.From]++
+			degrees[e.To]++
+		}
+		// The openings in the border count as doorways too.
+		for _, i := range(append(g.Entrances, g.Exits...)) {
+			degrees[i]++
+		}
+		byDegree := [5]int{}
+		for _, degree := range(degrees) {
+			byDegree[degree]++
+		}
+		if stats.Rooms != len(g.Nodes) || stats.RoomsByDegree != byDegree {
+			t.Errorf("thickness %v: %v rooms by degree %v; the graph has %v by %v", thickness, stats.Rooms, stats.RoomsByDegree, len(g.Nodes), byDegree)
+		}
+		if stats.DeadEnds != byDegree[1] || stats.DeadEnds == 0 {
+			t.Errorf("thickness %v: %v dead ends; the graph has %v", thickness, stats.DeadEnds, byDegree[1])
+		}
+		if stats.Junctions != byDegree[3] + byDegree[4] {
+			t.Errorf("thickness %v: %v junctions; the graph has %v", thickness, stats.Junctions, byDegree[3] + byDegree[4])
+		}
+		total := 0
+		for _, count := range(stats.RoomsByDegree) {
+			total += count
+		}
+		if total != stats.Rooms {
+			t.Errorf("thickness %v: the rooms by degree add up to %v, not %v", thickness, total, stats.Rooms)
+		}
+
+		route, ok := g.AStar(g.Entrance, g.Exit)
+		if !ok || stats.SolutionLength != len(route.Nodes) || stats.SolutionLength != len(m.solutionRooms()) {
+			t.Errorf("thickness %v: a solution of %v rooms, but the route has %v", thickness, stats.SolutionLength, len(route.Nodes))
+		}
+		if stats.SolutionShare <= 0 || stats.SolutionShare > 1 || stats.RiverFactor != float64(byDegree[2]) / float64(stats.Rooms) {
+			t.Errorf("thickness %v: a solution share of %v and a river factor of %v", thickness, stats.SolutionShare, stats.RiverFactor)
+		}
+	}
+}
+
+// Constraints should be parsed only from the forms that ParseConstraint()
+// documents, and GenerateWithConstraints() should keep going until they are
+// met or the attempts run out.
+func TestConstraints(t *testing.T) {
+	for _, s := range([]string{"", "deadEnds", "deadEnds>20", "deadEnds=>20", "noSuchMetric>=1", "deadEnds>=many", "deadEnds<="}) {
+		if c, err := ParseConstraint(s); err == nil {
+			t.Errorf("parsed %q as %v", s, c)
+		}
+	}
+	for s, expected := range(map[string]Constraint{
+		"deadEnds >= 20": {Metric: "deadEnds", Min: 20, Max: math.Inf(1)},
+		"solutionLength<=40": {Metric: "solutionLength", Min: math.Inf(-1), Max: 40},
+		"rooms=50": {Metric: "rooms", Min: 50, Max: 50},
+	}) {
+		c, err := ParseConstraint(s)
+		if err != nil || c != expected {
+			t.Errorf("parsed %q as %v (%v), not %v", s, c, err, expected)
+		}
+	}
+
+	// Ask for a longer solution than the first seed gives.
+	m := NewMaze(41, 21)
+	m.SetSeed("constraints")
+	m.GenerateNested([]int{1})
+	wanted := Constraint{Metric: "solutionLength", Min: float64(m.Stats().SolutionLength + 1), Max: math.Inf(1)}
+	m = NewMaze(41, 21)
+	attempts, err := m.GenerateWithConstraints(context.Background(), []int{1}, "constraints", []Constraint{wanted}, 100)
+	if err != nil || attempts < 2 || wanted.distance(m.Stats()) != 0 {
+		t.Errorf("%v after %v attempts: a solution of %v rooms for %v", err, attempts, m.Stats().SolutionLength, wanted)
+	}
+	other := NewMaze(41, 21)
+	other.SetSeed(m.Seed())
+	other.GenerateNested([]int{1})
+	if !slices.Equal(m.cells, other.cells) {
+		t.Errorf("the seed %q doesn't reproduce the maze", m.Seed())
+	}
+
+	// Nothing satisfies an impossible constraint, but the maze is still
+	// left holding one of the attempts.
+	for _, limit := range([]int{0, 1, 5}) {
+		m := NewMaze(41, 21)
+		attempts, err := m.GenerateWithConstraints(context.Background(), []int{1}, "impossible", []Constraint{{Metric: "rooms", Min: math.Inf(-1), Max: 0}}, limit)
+		if err == nil || attempts != max(1, limit) {
+			t.Errorf("a limit of %v: %v after %v attempts", limit, err, attempts)
+		}
+		if err := m.Validate(true); err != nil {
+			t.Errorf("a limit of %v: the closest maze is invalid:\n%v", limit, err)
+		}
+	}
+}
+
+// Validate() should report each kind of problem that it documents.
+func TestValidate(t *testing.T) {
+	perfect := []string{
+		"+---+",
+		"    |",
+		"+-+ +",
+		"|   |",
+		"+ +-+",
+	}
+	pocket := []string{
+		"+-----+",
+		"      |",
+		"+---+ +",
+		"|   | |",
+		"+-+-+ +",
+	}
+	loop := []string{
+		"+-----+",
+		"      |",
+		"+ + + +",
+		"|     |",
+		"+---+ +",
+	}
+	for _, test := range([]struct{
+		name string
+		rows []string
+		perfect bool
+		change func(m *Maze)
+		problem string
+	}{
+		{"perfect", perfect, true, nil, ""},
+		{"a sealed pocket", pocket, false, nil, "sealed pocket of 3 unit(s)"},
+		{"a loop", loop, false, nil, ""},
+		{"a loop in a perfect maze", loop, true, nil, "closes a loop"},
+		{"an unknown rune", perfect, false, func(m *Maze) { m.setCell(m.offset(2, 2), '#') }, "unexpected rune '#'"},
+		{"an entrance in a wall junction", perfect, false, func(m *Maze) { m.entrances = []rect{m.unitRect(point{2, 2})} }, "neither on the border of the maze nor in a room"},
+		{"an entrance in the border wall", perfect, false, func(m *Maze) { m.entrances = []rect{m.unitRect(point{0, 3})} }, "the entrance is walled off"},
+		{"no entrance", perfect, false, func(m *Maze) { m.entrances = nil }, "0 entrance(s) and 1 exit(s)"},
+	}) {
+		m := NewMaze(1, 1)
+		if err := m.Load(strings.NewReader(strings.Join(test.rows, "\n"))); err != nil {
+			t.Fatal(err)
+		}
+		if test.change != nil {
+			test.change(&m)
+		}
+		err := m.Validate(test.perfect)
+		switch {
+		case test.problem == "" && err != nil:
+			t.Errorf("%v: the maze is invalid:\n%v", test.name, err)
+		case test.problem != "" && err == nil:
+			t.Errorf("%v: the maze is valid", test.name)
+		case test.problem != "" && !strings.Contains(err.Error(), test.problem):
+			t.Errorf("%v: %q is not among the problems:\n%v", test.name, test.problem, err)
+		}
+	}
+}
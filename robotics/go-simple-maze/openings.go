@@ -0,0 +1,384 @@
+package main
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Entrances and exits.
+//
+// By default, generation cuts one entrance and one exit into the border, as
+// far apart as it can find (see findEntranceAndExit().)  SetOpenings() asks
+// for particular ones instead, and for any number of each.  Each opening is
+// given as one of:
+//
+//   left:5      The opening in the left border that lines up with cell row 5.
+//               Likewise "right:5", and "top:12" and "bottom:12" for cell
+//               columns.
+//   left:*      Wherever in the left border is farthest from the openings
+//               placed so far.
+//   *           Wherever in the border is farthest from the openings placed
+//               so far.
+//   center      The room in the middle of the maze, like the goal of a
+//               micromouse maze.
+//   12,7        The room that contains cell (12, 7).
+//
+// The last two are goals in the interior: nothing is cut for them, but the
+// solution leads to (or from) them all the same.  The openings with a fixed
+// position are placed first, and then the others in order, entrances before
+// exits; so "--entrance left:5 --exit *" puts the exit as far from the
+// entrance as the maze allows.  In a nested maze, only the last pass makes
+// the openings.
+//
+// With many entrances and exits, the solution is the shortest path from any
+// entrance to any exit.
+
+// An opening that generation should make; see ParseOpening().
+type OpeningSpec struct {
+	// "left", "right", "top", "bottom", "*" for any of them, "center", or
+	// "" for the room that contains cell (X, Y).
+	Side string
+
+	// For a side, the cell row or column that the opening lines up with,
+	// or -1 for wherever is farthest from the other openings.
+	Position int
+
+	X, Y int
+}
+
+// Parses an opening in one of the forms described above.
+func ParseOpening(s string) (OpeningSpec, error) {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "*":
+		return OpeningSpec{Side: "*", Position: -1}, nil
+	case "center":
+		return OpeningSpec{Side: "center"}, nil
+	}
+	if side, position, ok := strings.Cut(s, ":"); ok {
+		if !slices.Contains(borderSideNames(), side) {
+			return OpeningSpec{}, fmt.Errorf("unknown side \"%v\" in \"%v\"; expected one of %v", side, s, strings.Join(borderSideNames(), ", "))
+		}
+		if position == "*" {
+			return OpeningSpec{Side: side, Position: -1}, nil
+		}
+		n, err := strconv.Atoi(position)
+		if err != nil || n < 0 {
+			return OpeningSpec{}, fmt.Errorf("invalid position \"%v\" in \"%v\"", position, s)
+		}
+		return OpeningSpec{Side: side, Position: n}, nil
+	}
+	if xs, ys, ok := strings.Cut(s, ","); ok {
+		x, errX := strconv.Atoi(strings.TrimSpace(xs))
+		y, errY := strconv.Atoi(strings.TrimSpace(ys))
+		if errX != nil || errY != nil || x < 0 || y < 0 {
+			return OpeningSpec{}, fmt.Errorf("invalid coordinates \"%v\"", s)
+		}
+		return OpeningSpec{X: x, Y: y}, nil
+	}
+	return OpeningSpec{}, fmt.Errorf("invalid opening \"%v\"; expected \"side:position\", \"side:*\", \"*\", \"center\" or \"x,y\"", s)
+}
+
+// Parses each of the given openings.
+func ParseOpenings(list []string) ([]OpeningSpec, error) {
+	result := []OpeningSpec{}
+	for _, s := range(list) {
+		spec, err := ParseOpening(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, spec)
+	}
+	return result, nil
+}
+
+func (o OpeningSpec) String() string {
+	switch {
+	case o.Side == "":
+		return fmt.Sprintf("%v,%v", o.X, o.Y)
+	case o.Side == "*" || o.Side == "center":
+		return o.Side
+	case o.Position < 0:
+		return o.Side + ":*"
+	}
+	return fmt.Sprintf("%v:%v", o.Side, o.Position)
+}
+
+func borderSideNames() []string {
+	return []string{"left", "right", "top", "bottom"}
+}
+
+// Sets the openings that generation should make instead of the usual
+// entrance and exit, or nil and nil to go back to those.  If only one of the
+// lists is empty, it gets a single "*".
+func (m *Maze) SetOpenings(entrances, exits []OpeningSpec) {
+	m.entranceSpecs, m.exitSpecs = nil, nil
+	if len(entrances) == 0 && len(exits) == 0 {
+		return
+	}
+	anywhere := []OpeningSpec{{Side: "*", Position: -1}}
+	m.entranceSpecs, m.exitSpecs = slices.Clone(entrances), slices.Clone(exits)
+	if len(entrances) == 0 {
+		m.entranceSpecs = anywhere
+	}
+	if len(exits) == 0 {
+		m.exitSpecs = anywhere
+	}
+}
+
+// Returns the first of the given rectangles, or an empty one.
+func first(rects []rect) rect {
+	if len(rects) == 0 {
+		return rect{}
+	}
+	return rects[0]
+}
+
+// Returns a copy of the given rectangles with the first one replaced (or
+// added, if there are none.)
+func replaceFirst(rects []rect, r rect) []rect {
+	return append([]rect{r}, rects[min(1, len(rects)):]...)
+}
+
+// Returns the unit whose rectangle has the opening's upper-left corner.
+func (m *Maze) openingUnit(r rect) point {
+	x, y := m.cellToUnitCoordinates(r.x, r.y)
+	return point{x, y}
+}
+
+// Returns the room that the given opening leads into: the opening itself if
+// it's a goal in the interior, or else the room just inside the border.  The
+// second return value is false if there is no such room.
+func (m *Maze) openingRoom(r rect) (point, bool) {
+	if p := m.openingUnit(r); m.isRoom(p) {
+		return p, true
+	}
+	return m.roomInside(m.openingUnit(r))
+}
+
+// Returns the rooms that the given openings lead into.
+func (m *Maze) openingRooms(rects []rect) []point {
+	result := []point{}
+	for _, r := range(rects) {
+		if room, ok := m.openingRoom(r); ok {
+			result = append(result, room)
+		}
+	}
+	return result
+}
+
+// Returns "entrance" or "exit" if any of the entrances or exits lead into the
+// given room, and "" otherwise.
+func (m *Maze) goalKind(room point) string {
+	if slices.Contains(m.openingRooms(m.entrances), room) {
+		return "entrance"
+	}
+	if slices.Contains(m.openingRooms(m.exits), room) {
+		return "exit"
+	}
+	return ""
+}
+
+// Returns the odd unit row or column whose interior contains the given cell
+// row or column.  The second return value is false if the cell is on a line
+// of posts instead.
+func (m *Maze) roomUnitAlong(cell int) (int, bool) {
+	unit := cell
+	switch {
+	case m.thickness == 2:
+		unit = cell / 2
+	case m.thickness > 2:
+		if cell % (m.thickness - 1) == 0 {
+			return 0, false
+		}
+		unit = cell / (m.thickness - 1)
+	}
+	return unit, cell >= 0 && unit % 2 == 1
+}
+
+// An opening that has been placed, but not yet cut.
+type placedOpening struct {
+	// The unit in the border to cut, or the room itself for a goal in the
+	// interior.
+	unit point
+	room point
+	border bool
+}
+
+// Returns the opening in the given side of the border for the room at the
+// given unit row (for left and right) or column (for top and bottom.)
+func (m *Maze) sideOpening(side string, unit int) placedOpening {
+	unitWidth, unitHeight := m.unitDimensions()
+	o := placedOpening{border: true}
+	switch side {
+	case "left":
+		o.unit, o.room = point{0, unit}, point{1, unit}
+	case "right":
+		o.unit, o.room = point{unitWidth - 1, unit}, point{unitWidth - 2, unit}
+	case "top":
+		o.unit, o.room = point{unit, 0}, point{unit, 1}
+	default:
+		o.unit, o.room = point{unit, unitHeight - 1}, point{unit, unitHeight - 2}
+	}
+	return o
+}
+
+// Places an opening with a fixed position.
+func (m *Maze) placeFixedOpening(spec OpeningSpec) (placedOpening, error) {
+	unitWidth, unitHeight := m.unitDimensions()
+	var o placedOpening
+	switch spec.Side {
+	case "center":
+		o.room = point{unitWidth / 2 | 1, unitHeight / 2 | 1}
+	case "":
+		x, okX := m.roomUnitAlong(spec.X)
+		y, okY := m.roomUnitAlong(spec.Y)
+		if !okX || !okY {
+			return o, fmt.Errorf("(%v, %v) is not in a room", spec.X, spec.Y)
+		}
+		o.room = point{x, y}
+	default:
+		unit, ok := m.roomUnitAlong(spec.Position)
+		if !ok {
+			return o, fmt.Errorf("%v doesn't line up with a corridor", spec.Position)
+		}
+		o = m.sideOpening(spec.Side, unit)
+		if o.room.x < 1 || o.room.y < 1 || o.room.x > unitWidth - 2 || o.room.y > unitHeight - 2 {
+			return o, fmt.Errorf("%v is beyond the %v side of the maze", spec.Position, spec.Side)
+		}
+	}
+	if !m.isRoom(o.room) {
+		return o, fmt.Errorf("the room is walled off or outside of the maze")
+	}
+	if !o.border {
+		o.unit = o.room
+	}
+	return o, nil
+}
+
+// Returns the places in the border where an opening on the given side (or
+// "*" for any side) could go.
+func (m *Maze) openingCandidates(side string) []placedOpening {
+	unitWidth, unitHeight := m.unitDimensions()
+	result := []placedOpening{}
+	for _, s := range(borderSideNames()) {
+		if side != "*" && side != s {
+			continue
+		}
+		count := unitHeight
+		if s == "top" || s == "bottom" {
+			count = unitWidth
+		}
+		for unit := 1; unit < count - 1; unit += 2 {
+			if o := m.sideOpening(s, unit); m.isRoom(o.room) {
+				result = append(result, o)
+			}
+		}
+	}
+	return result
+}
+
+// Returns the candidate whose room is farthest from the rooms with the given
+// distances (from roomDistances()), leaving out the ones that are taken, and
+// its distance.  The distance is -1 if none of them can be reached.
+func (m *Maze) farthestOpening(candidates []placedOpening, distances []int, taken []placedOpening) (placedOpening, int) {
+	unitWidth, _ := m.unitDimensions()
+	best, bestDistance := placedOpening{}, -1
+	for _, c := range(candidates) {
+		distance := distances[c.room.y * unitWidth + c.room.x]
+		if distance > bestDistance && !slices.Contains(taken, c) {
+			best, bestDistance = c, distance
+		}
+	}
+	return best, bestDistance
+}
+
+// Step 6, when SetOpenings() asked for particular openings: places them in
+// the finished maze and cuts the ones in the border.
+func (g *Generator) cutRequestedOpenings() {
+	m := g.m
+	specs := append(slices.Clone(m.entranceSpecs), m.exitSpecs...)
+	placed := make([]*placedOpening, len(specs))
+	taken := []placedOpening{}
+	fail := func(i int, err error) {
+		kind := "entrance"
+		if i >= len(m.entranceSpecs) {
+			kind = "exit"
+		}
+		g.err = fmt.Errorf("could not place the %v %v: %w", kind, specs[i], err)
+	}
+
+	// First the openings with fixed positions...
+	for i, spec := range(specs) {
+		if spec.Position >= 0 && spec.Side != "*" {
+			o, err := m.placeFixedOpening(spec)
+			if err != nil {
+				fail(i, err)
+				return
+			}
+			placed[i] = &o
+			taken = append(taken, o)
+		}
+	}
+
+	// ...then the others, each as far from the ones before it as it can
+	// be.  If there are none before it, it goes wherever is farthest from
+	// where the next one could go.
+	for i, spec := range(specs) {
+		if placed[i] != nil {
+			continue
+		}
+		candidates := m.openingCandidates(spec.Side)
+		var o placedOpening
+		bestDistance := -1
+		if len(taken) > 0 {
+			rooms := []point{}
+			for _, t := range(taken) {
+				rooms = append(rooms, t.room)
+			}
+			o, bestDistance = m.farthestOpening(candidates, m.roomDistances(rooms...), taken)
+		} else {
+			targets := m.openingCandidates("*")
+			for j := i + 1; j < len(specs); j++ {
+				if placed[j] == nil {
+					targets = m.openingCandidates(specs[j].Side)
+					break
+				}
+			}
+			for _, c := range(candidates) {
+				if g.stopped() {
+					return
+				}
+				if _, distance := m.farthestOpening(targets, m.roomDistances(c.room), []placedOpening{c}); distance > bestDistance {
+					o, bestDistance = c, distance
+				}
+			}
+		}
+		if bestDistance < 0 {
+			fail(i, fmt.Errorf("there is no room for it"))
+			return
+		}
+		placed[i] = &o
+		taken = append(taken, o)
+	}
+
+	unitWidth, unitHeight := m.unitDimensions()
+	m.entrances, m.exits = []rect{}, []rect{}
+	for i, o := range(placed) {
+		if o.border {
+			m.cutBorderOpening(o.unit, o.unit.y == 0 || o.unit.y == unitHeight - 1, unitWidth, unitHeight)
+		}
+		r := m.unitRect(o.unit)
+		if i < len(m.entranceSpecs) {
+			m.entrances = append(m.entrances, r)
+			m.emit(EntranceEvent, r.x, r.y, r.width, r.height, g.wallCount)
+			m.log().Info("entrance", "spec", specs[i].String(), rectAttr("rect", r.x, r.y, r.width, r.height))
+		} else {
+			m.exits = append(m.exits, r)
+			m.emit(ExitEvent, r.x, r.y, r.width, r.height, g.wallCount)
+			m.log().Info("exit", "spec", specs[i].String(), rectAttr("rect", r.x, r.y, r.width, r.height))
+		}
+	}
+	g.opened = true
+}
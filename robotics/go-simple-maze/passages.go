@@ -0,0 +1,248 @@
+package main
+
+// Routines for walking the maze's passages.
+//
+// Recall that the maze is drawn on a grid of unit rectangles whose width and
+// height are both odd.  The units at even coordinates are where walls start
+// and end; the units at odd coordinates on both axes are the "rooms" of the
+// maze, and the units between two neighboring rooms are either a wall or a
+// doorway between them.  The entrance and exit are doorways cut into the
+// border wall.
+//
+// The functions in this file treat the rooms as the nodes of a graph, with an
+// edge between two rooms whenever the unit between them is open.  The
+// entrances and exits lead into rooms (see openingRoom()), and the solution
+// is the shortest path from any of the former to any of the latter.
+
+// A unit coordinate.
+type point struct {
+	x, y int
+}
+
+// Returns true if the given unit coordinate is a room: an open unit whose
+// coordinates are both odd.
+func (m *Maze) isRoom(p point) bool {
+	unitWidth, unitHeight := m.unitDimensions()
+	if p.x < 0 || p.y < 0 || p.x >= unitWidth || p.y >= unitHeight {
+		return false
+	}
+	return p.x % 2 == 1 && p.y % 2 == 1 && m.unitIsOpen(p.x, p.y)
+}
+
+// Returns true if the unit between the given room and its neighbor in the
+// given direction (an index into the directions array) is open.  This is
+// true for doorways leading out of the maze, too.
+func (m *Maze) hasDoorway(room point, direction int) bool {
+	unitWidth, unitHeight := m.unitDimensions()
+	x, y := room.x + directions[direction].x, room.y + directions[direction].y
+	if x < 0 || y < 0 || x >= unitWidth || y >= unitHeight {
+		return false
+	}
+	return m.unitIsOpen(x, y)
+}
+
+// Returns the number of doorways leading out of the given room, including
+// the entrance and exit.
+func (m *Maze) roomDegree(room point) int {
+	degree := 0
+	for i := range(directions) {
+		if m.hasDoorway(room, i) {
+			degree++
+		}
+	}
+	return degree
+}
+
+// Returns true if the given room is just part of a corridor: it has two
+// doorways, both of them lead to other rooms, and it isn't a goal in the
+// interior.
+func (m *Maze) isPassThrough(room point) bool {
+	return m.roomDegree(room) == 2 && len(m.roomNeighbors(room)) == 2 && m.goalKind(room) == ""
+}
+
+// Returns the rooms that can be reached from the given room in a single step.
+func (m *Maze) roomNeighbors(room point) []point {
+	result := []point{}
+	for i, d := range(directions) {
+		neighbor := point{x: room.x + 2 * d.x, y: room.y + 2 * d.y}
+		if m.hasDoorway(room, i) && m.isRoom(neighbor) {
+			result = append(result, neighbor)
+		}
+	}
+	return result
+}
+
+// Returns every room in the maze, from left to right and top to bottom.
+func (m *Maze) rooms() []point {
+	unitWidth, unitHeight := m.unitDimensions()
+	result := []point{}
+	for y := 1; y < unitHeight; y += 2 {
+		for x := 1; x < unitWidth; x += 2 {
+			if m.isRoom(point{x, y}) {
+				result = append(result, point{x, y})
+			}
+		}
+	}
+	return result
+}
+
+// Returns the corridors of the maze: the chains of rooms that lead from one
+// room that is not a pass-through (see isPassThrough()) to another, including
+// both ends.  Each corridor is listed once, from whichever end comes first
+// from left to right and top to bottom.  Loops made only of pass-throughs
+// are left out, since they have no ends.
+func (m *Maze) corridors() [][]point {
+	unitWidth, _ := m.unitDimensions()
+	offset := func(p point) int { return p.y * unitWidth + p.x }
+	result := [][]point{}
+	for _, room := range(m.rooms()) {
+		if m.isPassThrough(room) {
+			continue
+		}
+
+		// Walk every corridor starting from each of its two ends, and
+		// only keep it from the end that comes first.
+		for _, next := range(m.roomNeighbors(room)) {
+			corridor := []point{room, next}
+			for current := next; m.isPassThrough(current); {
+				previous := corridor[len(corridor) - 2]
+				neighbors := m.roomNeighbors(current)
+				current = neighbors[0]
+				if current == previous {
+					current = neighbors[1]
+				}
+				corridor = append(corridor, current)
+			}
+			last := corridor[len(corridor) - 1]
+			if offset(last) > offset(room) || (last == room && offset(next) > offset(corridor[len(corridor) - 2])) {
+				result = append(result, corridor)
+			}
+		}
+	}
+	return result
+}
+
+// Returns the open units in the maze's border wall, going clockwise from the
+// upper-left corner.  The corners themselves are never openings.
+func (m *Maze) borderOpenings() []point {
+	unitWidth, unitHeight := m.unitDimensions()
+	result := []point{}
+	if unitWidth < 3 || unitHeight < 3 {
+		return result
+	}
+	for _, p := range(rectPerimeter(unitWidth, unitHeight)) {
+		corner := (p.x == 0 || p.x == unitWidth - 1) && (p.y == 0 || p.y == unitHeight - 1)
+		if !corner && m.unitIsOpen(p.x, p.y) {
+			result = append(result, point{p.x, p.y})
+		}
+	}
+	return result
+}
+
+// Returns the room just inside the given doorway in the border wall.  The
+// second return value is false if there is no such room.
+func (m *Maze) roomInside(doorway point) (point, bool) {
+	for _, d := range(directions) {
+		room := point{x: doorway.x + d.x, y: doorway.y + d.y}
+		if m.isRoom(room) {
+			return room, true
+		}
+	}
+	return point{}, false
+}
+
+// Performs a breadth-first search from the given rooms, returning the number
+// of steps from the nearest of them to every room in the maze, indexed by
+// unit offset (y * unitWidth + x.)  Units that are not reachable rooms have a
+// distance of -1.
+func (m *Maze) roomDistances(from ...point) []int {
+	unitWidth, unitHeight := m.unitDimensions()
+	distances := make([]int, max(0, unitWidth * unitHeight))
+	for i := range(distances) {
+		distances[i] = -1
+	}
+
+	queue := []point{}
+	for _, p := range(from) {
+		if m.isRoom(p) && distances[p.y * unitWidth + p.x] < 0 {
+			distances[p.y * unitWidth + p.x] = 0
+			queue = append(queue, p)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, neighbor := range(m.roomNeighbors(current)) {
+			if distances[neighbor.y * unitWidth + neighbor.x] < 0 {
+				distances[neighbor.y * unitWidth + neighbor.x] = distances[current.y * unitWidth + current.x] + 1
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+	return distances
+}
+
+// Returns the shortest path of rooms from any entrance to any exit, or nil
+// if no exit can be reached.
+func (m *Maze) solutionRooms() []point {
+	// Search backwards from the exits so that we can follow the distances
+	// downhill from the nearest entrance.
+	unitWidth, _ := m.unitDimensions()
+	distances := m.roomDistances(m.openingRooms(m.exits)...)
+	start, startDistance := point{}, -1
+	for _, room := range(m.openingRooms(m.entrances)) {
+		distance := distances[room.y * unitWidth + room.x]
+		if distance >= 0 && (startDistance < 0 || distance < startDistance) {
+			start, startDistance = room, distance
+		}
+	}
+	if startDistance < 0 {
+		return nil
+	}
+	path := []point{start}
+	for current := start; distances[current.y * unitWidth + current.x] > 0; {
+		for _, neighbor := range(m.roomNeighbors(current)) {
+			if distances[neighbor.y * unitWidth + neighbor.x] == distances[current.y * unitWidth + current.x] - 1 {
+				current = neighbor
+				break
+			}
+		}
+		path = append(path, current)
+	}
+	return path
+}
+
+// Returns the solution as a sequence of orthogonally adjacent units, starting
+// with the entrance and ending with the exit.  This includes the doorways
+// between rooms, so it is suitable for drawing the solution on the maze.
+// (Goals in the interior have no doorway; the path starts or ends in their
+// room.)
+func (m *Maze) solutionUnits() []point {
+	rooms := m.solutionRooms()
+	if rooms == nil {
+		return nil
+	}
+	path := m.doorwayInto(m.entrances, rooms[0])
+	for i, room := range(rooms) {
+		if i > 0 {
+			previous := rooms[i - 1]
+			path = append(path, point{x: (previous.x + room.x) / 2, y: (previous.y + room.y) / 2})
+		}
+		path = append(path, room)
+	}
+	return append(path, m.doorwayInto(m.exits, rooms[len(rooms) - 1])...)
+}
+
+// Returns the unit of the first of the given openings that leads into the
+// given room through the border, or nothing if they're all goals in the
+// interior.
+func (m *Maze) doorwayInto(openings []rect, room point) []point {
+	for _, r := range(openings) {
+		if into, ok := m.openingRoom(r); ok && into == room {
+			if unit := m.openingUnit(r); unit != room {
+				return []point{unit}
+			}
+		}
+	}
+	return []point{}
+}
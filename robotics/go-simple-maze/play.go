@@ -0,0 +1,340 @@
+package main
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"github.com/akamensky/argparse"
+)
+
+// "maze play": walking through a maze in the terminal.
+//
+// The terminal is switched into raw mode with stty(1), so that each key
+// press arrives as soon as it's typed, and the maze is redrawn in place
+// after each move (and once a second, to keep the timer running.)  The
+// player walks a character at a time, starting from the entrance (the first
+// one, if there are several), and wins by reaching any exit.
+//
+// With --fog, only the cells near the player are drawn, along with the ones
+// that the player has already seen.
+
+// The keys that "maze play" understands.
+const playHelp = "Arrows/WASD: move   H: show/hide the solution   Q: quit"
+
+// The state of a game in progress.
+type game struct {
+	m *Maze
+	cells []string
+	wide bool
+	playerGlyph string
+	player point
+	gridWidth, gridHeight int
+
+	// The radius, in cells, that the player can see, or 0 to see
+	// everything.
+	fog int
+	seen []bool
+
+	showSolution bool
+	toExit []int
+
+	moves int
+	start time.Time
+}
+
+func newGame(m *Maze, playerGlyph string, fog int) (*game, error) {
+	g := &game{
+		m: m,
+		cells: m.renderedCells(),
+		wide: m.hasWideGlyphs(),
+		playerGlyph: playerGlyph,
+		fog: fog,
+		seen: make([]bool, m.cellCount()),
+		toExit: m.cellDistancesFrom(m.exits...),
+		start: time.Now(),
+	}
+	g.gridWidth, g.gridHeight = m.gridDimensions()
+	if !isSingleGlyph(playerGlyph) {
+		return nil, fmt.Errorf("the player, \"%v\", is not a single character", playerGlyph)
+	}
+	if glyphWidth(playerGlyph) == 2 && !g.wide {
+		return nil, fmt.Errorf("the player, \"%v\", is two columns wide, but the maze is not; use a wide glyph for the floor as well", playerGlyph)
+	}
+
+	// Start on the floor cell nearest the middle of the first entrance.
+	e := first(m.entrances)
+	g.player = point{-1, -1}
+	bestDistance := 0
+	for y := e.y; y < e.y + e.height; y++ {
+		for x := e.x; x < e.x + e.width; x++ {
+			if !m.valid(x, y) || m.cell(m.offset(x, y)) != m.floor {
+				continue
+			}
+			distance := abs(2 * x - (2 * e.x + e.width - 1)) + abs(2 * y - (2 * e.y + e.height - 1))
+			if g.player.x < 0 || distance < bestDistance {
+				g.player, bestDistance = point{x, y}, distance
+			}
+		}
+	}
+	if g.player.x < 0 {
+		return nil, errors.New("the maze has no entrance")
+	}
+	if g.toExit[m.offset(g.player.x, g.player.y)] < 0 {
+		return nil, errors.New("the exit cannot be reached from the entrance")
+	}
+	g.look()
+	return g, nil
+}
+
+// Marks the cells around the player as seen.
+func (g *game) look() {
+	for y := g.player.y - g.fog; y <= g.player.y + g.fog; y++ {
+		for x := g.player.x - g.fog; x <= g.player.x + g.fog; x++ {
+			if g.m.valid(x, y) {
+				g.seen[g.m.offset(x, y)] = true
+			}
+		}
+	}
+}
+
+// Moves the player by the given amount if there's floor there.
+func (g *game) move(dx, dy int) {
+	x, y := g.player.x + dx, g.player.y + dy
+	if x < 0 || y < 0 || x >= g.gridWidth || y >= g.gridHeight || g.m.cell(g.m.offset(x, y)) != g.m.floor {
+		return
+	}
+	g.player = point{x, y}
+	g.moves++
+	g.look()
+}
+
+// Returns true once the player has reached the exit.
+func (g *game) won() bool {
+	return g.toExit[g.m.offset(g.player.x, g.player.y)] == 0
+}
+
+// Returns the cells on the shortest path from the player to the exit.
+func (g *game) pathToExit() []bool {
+	result := make([]bool, g.m.cellCount())
+	current := g.player
+	for g.toExit[g.m.offset(current.x, current.y)] > 0 {
+		distance := g.toExit[g.m.offset(current.x, current.y)]
+		for _, d := range(directions) {
+			next := point{x: current.x + d.x, y: current.y + d.y}
+			if g.m.valid(next.x, next.y) && g.toExit[g.m.offset(next.x, next.y)] == distance - 1 {
+				current = next
+				break
+			}
+		}
+		result[g.m.offset(current.x, current.y)] = true
+	}
+	return result
+}
+
+// Draws the maze and the status line.  Since the terminal is in raw mode,
+// lines end in "\r\n".
+func (g *game) render() string {
+	pad := func(s string) string {
+		if g.wide && glyphWidth(s) == 1 {
+			return s + " "
+		}
+		return s
+	}
+	var path []bool
+	if g.showSolution {
+		path = g.pathToExit()
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for y := 0; y < g.m.height; y++ {
+		for x := 0; x < g.m.width; x++ {
+			offset := g.m.offset(x, y)
+			switch {
+			case x == g.player.x && y == g.player.y:
+				b.WriteString(pad(g.playerGlyph))
+			case g.fog > 0 && !g.seen[offset]:
+				b.WriteString(pad(" "))
+			case path != nil && path[offset]:
+				b.WriteString(pad("·"))
+			default:
+				b.WriteString(g.cells[offset])
+			}
+		}
+		b.WriteString("\x1b[K\r\n")
+	}
+	elapsed := time.Since(g.start) / time.Second
+	fmt.Fprintf(&b, "\x1b[KMoves: %v   Time: %d:%02d\r\n\x1b[K%v\r\n", g.moves, elapsed / 60, elapsed % 60, playHelp)
+	return b.String()
+}
+
+// Runs stty(1) on the terminal and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	output, err := cmd.Output()
+	return strings.TrimSpace(string(output)), err
+}
+
+// Puts the terminal into raw mode, returning a function that restores the
+// previous mode.
+func rawTerminal() (restore func(), err error) {
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("could not read the terminal settings: %v", err)
+	}
+	if _, err := stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("could not put the terminal into raw mode: %v", err)
+	}
+	return func() { stty(saved) }, nil
+}
+
+// Reads key presses from standard input and sends them to the channel.  An
+// arrow key arrives as a three-byte escape sequence ("\x1b[A" or, in
+// application mode, "\x1bOA"), which is sent as a single key; everything
+// else is sent a byte at a time.
+func readKeys(keys chan<- string) {
+	buffer := make([]byte, 64)
+	for {
+		n, err := os.Stdin.Read(buffer)
+		if err != nil {
+			close(keys)
+			return
+		}
+		for input := string(buffer[:n]); len(input) > 0; {
+			size := 1
+			if len(input) >= 3 && input[0] == '\x1b' && (input[1] == '[' || input[1] == 'O') {
+				size = 3
+			}
+			keys <- input[:size]
+			input = input[size:]
+		}
+	}
+}
+
+// Plays the game until the player wins or quits.
+func (g *game) play() error {
+	restore, err := rawTerminal()
+	if err != nil {
+		return err
+	}
+	// Switch to the alternate screen and hide the cursor, and put
+	// everything back when we're done.  The summary is repeated on the
+	// normal screen, since the win screen goes away with the alternate
+	// one.
+	summary := ""
+	fmt.Print("\x1b[?1049h\x1b[?25l\x1b[2J")
+	defer func() {
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		restore()
+		if summary != "" {
+			fmt.Println(summary)
+		}
+	}()
+
+	keys := make(chan string)
+	go readKeys(keys)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for !g.won() {
+		fmt.Print(g.render())
+		select {
+		case <-ticker.C:
+			continue
+		case key, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			switch key {
+			case "\x1b[A", "\x1bOA", "w", "W":
+				g.move(0, -1)
+			case "\x1b[B", "\x1bOB", "s", "S":
+				g.move(0, 1)
+			case "\x1b[D", "\x1bOD", "a", "A":
+				g.move(-1, 0)
+			case "\x1b[C", "\x1bOC", "d", "D":
+				g.move(1, 0)
+			case "h", "H", "?":
+				g.showSolution = !g.showSolution
+			case "q", "Q", "\x1b", "\x03":
+				return nil
+			}
+		}
+	}
+
+	// The win screen shows the whole maze, fog or no fog.
+	g.fog = 0
+	fmt.Print(g.render())
+	summary = fmt.Sprintf("You escaped in %v moves and %v!", g.moves, time.Since(g.start).Round(time.Second))
+	fmt.Printf("\x1b[K%v  Press any key.\r\n", summary)
+	<-keys
+	return nil
+}
+
+// The entry point for "maze play".
+func playMain(args []string) {
+	config, err := LoadConfig(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read the configuration file: %v.\n", err)
+		os.Exit(1)
+	}
+	parser := argparse.NewParser("maze play", "Lets you walk through a maze in the terminal.  " + playHelp + ".  Without --input, the maze is generated from the same arguments that \"maze\" takes.")
+	arguments := addMazeArguments(parser, config)
+	var input *string = parser.String("I", "input", &argparse.Options{
+		Required: false,
+		Help: "A file containing a maze to play, as printed by \"maze\".  The display runes and (single) thickness must match the ones the maze was drawn with",
+		Default: "",
+	})
+	var fog *int = parser.Int("", "fog", &argparse.Options{
+		Required: false,
+		Help: "If this is greater than 0, only the cells within this many characters of the player (and the ones that the player has already seen) are shown",
+		Default: 0,
+	})
+	var playerGlyph *string = parser.String("", "player", &argparse.Options{
+		Required: false,
+		Help: "The character to draw the player with",
+		Default: "@",
+	})
+
+	err = parser.Parse(args)
+	if err != nil {
+		fmt.Print(parser.Usage(err))
+		return
+	}
+	m, ok := arguments.newMaze(parser)
+	if !ok {
+		return
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode() & os.ModeCharDevice == 0 {
+		fmt.Fprintf(os.Stderr, "\"maze play\" needs a terminal to read keys from.\n")
+		os.Exit(1)
+	}
+
+	if *input == "" {
+		if !arguments.generate(&m) {
+			os.Exit(1)
+		}
+	} else {
+		f, err := os.Open(*input)
+		if err == nil {
+			err = m.Load(f)
+			f.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load \"%v\": %v\n", *input, err)
+			os.Exit(1)
+		}
+	}
+
+	g, err := newGame(&m, *playerGlyph, max(0, *fog))
+	if err == nil {
+		err = g.play()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not play: %v.\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,520 @@
+package main
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Reserved regions.
+//
+// Reserve() sets aside part of the maze before it is generated, for set
+// pieces such as a boss room or a treasure vault.  A region is either:
+//
+//   solid   A block of wall.  The maze's walls are drawn around it, just as
+//           they are drawn around the border.
+//   room    An open area, walled off from the rest of the maze except for
+//           the given number of doors.
+//
+// Regions are given in cells, as a rectangle and (optionally) a mask of the
+// cells in it that belong to the region.  A region takes up every room of
+// the first pass's grid (see passages.go) whose interior it overlaps, and
+// the walls around those rooms; with thick walls, that can be a good deal
+// less open space than was asked for.
+//
+// While the passes draw their walls, every region is a solid block.  The
+// walls only ever grow into open floor, so they can't join the block to
+// anything else, and the rest of the maze stays in one piece as long as the
+// regions don't cut it in two to begin with.  Once the walls are done (but
+// before the entrance and exit are cut, so that they can lead into a room),
+// the rooms are cleared out and their doors are cut on whichever sides of
+// them have the fewest doors so far.
+//
+// In a nested maze, the later passes go around the regions too, and the
+// doors are cut on the first pass's grid, so it's up to the repairs
+// afterward (see repair.go) to make sure that they lead somewhere.
+
+// A part of the maze that generation should set aside; see Reserve().
+type Region struct {
+	// "room" or "solid".
+	Kind string                  `json:"kind"`
+
+	// A name for the region, such as "boss", for the caller's use.
+	Name string                  `json:"name,omitempty"`
+
+	// The rectangle of cells that the region covers.
+	X int                        `json:"x"`
+	Y int                        `json:"y"`
+	Width int                    `json:"width"`
+	Height int                   `json:"height"`
+
+	// The cells of the rectangle that belong to the region, as
+	// Mask[row][column], or nil for all of them.
+	Mask [][]bool                `json:"mask,omitempty"`
+
+	// For a room, the number of doors into the rest of the maze.  Rooms
+	// always have at least one.
+	Doors int                    `json:"doors,omitempty"`
+}
+
+func regionKindNames() []string {
+	return []string{"room", "solid"}
+}
+
+// Parses a region of the given kind from "[NAME=]X,Y,WIDTHxHEIGHT", followed
+// by ":DOORS" for a room.
+func ParseRegion(kind, s string) (Region, error) {
+	r := Region{Kind: kind}
+	rest := strings.TrimSpace(s)
+	if name, after, ok := strings.Cut(rest, "="); ok {
+		r.Name, rest = strings.TrimSpace(name), after
+	}
+	if geometry, doors, ok := strings.Cut(rest, ":"); ok {
+		n, err := strconv.Atoi(strings.TrimSpace(doors))
+		if err != nil || n < 1 || kind != "room" {
+			return Region{}, fmt.Errorf("invalid number of doors \"%v\" in \"%v\"", doors, s)
+		}
+		r.Doors, rest = n, geometry
+	}
+	fields := strings.Split(rest, ",")
+	if len(fields) == 3 {
+		width, height, ok := strings.Cut(fields[2], "x")
+		values := []int{}
+		for _, field := range([]string{fields[0], fields[1], width, height}) {
+			if n, err := strconv.Atoi(strings.TrimSpace(field)); err == nil && ok {
+				values = append(values, n)
+			}
+		}
+		if len(values) == 4 {
+			r.X, r.Y, r.Width, r.Height = values[0], values[1], values[2], values[3]
+			return r, r.check()
+		}
+	}
+	return Region{}, fmt.Errorf("invalid region \"%v\"; expected \"x,y,widthxheight\", optionally preceded by \"name=\"", s)
+}
+
+// Returns an error if the region can't be reserved.
+func (r Region) check() error {
+	switch {
+	case !slices.Contains(regionKindNames(), r.Kind):
+		return fmt.Errorf("unknown kind of region \"%v\"; expected one of %v", r.Kind, strings.Join(regionKindNames(), ", "))
+	case r.Width < 1 || r.Height < 1:
+		return fmt.Errorf("the %v is %vx%v; it must be at least 1x1", r, r.Width, r.Height)
+	case r.Doors < 0:
+		return fmt.Errorf("the %v can't have %v doors", r, r.Doors)
+	}
+	return nil
+}
+
+// Describes the region for messages.
+func (r Region) String() string {
+	if r.Name != "" {
+		return fmt.Sprintf("%v \"%v\"", r.Kind, r.Name)
+	}
+	return fmt.Sprintf("%v at (%v, %v)", r.Kind, r.X, r.Y)
+}
+
+// Returns true if the given cell belongs to the region.
+func (r Region) contains(x, y int) bool {
+	if x < r.X || y < r.Y || x >= r.X + r.Width || y >= r.Y + r.Height {
+		return false
+	}
+	if r.Mask == nil {
+		return true
+	}
+	row := y - r.Y
+	return row < len(r.Mask) && x - r.X < len(r.Mask[row]) && r.Mask[row][x - r.X]
+}
+
+// Sets aside a region of the maze for the next generation (see above.)
+// Regions stay reserved until ClearRegions() is called.
+func (m *Maze) Reserve(r Region) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+	if r.Kind == "room" {
+		r.Doors = max(r.Doors, 1)
+	}
+	if r.Mask != nil {
+		r.Mask = slices.Clone(r.Mask)
+	}
+	m.regions = append(m.regions, r)
+	return nil
+}
+
+// Returns the reserved regions.
+func (m *Maze) Regions() []Region {
+	return slices.Clone(m.regions)
+}
+
+// Returns the doors that the last generation cut into the given region (an
+// index into Regions()), as rectangles of cells.  There are none for solid
+// regions, or if the rooms haven't been opened yet.
+func (m *Maze) RegionDoors(i int) []rect {
+	if i < 0 || i >= len(m.regionDoors) {
+		return nil
+	}
+	return slices.Clone(m.regionDoors[i])
+}
+
+// Forgets all of the reserved regions.
+func (m *Maze) ClearRegions() {
+	m.regions, m.regionDoors = nil, nil
+}
+
+// Returns the rooms of the current grid that the region covers: the ones
+// with one of its cells in their interior.
+func (m *Maze) regionRooms(r Region) []point {
+	unitWidth, unitHeight := m.unitDimensions()
+	result := []point{}
+	for unitRow := 1; unitRow < unitHeight - 1; unitRow += 2 {
+		for unitColumn := 1; unitColumn < unitWidth - 1; unitColumn += 2 {
+			x, y, width, height := m.unitCoordinatesToRect(unitColumn, unitRow)
+			if m.thickness > 2 {
+				x, y, width, height = x + 1, y + 1, width - 2, height - 2
+			}
+			if x >= r.X + r.Width || y >= r.Y + r.Height || x + width <= r.X || y + height <= r.Y {
+				continue
+			}
+			covered := false
+			for row := y; row < y + height && !covered; row++ {
+				for column := x; column < x + width && !covered; column++ {
+					covered = r.contains(column, row)
+				}
+			}
+			if covered {
+				result = append(result, point{unitColumn, unitRow})
+			}
+		}
+	}
+	return result
+}
+
+// Returns the units that the given rooms and the walls around them take up.
+func regionUnits(rooms []point) map[point]bool {
+	units := map[point]bool{}
+	for _, room := range(rooms) {
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				units[point{room.x + dx, room.y + dy}] = true
+			}
+		}
+	}
+	return units
+}
+
+// Returns true if the given unit is completely surrounded by the others.
+func surrounded(units map[point]bool, p point) bool {
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			if !units[point{p.x + dx, p.y + dy}] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns the rectangle of cells that bounds the given units, and a function
+// that says whether a cell is in any of them.
+func (m *Maze) unitCells(units map[point]bool) (rect, func(x, y int) bool) {
+	bounds := rect{}
+	rects := []rect{}
+	for unit := range(units) {
+		r := m.unitRect(unit)
+		if len(rects) == 0 {
+			bounds = r
+		} else {
+			right, bottom := max(bounds.x + bounds.width, r.x + r.width), max(bounds.y + bounds.height, r.y + r.height)
+			bounds.x, bounds.y = min(bounds.x, r.x), min(bounds.y, r.y)
+			bounds.width, bounds.height = right - bounds.x, bottom - bounds.y
+		}
+		rects = append(rects, r)
+	}
+	inside := make([]bool, bounds.width * bounds.height)
+	for _, r := range(rects) {
+		for y := r.y; y < r.y + r.height; y++ {
+			for x := r.x; x < r.x + r.width; x++ {
+				inside[(y - bounds.y) * bounds.width + x - bounds.x] = true
+			}
+		}
+	}
+	return bounds, func(x, y int) bool {
+		return inRect(x, y, bounds) && inside[(y - bounds.y) * bounds.width + x - bounds.x]
+	}
+}
+
+// Draws the cells for which contains() is true (all of them within bounds)
+// as a solid block: fill, outlined with walls that join whatever walls they
+// meet outside of it.
+func (m *Maze) drawBlock(bounds rect, contains func(x, y int) bool) {
+	isEdge := make([]bool, bounds.width * bounds.height)
+	for y := bounds.y; y < bounds.y + bounds.height; y++ {
+		for x := bounds.x; x < bounds.x + bounds.width; x++ {
+			if !contains(x, y) || !m.valid(x, y) {
+				continue
+			}
+			edge := false
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					edge = edge || !contains(x + dx, y + dy) || !m.valid(x + dx, y + dy)
+				}
+			}
+			isEdge[(y - bounds.y) * bounds.width + x - bounds.x] = edge
+			m.setCell(m.offset(x, y), m.fill)
+		}
+	}
+
+	// Now that we know where the edges are, each edge joins its
+	// neighbors along the edge, and any walls outside the block.
+	joins := func(x, y int) bool {
+		switch {
+		case !m.valid(x, y):
+			return false
+		case contains(x, y):
+			return isEdge[(y - bounds.y) * bounds.width + x - bounds.x]
+		}
+		return m.cell(m.offset(x, y)) != m.floor
+	}
+	for y := bounds.y; y < bounds.y + bounds.height; y++ {
+		for x := bounds.x; x < bounds.x + bounds.width; x++ {
+			if !m.valid(x, y) || !isEdge[(y - bounds.y) * bounds.width + x - bounds.x] {
+				continue
+			}
+			horizontal := joins(x - 1, y) || joins(x + 1, y)
+			vertical := joins(x, y - 1) || joins(x, y + 1)
+			switch {
+			case horizontal && !vertical:
+				m.setCell(m.offset(x, y), m.horizontal)
+			case vertical && !horizontal:
+				m.setCell(m.offset(x, y), m.vertical)
+			default:
+				m.setCell(m.offset(x, y), m.intersection)
+			}
+
+			// A line outside the block that the edge meets
+			// side-on now joins it, as it would if a wall had
+			// grown into it.
+			for _, d := range(directions) {
+				if !m.valid(x + d.x, y + d.y) || contains(x + d.x, y + d.y) {
+					continue
+				}
+				switch m.cell(m.offset(x + d.x, y + d.y)) {
+				case m.horizontal:
+					if d.y != 0 {
+						m.setCell(m.offset(x + d.x, y + d.y), m.intersection)
+					}
+				case m.vertical:
+					if d.x != 0 {
+						m.setCell(m.offset(x + d.x, y + d.y), m.intersection)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Draws the given units as walls.  For thicknesses greater than 1, that
+// means drawing each one on its own, the same way as the border; for a
+// thickness of 1, it's a single block.
+func (m *Maze) drawUnits(units map[point]bool) {
+	if m.thickness == 1 {
+		m.drawBlock(m.unitCells(units))
+		return
+	}
+	for unit := range(units) {
+		x, y, width, height := m.unitCoordinatesToRect(unit.x, unit.y)
+		m.drawRect(x, y, width, height, m.fill)
+	}
+}
+
+// Returns an error unless the rooms outside of the given units are all
+// connected to each other.
+func (m *Maze) checkFreeRooms(blocked map[point]bool) error {
+	free := []point{}
+	for _, room := range(m.rooms()) {
+		if !blocked[room] {
+			free = append(free, room)
+		}
+	}
+	if len(free) == 0 {
+		return errors.New("the reserved regions leave no room for the maze")
+	}
+	reached := map[point]bool{free[0]: true}
+	for queue := []point{free[0]}; len(queue) > 0; queue = queue[1:] {
+		for _, d := range(directions) {
+			neighbor := point{queue[0].x + 2 * d.x, queue[0].y + 2 * d.y}
+			if m.isRoom(neighbor) && !blocked[neighbor] && !reached[neighbor] {
+				reached[neighbor] = true
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+	if len(reached) < len(free) {
+		return errors.New("the reserved regions cut the maze in two")
+	}
+	return nil
+}
+
+// At the start of the first pass: draws the reserved regions as solid
+// blocks.  Returns false (and sets g.err) if they can't be placed.
+func (g *Generator) reserveRegions() bool {
+	m := g.m
+	owners := map[point]int{}
+	blocked := map[point]bool{}
+	for i, r := range(m.regions) {
+		rooms := m.regionRooms(r)
+		if len(rooms) == 0 {
+			g.err = fmt.Errorf("the %v doesn't cover any part of the maze", r)
+			return false
+		}
+		for _, room := range(rooms) {
+			if j, ok := owners[room]; ok {
+				g.err = fmt.Errorf("the %v overlaps the %v", r, m.regions[j])
+				return false
+			}
+			owners[room] = i
+		}
+		for unit := range(regionUnits(rooms)) {
+			blocked[unit] = true
+		}
+	}
+
+	if err := m.checkFreeRooms(blocked); err != nil {
+		g.err = err
+		return false
+	}
+
+	for _, r := range(m.regions) {
+		units := regionUnits(m.regionRooms(r))
+		m.drawUnits(units)
+		bounds, _ := m.unitCells(units)
+		m.emit(RegionEvent, bounds.x, bounds.y, bounds.width, bounds.height, 0)
+	}
+	g.regionThickness, m.regionDoors = m.thickness, nil
+	return true
+}
+
+// Once the walls are done: clears out the rooms among the reserved regions
+// and cuts their doors, on the grid that reserveRegions() drew them on.
+// Does nothing if that has been done already (or if there are no regions.)
+// Returns false (and sets g.err) if a room has nowhere to put a door.
+func (g *Generator) openRegions() bool {
+	m := g.m
+	if g.regionThickness == 0 || m.regionDoors != nil {
+		return true
+	}
+	thickness := m.thickness
+	m.thickness = g.regionThickness
+	defer func() { m.thickness = thickness }()
+
+	regionRooms := [][]point{}
+	blocked := map[point]bool{}
+	for _, r := range(m.regions) {
+		rooms := m.regionRooms(r)
+		regionRooms = append(regionRooms, rooms)
+		for unit := range(regionUnits(rooms)) {
+			blocked[unit] = true
+		}
+	}
+
+	doors := make([][]rect, len(m.regions))
+	for i, r := range(m.regions) {
+		doors[i] = []rect{}
+		if r.Kind != "room" {
+			continue
+		}
+		rooms := regionRooms[i]
+		units := regionUnits(rooms)
+
+		// Clear out everything inside the ring of walls around the
+		// room, and redraw the ring on its own.
+		ring := map[point]bool{}
+		for unit := range(units) {
+			if !surrounded(units, unit) {
+				ring[unit] = true
+			}
+		}
+		_, inRing := m.unitCells(ring)
+		for unit := range(units) {
+			if ring[unit] {
+				continue
+			}
+			x, y, width, height := m.unitCoordinatesToRect(unit.x, unit.y)
+			for row := y; row < y + height; row++ {
+				for column := x; column < x + width; column++ {
+					if !inRing(column, row) && m.valid(column, row) {
+						m.setCell(m.offset(column, row), m.floor)
+					}
+				}
+			}
+		}
+		m.drawUnits(ring)
+		bounds, _ := m.unitCells(units)
+
+		// A door can go anywhere that the ring lies between one of the
+		// room's rooms and one that isn't reserved.  (In a nested maze,
+		// the later passes may have walled that one off, but the
+		// repairs will reconnect it.)
+		type door struct {
+			unit point
+			side int
+		}
+		unitWidth, unitHeight := m.unitDimensions()
+		candidates := []door{}
+		for _, room := range(rooms) {
+			for side, d := range(directions) {
+				outside := point{room.x + 2 * d.x, room.y + 2 * d.y}
+				inMaze := outside.x > 0 && outside.y > 0 && outside.x < unitWidth - 1 && outside.y < unitHeight - 1
+				if inMaze && !blocked[outside] && (g.nested || m.isRoom(outside)) {
+					candidates = append(candidates, door{point{room.x + d.x, room.y + d.y}, side})
+				}
+			}
+		}
+		perSide := make([]int, len(directions))
+		for len(doors[i]) < r.Doors {
+			// Spread the doors out over the sides of the room.
+			fewest, choices := -1, []door{}
+			for _, c := range(candidates) {
+				switch {
+				case fewest < 0 || perSide[c.side] < fewest:
+					fewest, choices = perSide[c.side], []door{c}
+				case perSide[c.side] == fewest:
+					choices = append(choices, c)
+				}
+			}
+			if len(choices) == 0 {
+				break
+			}
+			c := choices[m.random.Intn(len(choices))]
+			candidates = slices.DeleteFunc(candidates, func(other door) bool { return other == c })
+			perSide[c.side]++
+
+			x, y, width, height := m.unitCoordinatesToRect(c.unit.x, c.unit.y)
+			if m.thickness > 2 {
+				// Only cut the middle of the wall, as for the
+				// entrance and exit.
+				if directions[c.side].x != 0 {
+					y, height = y + 1, height - 2
+				} else {
+					x, width = x + 1, width - 2
+				}
+			}
+			m.clearRect(x, y, width, height)
+			m.touchUpWalls(x, y, width, height)
+			doors[i] = append(doors[i], rect{x, y, width, height})
+		}
+		if len(doors[i]) == 0 {
+			g.err = fmt.Errorf("the %v has nowhere to put a door", r)
+			return false
+		}
+		if len(doors[i]) < r.Doors {
+			m.log().Warn("not enough room for the doors", "region", r.String(), "doors", len(doors[i]), "requested", r.Doors)
+		}
+		m.emit(RegionEvent, bounds.x, bounds.y, bounds.width, bounds.height, g.wallCount)
+		m.log().Info("room", "region", r.String(), rectAttr("rect", bounds.x, bounds.y, bounds.width, bounds.height), "doors", len(doors[i]))
+	}
+	m.regionDoors = doors
+	return true
+}
@@ -0,0 +1,340 @@
+package main
+
+// Repairs for nested mazes.
+//
+// Unless the thicknesses passed to GenerateNested() happen to produce unit
+// grids that line up with one another, each pass draws its ring of border
+// walls somewhere slightly different from the last one.  The new ring can
+// cut straight across the previous pass's entrance or exit, sealing off
+// whatever lay behind it, and it can leave the new pass with no outer
+// corridor in which to cut an entrance of its own.
+//
+// Rather than trying to prevent this, we clean up afterward: any part of the
+// maze that can't be reached from the entrance gets connected to the rest
+// by cutting the shortest possible passage through the walls, and the same
+// is done for a missing entrance or exit.
+
+// Returns true if the given unit is in the ring of units along the edge of
+// the maze.
+func (m *Maze) onBorder(p point) bool {
+	unitWidth, unitHeight := m.unitDimensions()
+	return p.x >= 0 && p.y >= 0 && p.x < unitWidth && p.y < unitHeight &&
+		(p.x == 0 || p.y == 0 || p.x == unitWidth - 1 || p.y == unitHeight - 1)
+}
+
+// Returns true if the given unit is one of the maze's four corners.
+func (m *Maze) isCorner(p point) bool {
+	unitWidth, unitHeight := m.unitDimensions()
+	return (p.x == 0 || p.x == unitWidth - 1) && (p.y == 0 || p.y == unitHeight - 1)
+}
+
+// Clears a passage through the given sequence of orthogonally adjacent units.
+//
+// For thicknesses greater than 2, the unit rectangles share their borders, so
+// we only clear the strip through the middle of each unit in the direction(s)
+// the path travels, the same way findEntranceAndExit() cuts the entrance.
+func (m *Maze) openPath(path []point) {
+	unitWidth, unitHeight := m.unitDimensions()
+	for i, p := range(path) {
+		horizontal, vertical := false, false
+		for _, j := range([]int{i - 1, i + 1}) {
+			if j < 0 || j >= len(path) {
+				continue
+			}
+			if path[j].x != p.x {
+				horizontal = true
+			} else {
+				vertical = true
+			}
+		}
+		if len(path) == 1 {
+			horizontal = true
+		}
+
+		x, y, width, height := m.unitCoordinatesToRect(p.x, p.y)
+		strips := [][4]int{}
+		switch {
+		case m.thickness <= 2:
+			strips = append(strips, [4]int{x, y, width, height})
+		default:
+			if horizontal {
+				strips = append(strips, [4]int{x, y + 1, width, height - 2})
+			}
+			if vertical {
+				strips = append(strips, [4]int{x + 1, y, width - 2, height})
+			}
+		}
+		for _, strip := range(strips) {
+			x, y, width, height := strip[0], strip[1], strip[2], strip[3]
+
+			// See findEntranceAndExit().
+			if p.x == unitWidth - 1 && horizontal {
+				width = m.width - x
+			}
+			if p.y == unitHeight - 1 && vertical {
+				height = m.height - y
+			}
+			m.clearRect(x, y, width, height)
+			m.touchUpWalls(x, y, width, height)
+		}
+	}
+
+	if m.eventHandler != nil && len(path) > 0 {
+		left, top, right, bottom := m.width, m.height, 0, 0
+		for _, p := range(path) {
+			x, y, width, height := m.unitCoordinatesToRect(p.x, p.y)
+			left, top = min(left, x), min(top, y)
+			right, bottom = max(right, x + width), max(bottom, y + height)
+		}
+		m.emit(PassageEvent, left, top, right - left, bottom - top, 0)
+	}
+}
+
+// Redraws the walls around the given rectangle after a passage has been cut
+// through it: fill that the passage exposed becomes wall, and walls that the
+// passage cut short become intersections so that they still join whatever
+// is left of their neighbors.
+func (m *Maze) touchUpWalls(x, y, width, height int) {
+	isWall := func(x, y int) bool {
+		return m.valid(x, y) && m.cell(m.offset(x, y)) != m.floor
+	}
+	for row := y - 1; row <= y + height; row++ {
+		for column := x - 1; column <= x + width; column++ {
+			if !m.valid(column, row) {
+				continue
+			}
+			switch m.cell(m.offset(column, row)) {
+			case m.fill:
+				exposedSideways := !m.hasNeighbor(column, row, left | right, m.fill) && (m.Get(column - 1, row) == m.floor || m.Get(column + 1, row) == m.floor)
+				exposedUpOrDown := !m.hasNeighbor(column, row, up | down, m.fill) && (m.Get(column, row - 1) == m.floor || m.Get(column, row + 1) == m.floor)
+				switch {
+				case exposedSideways && exposedUpOrDown:
+					m.setCell(m.offset(column, row), m.intersection)
+				case exposedSideways:
+					m.setCell(m.offset(column, row), m.vertical)
+				case exposedUpOrDown:
+					m.setCell(m.offset(column, row), m.horizontal)
+				}
+			case m.vertical:
+				if !isWall(column, row - 1) && !isWall(column, row + 1) {
+					m.setCell(m.offset(column, row), m.intersection)
+				}
+			case m.horizontal:
+				if !isWall(column - 1, row) && !isWall(column + 1, row) {
+					m.setCell(m.offset(column, row), m.intersection)
+				}
+			}
+		}
+	}
+}
+
+// Sets every in-bounds cell of the given rectangle to m.floor.
+func (m *Maze) clearRect(x, y, width, height int) {
+	if !m.validRect(x, y, width, height) {
+		return
+	}
+	x, y, width, height = m.clipRect(x, y, width, height)
+	for row := y; row < y + height; row++ {
+		for column := x; column < x + width; column++ {
+			m.setCell(m.offset(column, row), m.floor)
+		}
+	}
+}
+
+// Finds the shortest path that starts at one of the given units, passes only
+// through walled-in units that are not on the border, and ends at an open
+// unit for which isTarget() returns true.  The start and target units are
+// included in the result.  Returns nil if there is no such path.
+func (m *Maze) findPassage(sources []point, isTarget func(point) bool) []point {
+	unitWidth, unitHeight := m.unitDimensions()
+	previous := map[point]point{}
+	queue := []point{}
+	for _, p := range(sources) {
+		if _, seen := previous[p]; !seen {
+			previous[p] = p
+			queue = append(queue, p)
+		}
+	}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, d := range(directions) {
+			neighbor := point{x: current.x + d.x, y: current.y + d.y}
+			if neighbor.x < 0 || neighbor.y < 0 || neighbor.x >= unitWidth || neighbor.y >= unitHeight {
+				continue
+			}
+			if _, seen := previous[neighbor]; seen {
+				continue
+			}
+			previous[neighbor] = current
+			if m.unitIsOpen(neighbor.x, neighbor.y) {
+				if !isTarget(neighbor) {
+					continue
+				}
+				path := []point{neighbor}
+				for p := current; ; p = previous[p] {
+					path = append([]point{p}, path...)
+					if previous[p] == p {
+						break
+					}
+				}
+				return path
+			}
+			if !m.onBorder(neighbor) {
+				queue = append(queue, neighbor)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the open units that can be reached from the given unit, indexed by
+// unit offset.
+func (m *Maze) reachableUnits(start point) []bool {
+	unitWidth, unitHeight := m.unitDimensions()
+	reached := make([]bool, max(0, unitWidth * unitHeight))
+	if !m.unitIsOpen(start.x, start.y) {
+		return reached
+	}
+	reached[start.y * unitWidth + start.x] = true
+	stack := []point{start}
+	for len(stack) > 0 {
+		current := stack[len(stack) - 1]
+		stack = stack[:len(stack) - 1]
+		for _, d := range(directions) {
+			neighbor := point{x: current.x + d.x, y: current.y + d.y}
+			if neighbor.x < 0 || neighbor.y < 0 || neighbor.x >= unitWidth || neighbor.y >= unitHeight {
+				continue
+			}
+			if !reached[neighbor.y * unitWidth + neighbor.x] && m.unitIsOpen(neighbor.x, neighbor.y) {
+				reached[neighbor.y * unitWidth + neighbor.x] = true
+				stack = append(stack, neighbor)
+			}
+		}
+	}
+	return reached
+}
+
+// Connects every part of the maze to the entrance, and ensures that the
+// maze has both an entrance and an exit in its border.  See the top of this
+// file.  For a maze of a single thickness, this never changes anything.
+//
+// A large maze can have a great many pockets, so stopped() is checked
+// before each one.  If it returns true, this returns false, and calling it
+// again picks up where it left off.
+func (m *Maze) connectPockets(stopped func() bool) bool {
+	unitWidth, unitHeight := m.unitDimensions()
+	if unitWidth < 3 || unitHeight < 3 {
+		return true
+	}
+
+	// Openings can only be cut where a room lies just inside the border;
+	// anywhere else, the opening would run into the end of a wall.
+	leadsToRoom := func(p point) bool {
+		if p.x == 0 || p.x == unitWidth - 1 {
+			return p.y % 2 == 1
+		}
+		return p.x % 2 == 1
+	}
+	borderUnits := []point{}
+	for _, p := range(rectPerimeter(unitWidth, unitHeight)) {
+		if !m.isCorner(point{p.x, p.y}) && leadsToRoom(point{p.x, p.y}) {
+			borderUnits = append(borderUnits, point{p.x, p.y})
+		}
+	}
+	isInterior := func(p point) bool { return !m.onBorder(p) }
+	usableOpening := func(p point) bool {
+		if !m.onBorder(p) {
+			// A goal in the interior.
+			return m.isRoom(p)
+		}
+		if m.isCorner(p) || !leadsToRoom(p) || !m.unitIsOpen(p.x, p.y) {
+			return false
+		}
+		_, ok := m.roomInside(p)
+		return ok
+	}
+
+	// The entrance.  (Only the first of each is repaired; the others are
+	// open, like any other pocket, if they can be.)
+	entrance := m.openingUnit(first(m.entrances))
+	if !usableOpening(entrance) {
+		path := m.findPassage(borderUnits, isInterior)
+		if path == nil {
+			// Nothing inside the border is open at all (the
+			// earlier passes' rooms are too small for this
+			// one's grid to see), so start over with a single
+			// room just inside the border.
+			p := borderUnits[0]
+			room := point{min(max(p.x, 1), unitWidth - 2), min(max(p.y, 1), unitHeight - 2)}
+			path = []point{p, room}
+		}
+		m.openPath(path)
+		entrance = path[0]
+		m.entrances = replaceFirst(m.entrances, m.unitRect(entrance))
+	}
+
+	// The pockets.  These include any old openings in the border that
+	// the later passes cut off from the rest of the maze.
+	abandoned := make([]bool, unitWidth * unitHeight)
+	for {
+		if stopped() {
+			return false
+		}
+		reached := m.reachableUnits(entrance)
+		var pocket []point
+		for unitRow := 0; unitRow < unitHeight && pocket == nil; unitRow++ {
+			for unitColumn := 0; unitColumn < unitWidth; unitColumn++ {
+				offset := unitRow * unitWidth + unitColumn
+				if !reached[offset] && !abandoned[offset] && m.unitIsOpen(unitColumn, unitRow) {
+					pocketUnits := m.reachableUnits(point{unitColumn, unitRow})
+					pocket = []point{}
+					for i, inPocket := range(pocketUnits) {
+						if inPocket {
+							pocket = append(pocket, point{i % unitWidth, i / unitWidth})
+						}
+					}
+					break
+				}
+			}
+		}
+		if pocket == nil {
+			break
+		}
+		path := m.findPassage(pocket, func(p point) bool { return reached[p.y * unitWidth + p.x] })
+		if path == nil {
+			// There's no way through, so leave this one be.
+			for _, p := range(pocket) {
+				abandoned[p.y * unitWidth + p.x] = true
+			}
+			continue
+		}
+		m.openPath(path)
+
+		// Connecting this pocket may have given the ones we gave up
+		// on a way through, so they get another chance.
+		clear(abandoned)
+	}
+
+	// The exit.
+	exit := m.openingUnit(first(m.exits))
+	if !usableOpening(exit) || exit == entrance {
+		candidates := []point{}
+		for _, p := range(borderUnits) {
+			if p != entrance {
+				candidates = append(candidates, p)
+			}
+		}
+		reached := m.reachableUnits(entrance)
+		path := m.findPassage(candidates, func(p point) bool {
+			return isInterior(p) && reached[p.y * unitWidth + p.x]
+		})
+		if path == nil {
+			return true
+		}
+		m.openPath(path)
+		exit = path[0]
+		m.exits = replaceFirst(m.exits, m.unitRect(exit))
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+package main
+import (
+	"container/heap"
+	"slices"
+)
+
+// Route planning on the passage graph.
+//
+// Dijkstra() and AStar() find the shortest route between two nodes of a
+// Graph (see graph.go), weighing each edge by its length.  On the contracted
+// graph, whose nodes are only the junctions, dead ends, entrance and exit,
+// this is much faster than searching the maze cell by cell.  The route is
+// then expanded back into the cells that it passes through.
+//
+// For example, to solve a maze:
+//
+//   g := m.Graph(true)
+//   route, ok := g.AStar(g.Entrance, g.Exit)
+//
+// Both searches find routes of the same length; A* just visits fewer nodes
+// on the way, by estimating the rest of the distance from the coordinates of
+// the rooms.  For a maze with several entrances or exits, Solve() finds the
+// shortest route from any of the former to any of the latter.
+
+// A route through the passage graph.
+type Route struct {
+	// The nodes along the route, from start to finish, and the edges
+	// between them (so there is one edge fewer than there are nodes.)
+	Nodes []int
+	Edges []int
+
+	// The number of steps from room to room.
+	Length int
+
+	// The cells along the route, from the center of the first room to the
+	// center of the last.  Each cell is orthogonally adjacent to the next.
+	Cells []point
+}
+
+// Finds the shortest route from one node to another with Dijkstra's
+// algorithm.  The second return value is false if there is no route (or if
+// either node doesn't exist.)
+func (g Graph) Dijkstra(from, to int) (Route, bool) {
+	return g.search([]int{from}, []int{to}, func(node int) int { return 0 })
+}
+
+// Finds the shortest route from one node to another with A*.  The second
+// return value is false if there is no route (or if either node doesn't
+// exist.)
+func (g Graph) AStar(from, to int) (Route, bool) {
+	return g.AStarBetween([]int{from}, []int{to})
+}
+
+// Finds the shortest route from any of the given nodes to any of the others
+// with A*.
+func (g Graph) AStarBetween(from, to []int) (Route, bool) {
+	// Neighboring rooms are two units apart, and a corridor can't be
+	// shorter than the straight line between its ends.
+	return g.search(from, to, func(node int) int {
+		n, estimate := g.Nodes[node], -1
+		for _, goal := range(to) {
+			if goal >= 0 && goal < len(g.Nodes) {
+				goal := g.Nodes[goal]
+				distance := (max(n.X - goal.X, goal.X - n.X) + max(n.Y - goal.Y, goal.Y - n.Y)) / 2
+				if estimate < 0 || distance < estimate {
+					estimate = distance
+				}
+			}
+		}
+		return max(estimate, 0)
+	})
+}
+
+// Finds the shortest route from any entrance to any exit.
+func (g Graph) Solve() (Route, bool) {
+	return g.AStarBetween(g.Entrances, g.Exits)
+}
+
+// A node waiting to be visited, by the length of the shortest route through
+// it that the search has found so far (plus the estimate, for A*.)
+type routeQueueItem struct {
+	node, priority int
+}
+type routeQueue []routeQueueItem
+
+func (q routeQueue) Len() int { return len(q); }
+func (q routeQueue) Less(i, j int) bool { return q[i].priority < q[j].priority; }
+func (q routeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i]; }
+func (q *routeQueue) Push(x interface{}) { *q = append(*q, x.(routeQueueItem)); }
+func (q *routeQueue) Pop() interface{} {
+	item := (*q)[len(*q) - 1]
+	*q = (*q)[:len(*q) - 1]
+	return item
+}
+
+// Performs an A* search from any of the given nodes to the nearest of the
+// goals, with the given estimate of the remaining distance from each node to
+// the goals.  The estimate must never be too high.  Nodes that don't exist
+// are ignored.
+func (g Graph) search(from, goals []int, estimate func(node int) int) (Route, bool) {
+	distances := make([]int, len(g.Nodes))
+	via := make([]int, len(g.Nodes))
+	isGoal := make([]bool, len(g.Nodes))
+	for i := range(distances) {
+		distances[i], via[i] = -1, -1
+	}
+	for _, goal := range(goals) {
+		if goal >= 0 && goal < len(g.Nodes) {
+			isGoal[goal] = true
+		}
+	}
+
+	queue := &routeQueue{}
+	for _, start := range(from) {
+		if start >= 0 && start < len(g.Nodes) && distances[start] < 0 {
+			distances[start] = 0
+			heap.Push(queue, routeQueueItem{start, estimate(start)})
+		}
+	}
+	to := -1
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(routeQueueItem)
+		current := item.node
+		if isGoal[current] {
+			to = current
+			break
+		}
+		if item.priority > distances[current] + estimate(current) {
+			// We've already found a shorter way here.
+			continue
+		}
+		for _, edge := range(g.adjacency[current]) {
+			next := g.otherEnd(edge, current)
+			distance := distances[current] + g.Edges[edge].Length
+			if distances[next] < 0 || distance < distances[next] {
+				distances[next], via[next] = distance, edge
+				heap.Push(queue, routeQueueItem{next, distance + estimate(next)})
+			}
+		}
+	}
+	if to < 0 {
+		return Route{}, false
+	}
+
+	route := Route{Nodes: []int{to}, Edges: []int{}, Length: distances[to]}
+	for node := to; via[node] >= 0; {
+		edge := via[node]
+		node = g.otherEnd(edge, node)
+		route.Edges = append(route.Edges, edge)
+		route.Nodes = append(route.Nodes, node)
+	}
+	slices.Reverse(route.Nodes)
+	slices.Reverse(route.Edges)
+	route.Cells = g.routeCells(route)
+	return route, true
+}
+
+// Returns the cells along the given route, by joining the cells of its
+// edges.
+func (g Graph) routeCells(route Route) []point {
+	cells := []point{g.Nodes[route.Nodes[0]].cell}
+	for i, edge := range(route.Edges) {
+		corridor := g.Edges[edge].Cells
+		if g.Edges[edge].From != route.Nodes[i] {
+			corridor = slices.Clone(corridor)
+			slices.Reverse(corridor)
+		}
+		cells = append(cells, corridor[1:]...)
+	}
+	return cells
+}
@@ -0,0 +1,444 @@
+package main
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"github.com/akamensky/argparse"
+)
+
+// An HTTP service for generating mazes on demand.
+//
+//   GET /maze?w=&h=&thickness=&min=&max=&seed=&format=&scale=
+//   GET /solve?...   (the same, with the solution drawn in or listed)
+//   GET /stats?...   (the maze's statistics; see stats.go)
+//   GET /daily?...   (the maze of the day, whose seed is the UTC date)
+//
+// Every parameter is optional; the defaults come from the command line of
+// "maze serve", which takes the same arguments as "maze".  The thickness is
+// a comma-separated list, as for --thickness.  The formats are txt, json,
+// svg and png (and only txt and json for /stats.)  A maze in JSON is a
+// MazeDocument; see json.go.  A PNG may be no larger than maxImagePixels, so
+// a large maze needs a smaller scale.
+//
+// A maze with a seed is always the same maze, so its responses can be cached
+// forever: they carry an ETag derived from the parameters (and the server's
+// theme), and a request
+// that already has it gets 304 Not Modified without generating anything.
+// The server also keeps the mazes it generated most recently.  Without a
+// seed, every request gets a new maze, and the X-Maze-Seed header says how to
+// get it again.
+
+// The parameters of a request.
+type mazeRequest struct {
+	width, height int
+	thicknessValues []int
+	minWallLength, maxWallLength int
+	seed string
+	format string
+	scale int
+
+	// The settings of the server's template that the request can't
+	// change but that still change the maze; see templateKey().
+	template string
+}
+
+// Returns a string that identifies the maze the request asks for, regardless
+// of the format.
+func (r mazeRequest) key() string {
+	return fmt.Sprintf("w=%v&h=%v&thickness=%v&min=%v&max=%v&seed=%v&template=%q", r.width, r.height, r.thicknessValues, r.minWallLength, r.maxWallLength, r.seed, r.template)
+}
+
+// Returns a string that identifies the display runes, box-drawing style and
+// wall limit of a server's template, so that a server started with another
+// theme doesn't answer with the ETags of the old one.
+func (m *Maze) templateKey() string {
+	key := string([]rune{m.floor, m.fill, m.intersection, m.horizontal, m.vertical})
+	if m.boxStyle != nil {
+		key += string(m.boxStyle[:])
+	}
+	return fmt.Sprintf("%v/%v", key, m.maxWalls)
+}
+
+// Returns an ETag for the response to the request at the given path.
+func (r mazeRequest) etag(path string) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v?%v&format=%v&scale=%v", path, r.key(), r.format, r.scale)
+	return fmt.Sprintf("\"%016x\"", h.Sum64())
+}
+
+// A request that the server refuses, with the status to refuse it with.
+type requestError struct {
+	status int
+	message string
+}
+
+func (e requestError) Error() string { return e.message; }
+
+func badRequest(format string, a ...interface{}) error {
+	return requestError{status: http.StatusBadRequest, message: fmt.Sprintf(format, a...)}
+}
+
+// The most recently used mazes, by request key.
+type mazeCache struct {
+	mutex sync.Mutex
+	capacity int
+	order *list.List
+	entries map[string]*list.Element
+}
+
+type mazeCacheEntry struct {
+	key string
+	m *Maze
+}
+
+func newMazeCache(capacity int) *mazeCache {
+	return &mazeCache{capacity: capacity, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *mazeCache) get(key string) *Maze {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(mazeCacheEntry).m
+	}
+	return nil
+}
+
+func (c *mazeCache) put(key string, m *Maze) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.capacity <= 0 {
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.order.PushFront(mazeCacheEntry{key: key, m: m})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(mazeCacheEntry).key)
+	}
+}
+
+type mazeServer struct {
+	// The maze that every generated maze is copied from, and the default
+	// request.
+	template Maze
+	defaults mazeRequest
+
+	// The limits on each request.
+	timeout time.Duration
+	maxCells int
+
+	cache *mazeCache
+
+	// Returns the current time, for /daily.
+	now func() time.Time
+}
+
+func serveFormatNames() []string {
+	return []string{"txt", "json", "svg", "png"}
+}
+
+// Reads the request's parameters, filling in the defaults.  The format must
+// be one of the given ones.
+func (s *mazeServer) parseRequest(r *http.Request, formats []string) (mazeRequest, error) {
+	q := r.URL.Query()
+	request := s.defaults
+	request.thicknessValues = append([]int{}, s.defaults.thicknessValues...)
+	request.template = s.template.templateKey()
+	ints := []struct{name string; target *int}{
+		{"w", &request.width},
+		{"h", &request.height},
+		{"min", &request.minWallLength},
+		{"max", &request.maxWallLength},
+		{"scale", &request.scale},
+	}
+	for _, p := range(ints) {
+		if value := q.Get(p.name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return request, badRequest("\"%v\" is not a valid %v", value, p.name)
+			}
+			*p.target = n
+		}
+	}
+	if value := q.Get("thickness"); value != "" {
+		thicknessValues, err := parseThicknessValues([]string{value})
+		if err != nil {
+			return request, badRequest("\"%v\" is not a valid thickness: %v", value, err)
+		}
+		request.thicknessValues = thicknessValues
+	}
+	if q.Has("seed") {
+		request.seed = q.Get("seed")
+	}
+	if value := q.Get("format"); value != "" {
+		request.format = value
+	}
+
+	switch {
+	case request.width < 0 || request.height < 0:
+		return request, badRequest("the width and height can't be negative")
+	case request.height > 0 && request.width > s.maxCells / request.height:
+		return request, requestError{
+			status: http.StatusRequestEntityTooLarge,
+			message: fmt.Sprintf("a maze of %vx%v is larger than the limit of %v cells", request.width, request.height, s.maxCells),
+		}
+	case !slices.Contains(formats, request.format):
+		return request, badRequest("unknown format \"%v\"; expected one of %v", request.format, strings.Join(formats, ", "))
+	case request.scale < 1 || request.scale > 64:
+		return request, badRequest("the scale must be from 1 to 64")
+	case request.format == "png" && !imageFits(request.width, request.height, request.scale):
+		return request, requestError{
+			status: http.StatusRequestEntityTooLarge,
+			message: fmt.Sprintf("a %vx%v maze at a scale of %v is larger than the limit of %v pixels", request.width, request.height, request.scale, maxImagePixels),
+		}
+	}
+	return request, nil
+}
+
+// Returns the maze that the request asks for, from the cache if possible.
+func (s *mazeServer) maze(ctx context.Context, request mazeRequest) (*Maze, error) {
+	if request.seed != "" {
+		if m := s.cache.get(request.key()); m != nil {
+			return m, nil
+		}
+	}
+
+	m := s.template
+	m.setSize(request.width, request.height)
+	m.Clear()
+	m.minWallLength, m.maxWallLength = request.minWallLength, request.maxWallLength
+	m.SetSeed(request.seed)
+	if s.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.timeout)
+		defer cancel()
+	}
+	err := m.GenerateNestedContext(ctx, request.thicknessValues)
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return nil, requestError{status: http.StatusServiceUnavailable, message: fmt.Sprintf("gave up after %v", s.timeout)}
+	case err != nil && !errors.Is(err, ErrIncomplete):
+		return nil, err
+	}
+	if request.seed != "" {
+		s.cache.put(request.key(), &m)
+	}
+	return &m, nil
+}
+
+// The rune that /solve draws the solution with in text.
+const solutionRune = '*'
+
+// Writes the maze in the requested format.  For /solve, the solution is
+// drawn in (or listed, for JSON.)
+func (s *mazeServer) writeMaze(w http.ResponseWriter, m *Maze, request mazeRequest, solve bool) error {
+	switch request.format {
+	case "png":
+		w.Header().Set("Content-Type", "image/png")
+		return m.WritePNG(w, ImageOptions{Scale: request.scale, Solution: solve})
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		return m.WriteSVG(w, ImageOptions{Scale: request.scale, Solution: solve})
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(m.Document(solve, false))
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if solve {
+		solved := NewMazeOverExisting(*m)
+		for _, p := range(m.solutionPath(m.cellDistances())) {
+			solved.setCell(solved.offset(p.x, p.y), solutionRune)
+		}
+		m = &solved
+	}
+	return m.WriteText(w)
+}
+
+// Returns true if the request already has the response with the given ETag.
+func ifNoneMatch(r *http.Request, etag string) bool {
+	for _, value := range(strings.Split(r.Header.Get("If-None-Match"), ",")) {
+		if value = strings.TrimSpace(value); value == etag || value == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a handler for one of the endpoints, which supports the given
+// formats.  It parses the request, lets adjust() change it (for /daily), sets
+// the caching headers, generates the maze and hands it to write().
+func (s *mazeServer) handler(formats []string, adjust func(*mazeRequest, http.Header), write func(http.ResponseWriter, *Maze, mazeRequest) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		request, err := s.parseRequest(r, formats)
+		if err == nil && adjust != nil {
+			adjust(&request, w.Header())
+		}
+		if err == nil && request.seed != "" {
+			etag := request.etag(r.URL.Path)
+			w.Header().Set("ETag", etag)
+			if w.Header().Get("Cache-Control") == "" {
+				w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+			}
+			if ifNoneMatch(r, etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		} else {
+			w.Header().Set("Cache-Control", "no-store")
+		}
+
+		var m *Maze
+		if err == nil {
+			m, err = s.maze(r.Context(), request)
+		}
+		if err != nil {
+			status := http.StatusInternalServerError
+			var requestErr requestError
+			if errors.As(err, &requestErr) {
+				status = requestErr.status
+			}
+			w.Header().Del("ETag")
+			w.Header().Set("Cache-Control", "no-store")
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("X-Maze-Seed", m.Seed())
+		if err := write(w, m, request); err != nil {
+			m.log().Warn("could not write the response", "path", r.URL.Path, "error", err.Error())
+		}
+	}
+}
+
+// Returns the handler for all of the endpoints.
+func (s *mazeServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/maze", s.handler(serveFormatNames(), nil, func(w http.ResponseWriter, m *Maze, request mazeRequest) error {
+		return s.writeMaze(w, m, request, false)
+	}))
+	mux.Handle("/solve", s.handler(serveFormatNames(), nil, func(w http.ResponseWriter, m *Maze, request mazeRequest) error {
+		return s.writeMaze(w, m, request, true)
+	}))
+	mux.Handle("/stats", s.handler([]string{"txt", "json"}, nil, func(w http.ResponseWriter, m *Maze, request mazeRequest) error {
+		if request.format == "txt" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			return m.Stats().WriteText(w)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return m.Stats().WriteJSON(w)
+	}))
+
+	// The maze of the day changes at midnight UTC, so that's when the
+	// cached copies expire.
+	mux.Handle("/daily", s.handler(serveFormatNames(), func(request *mazeRequest, header http.Header) {
+		now := s.now().UTC()
+		request.seed = "daily/" + now.Format(time.DateOnly)
+		midnight := time.Date(now.Year(), now.Month(), now.Day() + 1, 0, 0, 0, 0, time.UTC)
+		header.Set("Cache-Control", fmt.Sprintf("public, max-age=%v", int(midnight.Sub(now).Seconds())))
+	}, func(w http.ResponseWriter, m *Maze, request mazeRequest) error {
+		return s.writeMaze(w, m, request, false)
+	}))
+	return mux
+}
+
+func serveMain(args []string) {
+	config, err := LoadConfig(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read the configuration file: %v.\n", err)
+		os.Exit(1)
+	}
+	parser := argparse.NewParser("maze serve", "Serves mazes over HTTP: GET /maze, /solve, /stats and /daily, with the parameters w, h, thickness, min, max, seed, format (txt, json, svg or png) and scale.  The other arguments are the same as for \"maze\" and supply the defaults; --timeout applies to each request, and --difficulty, --require, --entrance, --exit, --room and --solid can't be used.")
+	arguments := addMazeArguments(parser, config)
+	var address *string = parser.String("", "listen", &argparse.Options{
+		Required: false,
+		Help: "The address to listen on",
+		Default: "localhost:8080",
+	})
+	var maxCells *int = parser.Int("", "max-cells", &argparse.Options{
+		Required: false,
+		Help: "The largest maze (width times height) that a request may ask for",
+		Default: 1000000,
+	})
+	var cacheSize *int = parser.Int("", "cache", &argparse.Options{
+		Required: false,
+		Help: "The number of recently generated mazes to keep",
+		Default: 64,
+	})
+
+	err = parser.Parse(args)
+	if err != nil {
+		fmt.Print(parser.Usage(err))
+		return
+	}
+	m, ok := arguments.newMaze(parser)
+	if !ok {
+		return
+	}
+	// Each request resizes a copy of the template, which would leave these
+	// where they were; and there's no telling how long --difficulty would
+	// keep a request waiting.
+	for _, option := range([]struct{names string; used bool}{
+		{"--difficulty and --require", len(arguments.constraints) > 0},
+		{"--entrance and --exit", len(*arguments.entrances) > 0 || len(*arguments.exits) > 0},
+		{"--room and --solid", len(*arguments.rooms) > 0 || len(*arguments.solids) > 0},
+	}) {
+		if option.used {
+			fmt.Fprintf(os.Stderr, "%v can't be used with serve.\n", option.names)
+			fmt.Print(parser.Usage(nil))
+			return
+		}
+	}
+	timeout := arguments.timeoutValue
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	s := &mazeServer{
+		template: m,
+		defaults: mazeRequest{
+			width: m.width,
+			height: m.height,
+			thicknessValues: arguments.thicknessValues,
+			minWallLength: m.minWallLength,
+			maxWallLength: m.maxWallLength,
+			seed: *arguments.seed,
+			format: "txt",
+			scale: 8,
+		},
+		timeout: timeout,
+		maxCells: *maxCells,
+		cache: newMazeCache(*cacheSize),
+		now: time.Now,
+	}
+	s.template.eventHandler = nil
+
+	m.log().Info("listening", "address", *address)
+	server := &http.Server{
+		Addr: *address,
+		Handler: s.routes(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not serve: %v.\n", err)
+		os.Exit(1)
+	}
+}
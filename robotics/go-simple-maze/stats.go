@@ -0,0 +1,146 @@
+package main
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Quality metrics for a generated maze.  All lengths are measured in rooms
+// (see passages.go), not in characters.
+type Stats struct {
+	Width int                    `json:"width"`
+	Height int                   `json:"height"`
+	Thickness int                `json:"thickness"`
+	Rooms int                    `json:"rooms"`
+
+	// The number of rooms with exactly one doorway.
+	DeadEnds int                 `json:"deadEnds"`
+
+	// The number of rooms with each number of doorways (0 through 4.)
+	// Rooms with three or more doorways are junctions.
+	RoomsByDegree [5]int         `json:"roomsByDegree"`
+	Junctions int                `json:"junctions"`
+
+	// Maps corridor lengths to the number of corridors with that length.
+	// A corridor is a chain of steps between two rooms that are not simple
+	// pass-throughs (i.e., rooms with a degree other than 2.)
+	CorridorLengths map[int]int  `json:"corridorLengths"`
+
+	// The fraction of rooms that are simple pass-throughs, from 0 to 1.
+	// High values make for long, winding, "river-like" passages; low
+	// values make for many short branches and dead ends.
+	RiverFactor float64          `json:"riverFactor"`
+
+	// The number of rooms on the shortest path from the entrance to the
+	// exit (0 if there is none), and that number as a fraction of all of
+	// the rooms.
+	SolutionLength int           `json:"solutionLength"`
+	SolutionShare float64        `json:"solutionShare"`
+
+	// The number of times the solution changes direction.
+	SolutionTurns int            `json:"solutionTurns"`
+
+	// The greatest number of steps one can take away from the solution
+	// before hitting a dead end.
+	LongestDeadEnd int           `json:"longestDeadEnd"`
+}
+
+// Computes quality metrics for the maze.
+func (m *Maze) Stats() Stats {
+	stats := Stats{
+		Width: m.width,
+		Height: m.height,
+		Thickness: m.thickness,
+		CorridorLengths: map[int]int{},
+	}
+
+	rooms := m.rooms()
+	stats.Rooms = len(rooms)
+	for _, room := range(rooms) {
+		degree := m.roomDegree(room)
+		stats.RoomsByDegree[degree]++
+		switch {
+		case degree == 1:
+			stats.DeadEnds++
+		case degree >= 3:
+			stats.Junctions++
+		}
+	}
+	if stats.Rooms > 0 {
+		stats.RiverFactor = float64(stats.RoomsByDegree[2]) / float64(stats.Rooms)
+	}
+
+	for _, corridor := range(m.corridors()) {
+		stats.CorridorLengths[len(corridor) - 1]++
+	}
+
+	solution := m.solutionRooms()
+	stats.SolutionLength = len(solution)
+	if stats.Rooms > 0 {
+		stats.SolutionShare = float64(len(solution)) / float64(stats.Rooms)
+	}
+	if len(solution) > 0 {
+		for _, distance := range(m.roomDistances(solution...)) {
+			stats.LongestDeadEnd = max(stats.LongestDeadEnd, distance)
+		}
+
+		units := m.solutionUnits()
+		for i := 2; i < len(units); i++ {
+			dx1, dy1 := units[i - 1].x - units[i - 2].x, units[i - 1].y - units[i - 2].y
+			dx2, dy2 := units[i].x - units[i - 1].x, units[i].y - units[i - 1].y
+			if dx1 != dx2 || dy1 != dy2 {
+				stats.SolutionTurns++
+			}
+		}
+	}
+
+	return stats
+}
+
+// Writes the statistics in a human-readable form.
+func (s Stats) WriteText(w io.Writer) error {
+	lengths := []int{}
+	for length := range(s.CorridorLengths) {
+		lengths = append(lengths, length)
+	}
+	sort.Ints(lengths)
+	corridors := ""
+	for i, length := range(lengths) {
+		if i > 0 {
+			corridors += ", "
+		}
+		corridors += fmt.Sprintf("%v×%v", s.CorridorLengths[length], length)
+	}
+	if corridors == "" {
+		corridors = "none"
+	}
+
+	_, err := fmt.Fprintf(w,
+		"Size:               %vx%v characters (thickness %v), %v rooms\n" +
+		"Dead ends:          %v\n" +
+		"Junctions:          %v (%v three-way, %v four-way)\n" +
+		"Rooms by degree:    %v\n" +
+		"Corridors:          %v (count×length)\n" +
+		"River factor:       %.3f\n" +
+		"Solution length:    %v rooms (%.1f%% of the maze)\n" +
+		"Solution turns:     %v\n" +
+		"Longest dead end:   %v\n",
+		s.Width, s.Height, s.Thickness, s.Rooms,
+		s.DeadEnds,
+		s.Junctions, s.RoomsByDegree[3], s.RoomsByDegree[4],
+		s.RoomsByDegree,
+		corridors,
+		s.RiverFactor,
+		s.SolutionLength, 100 * s.SolutionShare,
+		s.SolutionTurns,
+		s.LongestDeadEnd)
+	return err
+}
+
+// Writes the statistics as a JSON object.
+func (s Stats) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
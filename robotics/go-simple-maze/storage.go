@@ -0,0 +1,167 @@
+package main
+import (
+	"maps"
+)
+
+// Cell storage.
+//
+// By default, the cells of a maze are a []rune, at four bytes apiece.  That's
+// fine for anything that fits in a terminal, but a maze of 100,000 by 100,000
+// cells would need 40 gigabytes.
+//
+// A packed maze (see SetPacked()) stores each cell as an index into a palette
+// of the runes that it actually uses.  A maze of thickness 1 only ever uses
+// four (the floor, intersection, horizontal and vertical runes), so it takes
+// two bits per cell; thicker mazes add the fill rune and take four.  Any
+// other runes that are Set() widen the cells further, to 8, 16 and finally 32
+// bits, as needed, so there's no limit on how many different runes a packed
+// maze can hold.
+//
+// Everything else goes through cell() and setCell(), so the rest of the code
+// neither knows nor cares which kind of storage a maze has.  Packed cells are
+// a little slower to read and write.
+
+type packedCells struct {
+	// The number of bits per cell: 2, 4, 8, 16 or 32.
+	bits int
+	palette []rune
+
+	// The index of each rune in the palette, once the palette is too long
+	// to search.
+	indexes map[rune]int
+
+	words []uint64
+}
+
+// The length of the longest palette that paletteIndex() searches instead of
+// using p.indexes.
+const maxSearchedPalette = 16
+
+func newPackedCells(count int, floor rune) *packedCells {
+	p := &packedCells{bits: 2, palette: []rune{floor}}
+	p.words = make([]uint64, (count * p.bits + 63) / 64)
+	return p
+}
+
+func (p *packedCells) get(offset int) rune {
+	bit := offset * p.bits
+	return p.palette[(p.words[bit / 64] >> (bit % 64)) & (1 << p.bits - 1)]
+}
+
+func (p *packedCells) set(offset int, c rune) {
+	index := p.paletteIndex(c)
+	bit := offset * p.bits
+	word := &p.words[bit / 64]
+	*word = *word &^ ((1 << p.bits - 1) << (bit % 64)) | uint64(index) << (bit % 64)
+}
+
+// Returns the palette index of the given rune, adding it to the palette (and
+// widening the cells, if need be) if it isn't there yet.
+func (p *packedCells) paletteIndex(c rune) int {
+	if p.indexes != nil {
+		if i, ok := p.indexes[c]; ok {
+			return i
+		}
+	} else {
+		for i, other := range(p.palette) {
+			if other == c {
+				return i
+			}
+		}
+	}
+	if p.bits < 32 && len(p.palette) == 1 << p.bits {
+		p.widen()
+	}
+	p.palette = append(p.palette, c)
+	if p.indexes != nil {
+		p.indexes[c] = len(p.palette) - 1
+	} else if len(p.palette) > maxSearchedPalette {
+		p.indexes = map[rune]int{}
+		for i, other := range(p.palette) {
+			p.indexes[other] = i
+		}
+	}
+	return len(p.palette) - 1
+}
+
+// Doubles the number of bits per cell.
+func (p *packedCells) widen() {
+	count := len(p.words) * 64 / p.bits
+	wider := &packedCells{bits: p.bits * 2, palette: p.palette, indexes: p.indexes}
+	wider.words = make([]uint64, (count * wider.bits + 63) / 64)
+	for offset := 0; offset < count; offset++ {
+		bit := offset * p.bits
+		index := (p.words[bit / 64] >> (bit % 64)) & (1 << p.bits - 1)
+		bit = offset * wider.bits
+		wider.words[bit / 64] |= index << (bit % 64)
+	}
+	*p = *wider
+}
+
+func (p *packedCells) clone() *packedCells {
+	return &packedCells{
+		bits: p.bits,
+		palette: append([]rune{}, p.palette...),
+		indexes: maps.Clone(p.indexes),
+		words: append([]uint64{}, p.words...),
+	}
+}
+
+// Like NewMaze(), but the cells are packed from the start, so that the full
+// []rune is never allocated.
+func NewPackedMaze(width, height int) Maze {
+	m := NewMaze(0, 0)
+	m.packed = newPackedCells(0, m.floor)
+	m.setSize(width, height)
+	m.Clear()
+	return m
+}
+
+// Switches the maze between packed cells and a []rune, keeping its
+// contents.
+func (m *Maze) SetPacked(packed bool) {
+	if packed == m.Packed() {
+		return
+	}
+	count := m.cellCount()
+	if packed {
+		p := newPackedCells(count, m.floor)
+		for offset := 0; offset < count && offset < len(m.cells); offset++ {
+			p.set(offset, m.cells[offset])
+		}
+		m.packed, m.cells = p, nil
+		return
+	}
+	m.cells = make([]rune, count)
+	for offset := range(m.cells) {
+		m.cells[offset] = m.packed.get(offset)
+	}
+	m.packed = nil
+}
+
+// Returns true if the maze's cells are packed (see SetPacked().)
+func (m *Maze) Packed() bool {
+	return m.packed != nil
+}
+
+// Returns the number of cells in the maze.
+func (m *Maze) cellCount() int {
+	return m.width * m.height
+}
+
+// Returns the rune at the given offset (see offset().)
+func (m *Maze) cell(offset int) rune {
+	if m.packed != nil {
+		return m.packed.get(offset)
+	}
+	return m.cells[offset]
+}
+
+// Sets the rune at the given offset.
+func (m *Maze) setCell(offset int, c rune) {
+	if m.packed != nil {
+		m.packed.set(offset, c)
+		return
+	}
+	m.cells[offset] = c
+}
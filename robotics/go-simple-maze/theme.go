@@ -0,0 +1,510 @@
+package main
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Themes and the configuration file.
+//
+// A theme is a named set of display runes (the ones that -f, -F, -i, -x and
+// -y set individually), optionally with a box-drawing style.  The individual
+// flags still win over the theme, so "--theme shades -f ' '" is the shades
+// theme with a blank floor.
+//
+// The configuration file can define more themes and can change the default
+// value of most command-line arguments.  It may be written in JSON:
+//
+//   {
+//     "defaults": {"theme": "mine", "width": 61, "height": 31, "thickness": [3, 1]},
+//     "themes": {"mine": {"fill": "█", "vertical": "▒", "box-drawing": ""}}
+//   }
+//
+// or in TOML, if the file name ends in ".toml":
+//
+//   [defaults]
+//   theme = "mine"
+//   thickness = [3, 1]
+//
+//   [themes.mine]
+//   fill = "█"
+//
+// The keys in "defaults" are the long names of the command-line arguments.
+// Any rune that a theme leaves out is taken from the ascii theme.
+
+type Theme struct {
+	Floor string
+	Fill string
+	Intersection string
+	Horizontal string
+	Vertical string
+
+	// The name of a box-drawing style (see boxStyles), or "" for none.
+	BoxDrawing string
+}
+
+var themes = map[string]Theme{
+	"ascii": {Floor: " ", Fill: ".", Intersection: "+", Horizontal: "-", Vertical: "|"},
+	"blocks": {Floor: " ", Fill: "█", Intersection: "█", Horizontal: "█", Vertical: "█"},
+	"shades": {Floor: "░", Fill: "█", Intersection: "▒", Horizontal: "▒", Vertical: "▒"},
+
+	// The fill is a no-break space, which looks as empty as the floor
+	// but can still be told apart from it.
+	"box-light": {Floor: " ", Fill: "\u00A0", Intersection: "+", Horizontal: "-", Vertical: "|", BoxDrawing: "light"},
+	"box-heavy": {Floor: " ", Fill: "\u00A0", Intersection: "+", Horizontal: "-", Vertical: "|", BoxDrawing: "heavy"},
+
+	// Every rune here is two columns wide (even the ideographic space used
+	// for the floor), so the maze stays square.
+	"emoji": {Floor: "　", Fill: "🟫", Intersection: "🧱", Horizontal: "🧱", Vertical: "🧱"},
+}
+
+// Returns the names of the themes, including any from the configuration
+// file, in alphabetical order.
+func themeNames(config *Config) []string {
+	names := []string{}
+	for name := range(themes) {
+		names = append(names, name)
+	}
+	for name := range(config.Themes) {
+		if _, ok := themes[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Finds the named theme.  Themes in the configuration file take precedence
+// over the built-in ones.
+func findTheme(config *Config, name string) (Theme, error) {
+	if theme, ok := config.Themes[name]; ok {
+		return theme, nil
+	}
+	if theme, ok := themes[name]; ok {
+		return theme, nil
+	}
+	return Theme{}, fmt.Errorf("unknown theme \"%v\"; expected one of %v", name, strings.Join(themeNames(config), ", "))
+}
+
+// Sets the maze's display runes and box-drawing style from the theme.  Each
+// "rune" may be any single glyph, including emoji and CJK characters; see
+// width.go.
+func (m *Maze) SetTheme(theme Theme) error {
+	// Work on a copy, so that the maze is left alone if the theme is
+	// invalid.
+	other := *m
+	runes := []struct{name, value string; target *rune}{
+		{"floor", theme.Floor, &other.floor},
+		{"fill", theme.Fill, &other.fill},
+		{"intersection", theme.Intersection, &other.intersection},
+		{"horizontal", theme.Horizontal, &other.horizontal},
+		{"vertical", theme.Vertical, &other.vertical},
+	}
+	for _, r := range(runes) {
+		if !isSingleGlyph(r.value) {
+			return fmt.Errorf("the %v rune, \"%v\", must be exactly one character", r.name, r.value)
+		}
+		*r.target = other.glyphRune(r.value)
+	}
+	for _, r := range(runes[1:]) {
+		// Everything else finds its way around the maze by looking
+		// for the floor.
+		if *r.target == other.floor {
+			return fmt.Errorf("the %v rune, \"%v\", can't be the same as the floor", r.name, r.value)
+		}
+	}
+	if err := other.checkGlyphWidths(); err != nil {
+		return err
+	}
+	if err := other.SetBoxStyle(theme.BoxDrawing); err != nil {
+		return err
+	}
+	*m = other
+	return nil
+}
+
+// The contents of a configuration file.
+type Config struct {
+	// Default values for the command-line arguments, keyed by their long
+	// names.  Every value has been converted to the string that would
+	// have been given on the command line.
+	Defaults map[string]string
+
+	Themes map[string]Theme
+}
+
+// The arguments that the configuration file may provide defaults for, and
+// the kind of value that each one takes.
+var configDefaultKinds = map[string]string{
+	"width": "int",
+	"height": "int",
+	"thickness": "list",
+	"theme": "string",
+	"floor": "string",
+	"fill": "string",
+	"intersection": "string",
+	"horizontal": "string",
+	"vertical": "string",
+	"box-drawing": "string",
+	"min": "int",
+	"max": "int",
+	"seed": "string",
+	"max-walls": "int",
+	"difficulty": "string",
+	"attempts": "int",
+	"color": "string",
+	"log-format": "string",
+	"timeout": "string",
+}
+
+// Returns the configuration file's default for the named argument, or the
+// fallback if it doesn't have one.
+func (c *Config) stringDefault(name, fallback string) string {
+	if value, ok := c.Defaults[name]; ok {
+		return value
+	}
+	return fallback
+}
+
+func (c *Config) intDefault(name string, fallback int) int {
+	if value, ok := c.Defaults[name]; ok {
+		// LoadConfig() has already checked that this parses.
+		n, _ := strconv.Atoi(value)
+		return n
+	}
+	return fallback
+}
+
+// Returns the path of the configuration file to use: the argument of
+// --config if there is one, or else the first of config.toml and
+// config.json that exists in the user's configuration directory (such as
+// ~/.config/simple-maze.)  Returns "" if there isn't one.
+//
+// This has to look at the arguments before they are parsed, since the
+// configuration file supplies the defaults for the parser.
+func findConfigFile(args []string) string {
+	for i, arg := range(args) {
+		switch {
+		case arg == "--config" && i + 1 < len(args):
+			return args[i + 1]
+		case strings.HasPrefix(arg, "--config="):
+			return strings.TrimPrefix(arg, "--config=")
+		}
+	}
+	directory, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	for _, name := range([]string{"config.toml", "config.json"}) {
+		path := filepath.Join(directory, "simple-maze", name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// Reads the configuration file for the given command line (see
+// findConfigFile().)  Without one, this returns an empty configuration.
+func LoadConfig(args []string) (*Config, error) {
+	config := &Config{Defaults: map[string]string{}, Themes: map[string]Theme{}}
+	path := findConfigFile(args)
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// TOML is converted into the same generic form that encoding/json
+	// produces, so that both formats go through the same checks.
+	var document map[string]interface{}
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		document, err = parseTOML(string(data))
+	} else {
+		err = json.Unmarshal(data, &document)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if err := config.load(document); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return config, nil
+}
+
+// Fills in the configuration from a decoded JSON or TOML document.
+func (c *Config) load(document map[string]interface{}) error {
+	for key, value := range(document) {
+		if key != "defaults" && key != "themes" {
+			return fmt.Errorf("unknown section \"%v\"; expected \"defaults\" or \"themes\"", key)
+		}
+		if _, ok := value.(map[string]interface{}); !ok {
+			return fmt.Errorf("\"%v\" must be a table of keys and values", key)
+		}
+	}
+
+	defaults, _ := document["defaults"].(map[string]interface{})
+	for name, value := range(defaults) {
+		kind, ok := configDefaultKinds[name]
+		if !ok {
+			return fmt.Errorf("defaults: unknown argument \"%v\"", name)
+		}
+		s, err := configValueString(value, kind)
+		if err != nil {
+			return fmt.Errorf("defaults: %v: %v", name, err)
+		}
+		c.Defaults[name] = s
+	}
+
+	ascii := themes["ascii"]
+	themeTables, _ := document["themes"].(map[string]interface{})
+	for name, table := range(themeTables) {
+		fields, ok := table.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("themes: %v: expected a table of runes", name)
+		}
+		theme := Theme{}
+		for key, value := range(fields) {
+			var target *string
+			switch key {
+			case "floor":
+				target = &theme.Floor
+			case "fill":
+				target = &theme.Fill
+			case "intersection":
+				target = &theme.Intersection
+			case "horizontal":
+				target = &theme.Horizontal
+			case "vertical":
+				target = &theme.Vertical
+			case "box-drawing":
+				target = &theme.BoxDrawing
+			default:
+				return fmt.Errorf("themes: %v: unknown key \"%v\"", name, key)
+			}
+			s, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("themes: %v: %v must be a string", name, key)
+			}
+			*target = s
+		}
+		for _, f := range([]struct{value *string; fallback string}{
+			{&theme.Floor, ascii.Floor},
+			{&theme.Fill, ascii.Fill},
+			{&theme.Intersection, ascii.Intersection},
+			{&theme.Horizontal, ascii.Horizontal},
+			{&theme.Vertical, ascii.Vertical},
+		}) {
+			if *f.value == "" {
+				*f.value = f.fallback
+			}
+		}
+		m := NewMaze(0, 0)
+		if err := m.SetTheme(theme); err != nil {
+			return fmt.Errorf("themes: %v: %v", name, err)
+		}
+		c.Themes[name] = theme
+	}
+	return nil
+}
+
+// Converts a value from the configuration file into a command-line string,
+// checking that it is of the given kind ("int", "string" or "list", which
+// accepts an array of integers as well as a comma-separated string.)
+func configValueString(value interface{}, kind string) (string, error) {
+	switch v := value.(type) {
+	case string:
+		if kind == "int" {
+			if _, err := strconv.Atoi(v); err != nil {
+				return "", errors.New("expected an integer")
+			}
+		}
+		return v, nil
+	case float64:
+		if kind != "string" && v == float64(int(v)) {
+			return strconv.Itoa(int(v)), nil
+		}
+	case int64:
+		if kind != "string" {
+			return strconv.FormatInt(v, 10), nil
+		}
+	case []interface{}:
+		if kind == "list" {
+			items := []string{}
+			for _, item := range(v) {
+				s, err := configValueString(item, "int")
+				if err != nil {
+					return "", err
+				}
+				items = append(items, s)
+			}
+			return strings.Join(items, ","), nil
+		}
+	}
+	switch kind {
+	case "int":
+		return "", errors.New("expected an integer")
+	case "list":
+		return "", errors.New("expected an integer or a list of integers")
+	}
+	return "", errors.New("expected a string")
+}
+
+// Parses the subset of TOML that a configuration file needs: [table] and
+// [table.subtable] headers, and key = value pairs whose values are strings,
+// integers, floats, booleans or single-line arrays of those.  Comments begin
+// with #.
+func parseTOML(text string) (map[string]interface{}, error) {
+	document := map[string]interface{}{}
+	table := document
+	for lineNumber, line := range(strings.Split(text, "\n")) {
+		fail := func(format string, a ...interface{}) error {
+			return fmt.Errorf("line %v: %v", lineNumber + 1, fmt.Sprintf(format, a...))
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.Index(line, "]")
+			if end < 0 || strings.TrimSpace(stripTOMLComment(line[end + 1:])) != "" {
+				return nil, fail("malformed table header")
+			}
+			table = document
+			for _, key := range(strings.Split(line[1:end], ".")) {
+				key, err := parseTOMLKey(key)
+				if err != nil {
+					return nil, fail("%v", err)
+				}
+				next, ok := table[key]
+				if !ok {
+					next = map[string]interface{}{}
+					table[key] = next
+				}
+				if table, ok = next.(map[string]interface{}); !ok {
+					return nil, fail("\"%v\" is already a value, not a table", key)
+				}
+			}
+			continue
+		}
+
+		equals := strings.Index(line, "=")
+		if equals < 0 {
+			return nil, fail("expected key = value")
+		}
+		key, err := parseTOMLKey(line[:equals])
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+		if _, ok := table[key]; ok {
+			return nil, fail("duplicate key \"%v\"", key)
+		}
+		value, rest, err := parseTOMLValue(strings.TrimSpace(line[equals + 1:]))
+		if err != nil {
+			return nil, fail("%v", err)
+		}
+		if strings.TrimSpace(stripTOMLComment(rest)) != "" {
+			return nil, fail("unexpected \"%v\" after the value", strings.TrimSpace(rest))
+		}
+		table[key] = value
+	}
+	return document, nil
+}
+
+// Returns everything before the # that starts a comment.  The text must not
+// contain a string.
+func stripTOMLComment(text string) string {
+	if i := strings.Index(text, "#"); i >= 0 {
+		return text[:i]
+	}
+	return text
+}
+
+// Parses a bare or quoted key.
+func parseTOMLKey(text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "\"") {
+		return strconv.Unquote(text)
+	}
+	if strings.HasPrefix(text, "'") && strings.HasSuffix(text, "'") && len(text) >= 2 {
+		return text[1:len(text) - 1], nil
+	}
+	if text == "" || strings.IndexFunc(text, func(r rune) bool {
+		return !(r == '-' || r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9'))
+	}) >= 0 {
+		return "", fmt.Errorf("invalid key \"%v\"", text)
+	}
+	return text, nil
+}
+
+// Parses the value at the start of the text, returning it along with
+// whatever text follows it.
+func parseTOMLValue(text string) (interface{}, string, error) {
+	switch {
+	case strings.HasPrefix(text, "\""):
+		// Find the closing quote, skipping escaped characters.
+		for i := 1; i < len(text); i++ {
+			switch text[i] {
+			case '\\':
+				i++
+			case '"':
+				s, err := strconv.Unquote(text[:i + 1])
+				return s, text[i + 1:], err
+			}
+		}
+		return nil, "", errors.New("unterminated string")
+
+	case strings.HasPrefix(text, "'"):
+		end := strings.Index(text[1:], "'")
+		if end < 0 {
+			return nil, "", errors.New("unterminated string")
+		}
+		return text[1:end + 1], text[end + 2:], nil
+
+	case strings.HasPrefix(text, "["):
+		values := []interface{}{}
+		rest := strings.TrimSpace(text[1:])
+		for !strings.HasPrefix(rest, "]") {
+			value, after, err := parseTOMLValue(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			values = append(values, value)
+			rest = strings.TrimSpace(after)
+			if strings.HasPrefix(rest, ",") {
+				rest = strings.TrimSpace(rest[1:])
+			} else if !strings.HasPrefix(rest, "]") {
+				return nil, "", errors.New("expected , or ] in array")
+			}
+		}
+		return values, rest[1:], nil
+	}
+
+	// Anything else is a bare word: a boolean or a number.
+	end := strings.IndexAny(text, " \t,]#")
+	if end < 0 {
+		end = len(text)
+	}
+	word, rest := text[:end], text[end:]
+	switch word {
+	case "true":
+		return true, rest, nil
+	case "false":
+		return false, rest, nil
+	}
+	if n, err := strconv.ParseInt(strings.ReplaceAll(word, "_", ""), 0, 64); err == nil {
+		return n, rest, nil
+	}
+	if f, err := strconv.ParseFloat(strings.ReplaceAll(word, "_", ""), 64); err == nil {
+		return f, rest, nil
+	}
+	return nil, "", fmt.Errorf("invalid value \"%v\"", word)
+}
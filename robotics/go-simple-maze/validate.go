@@ -0,0 +1,280 @@
+package main
+import (
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"github.com/akamensky/argparse"
+)
+
+// A single problem found by Validate().  X and Y are the coordinates of the
+// offending cell (not unit), with (0, 0) in the upper-left corner.
+type ValidationError struct {
+	X, Y int
+	Problem string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("(%v, %v): %v", e.X, e.Y, e.Problem)
+}
+
+// All of the problems found by Validate(), in the order they were found.
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	problems := []string{}
+	for _, err := range(e) {
+		problems = append(problems, err.Error())
+	}
+	return strings.Join(problems, "\n")
+}
+
+// Checks the structural invariants that every maze produced by Generate()
+// should satisfy:
+//
+//   1. Every cell contains one of the maze's display runes, and each wall rune
+//      is consistent with its neighbors: horizontal walls continue to the left
+//      or right, vertical walls continue up or down, and fill is surrounded
+//      by walls.
+//   2. The entrance and exit are openings in the border wall.
+//   3. Every open unit can be reached from the entrance; there are no sealed
+//      pockets.
+//   4. If perfect is true, the rooms and doorways form a spanning tree: there
+//      are no open areas where a wall junction should be, and no loops.
+//
+// Returns nil if the maze is valid, or a ValidationErrors otherwise.
+func (m *Maze) Validate(perfect bool) error {
+	errors := ValidationErrors{}
+	report := func(x, y int, format string, a ...interface{}) {
+		errors = append(errors, ValidationError{X: x, Y: y, Problem: fmt.Sprintf(format, a...)})
+	}
+
+	// Returns the cell that best represents the given unit: its upper-left
+	// corner, or the first cell of its interior if the rectangles overlap.
+	unitCell := func(p point) (int, int) {
+		x, y, _, _ := m.unitCoordinatesToRect(p.x, p.y)
+		if m.thickness > 2 {
+			return x + 1, y + 1
+		}
+		return x, y
+	}
+
+	// STEP 1: Runes.
+	isWall := func(x, y int) bool {
+		return m.valid(x, y) && m.cell(m.offset(x, y)) != m.floor
+	}
+	for y := 0; y < m.height; y++ {
+		for x := 0; x < m.width; x++ {
+			switch c := m.cell(m.offset(x, y)); c {
+			case m.floor, m.intersection:
+				break
+			case m.horizontal:
+				if !isWall(x - 1, y) && !isWall(x + 1, y) {
+					report(x, y, "horizontal wall %q has no walls to its left or right", c)
+				}
+			case m.vertical:
+				if !isWall(x, y - 1) && !isWall(x, y + 1) {
+					report(x, y, "vertical wall %q has no walls above or below it", c)
+				}
+			case m.fill:
+				if !isWall(x - 1, y) || !isWall(x + 1, y) || !isWall(x, y - 1) || !isWall(x, y + 1) {
+					report(x, y, "fill %q is not surrounded by walls", c)
+				}
+			default:
+				report(x, y, "unexpected rune %q", c)
+			}
+		}
+	}
+
+	// STEP 2: The entrances and exits.  Each one is either in the border
+	// or a room in the interior (see openings.go.)
+	unitWidth, unitHeight := m.unitDimensions()
+	type opening struct {
+		name string
+		p point
+	}
+	openings := []opening{}
+	for _, r := range(m.entrances) {
+		openings = append(openings, opening{"entrance", m.openingUnit(r)})
+	}
+	for _, r := range(m.exits) {
+		openings = append(openings, opening{"exit", m.openingUnit(r)})
+	}
+	usable := func(p point) bool {
+		return m.unitIsOpen(p.x, p.y) && (m.onBorder(p) || m.isRoom(p))
+	}
+	for _, o := range(openings) {
+		x, y := unitCell(o.p)
+		switch {
+		case !m.onBorder(o.p) && (o.p.x % 2 == 0 || o.p.y % 2 == 0):
+			report(x, y, "the %v is neither on the border of the maze nor in a room", o.name)
+		case !usable(o.p):
+			report(x, y, "the %v is walled off", o.name)
+		}
+	}
+	if len(m.entrances) == 0 || len(m.exits) == 0 {
+		report(0, 0, "the maze has %v entrance(s) and %v exit(s); it needs at least one of each", len(m.entrances), len(m.exits))
+	}
+
+	// STEP 3: Reachability.  We flood the open units (not just the rooms)
+	// so that pockets of any shape are caught.
+	region := make([]int, max(0, unitWidth * unitHeight))
+	flood := func(start point, label int) int {
+		size := 0
+		stack := []point{start}
+		region[start.y * unitWidth + start.x] = label
+		for len(stack) > 0 {
+			current := stack[len(stack) - 1]
+			stack = stack[:len(stack) - 1]
+			size++
+			for _, d := range(directions) {
+				neighbor := point{x: current.x + d.x, y: current.y + d.y}
+				if neighbor.x < 0 || neighbor.y < 0 || neighbor.x >= unitWidth || neighbor.y >= unitHeight {
+					continue
+				}
+				if region[neighbor.y * unitWidth + neighbor.x] == 0 && m.unitIsOpen(neighbor.x, neighbor.y) {
+					region[neighbor.y * unitWidth + neighbor.x] = label
+					stack = append(stack, neighbor)
+				}
+			}
+		}
+		return size
+	}
+	for _, o := range(openings) {
+		if o.name == "entrance" && usable(o.p) && region[o.p.y * unitWidth + o.p.x] == 0 {
+			flood(o.p, 1)
+		}
+	}
+	isExit := func(p point) bool {
+		return slices.Contains(openings, opening{"exit", p})
+	}
+	label := 1
+	for y := 0; y < unitHeight; y++ {
+		for x := 0; x < unitWidth; x++ {
+			if region[y * unitWidth + x] != 0 || !m.unitIsOpen(x, y) {
+				continue
+			}
+			label++
+			size := flood(point{x, y}, label)
+			cellX, cellY := unitCell(point{x, y})
+			if isExit(point{x, y}) {
+				report(cellX, cellY, "the exit cannot be reached from the entrance")
+			} else {
+				report(cellX, cellY, "sealed pocket of %v unit(s) cannot be reached from the entrance", size)
+			}
+		}
+	}
+
+	// STEP 4: Perfection.  Every doorway joins two rooms that were not
+	// already joined, which we track with a union-find structure.
+	if perfect {
+		parent := map[point]point{}
+		var find func(p point) point
+		find = func(p point) point {
+			if q, ok := parent[p]; ok && q != p {
+				root := find(q)
+				parent[p] = root
+				return root
+			}
+			return p
+		}
+		for y := 1; y < unitHeight - 1; y++ {
+			for x := 1; x < unitWidth - 1; x++ {
+				if (x + y) % 2 == 0 || !m.unitIsOpen(x, y) {
+					if x % 2 == 0 && y % 2 == 0 && m.unitIsOpen(x, y) {
+						cellX, cellY := unitCell(point{x, y})
+						report(cellX, cellY, "open area where a wall junction should be")
+					}
+					continue
+				}
+				// This is a doorway between two rooms.
+				var a, b point
+				if x % 2 == 0 {
+					a, b = point{x - 1, y}, point{x + 1, y}
+				} else {
+					a, b = point{x, y - 1}, point{x, y + 1}
+				}
+				if !m.isRoom(a) || !m.isRoom(b) {
+					continue
+				}
+				rootA, rootB := find(a), find(b)
+				if rootA == rootB {
+					cellX, cellY := unitCell(point{x, y})
+					report(cellX, cellY, "this doorway closes a loop, so the maze is not perfect")
+					continue
+				}
+				parent[rootA] = rootB
+			}
+		}
+	}
+
+	if len(errors) == 0 {
+		return nil
+	}
+	return errors
+}
+
+// The entry point for "maze validate".
+func validateMain(args []string) {
+	config, err := LoadConfig(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read the configuration file: %v.\n", err)
+		os.Exit(1)
+	}
+	parser := argparse.NewParser("maze validate", "Checks a maze for sealed pockets, misplaced openings, inconsistent walls and (optionally) loops.  Without --input, the maze is generated from the same arguments that \"maze\" takes.  The exit status is 1 if any problems are found.")
+	arguments := addMazeArguments(parser, config)
+	var input *string = parser.String("I", "input", &argparse.Options{
+		Required: false,
+		Help: "A file containing a maze to validate, as printed by \"maze\", or \"-\" for standard input.  The display runes, box-drawing style and (single) thickness must match the ones the maze was drawn with",
+		Default: "",
+	})
+	var perfect *bool = parser.Flag("p", "perfect", &argparse.Options{
+		Required: false,
+		Help: "Also require the maze to be perfect: exactly one path between any two rooms",
+	})
+
+	err = parser.Parse(args)
+	if err != nil {
+		fmt.Print(parser.Usage(err))
+		return
+	}
+	m, ok := arguments.newMaze(parser)
+	if !ok {
+		return
+	}
+
+	switch *input {
+	case "":
+		if !arguments.generate(&m) {
+			os.Exit(1)
+		}
+	default:
+		var r io.Reader = os.Stdin
+		var f *os.File
+		if *input != "-" {
+			f, err = os.Open(*input)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Could not read \"%v\": %v\n", *input, err)
+				os.Exit(1)
+			}
+			r = f
+		}
+		// The file has to be closed before we get to os.Exit(), which
+		// skips any deferred calls.
+		err = m.Load(r)
+		if f != nil {
+			f.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not load a maze from \"%v\": %v\n", *input, err)
+			os.Exit(1)
+		}
+	}
+
+	if err := m.Validate(*perfect); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Println("OK")
+}
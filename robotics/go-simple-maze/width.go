@@ -0,0 +1,267 @@
+package main
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Wide glyphs.
+//
+// Terminals draw most characters in a single column, but CJK characters and
+// most emoji take up two.  When any of the maze's display runes is wide, the
+// narrow ones are padded out to two columns when the maze is printed (see
+// renderedCells()), so that the rows stay lined up.  Floor and fill runes are
+// padded with a copy of themselves, walls that continue to the right are
+// padded with a horizontal wall, and everything else is padded with a space.
+//
+// A display "rune" may also be a short sequence of code points that the
+// terminal draws as a single glyph, such as an emoji with a skin tone, a
+// flag, or a letter with a combining accent.  Since the cells of the maze
+// hold one rune apiece, each such glyph is represented in the cells by a
+// placeholder from the supplementary private use area, and m.glyphs maps the
+// placeholder back to the real thing.
+
+// The first placeholder rune.
+const firstGlyphPlaceholder = 0xF0000
+
+// The ranges of code points that are two columns wide: the East Asian Wide
+// and Fullwidth characters, and the emoji that are drawn as pictures by
+// default.
+var wideRanges = []struct{first, last rune}{
+	{0x1100, 0x115F}, {0x231A, 0x231B}, {0x2329, 0x232A}, {0x23E9, 0x23EC},
+	{0x23F0, 0x23F0}, {0x23F3, 0x23F3}, {0x25FD, 0x25FE}, {0x2614, 0x2615},
+	{0x2648, 0x2653}, {0x267F, 0x267F}, {0x2693, 0x2693}, {0x26A1, 0x26A1},
+	{0x26AA, 0x26AB}, {0x26BD, 0x26BE}, {0x26C4, 0x26C5}, {0x26CE, 0x26CE},
+	{0x26D4, 0x26D4}, {0x26EA, 0x26EA}, {0x26F2, 0x26F3}, {0x26F5, 0x26F5},
+	{0x26FA, 0x26FA}, {0x26FD, 0x26FD}, {0x2705, 0x2705}, {0x270A, 0x270B},
+	{0x2728, 0x2728}, {0x274C, 0x274C}, {0x274E, 0x274E}, {0x2753, 0x2755},
+	{0x2757, 0x2757}, {0x2795, 0x2797}, {0x27B0, 0x27B0}, {0x27BF, 0x27BF},
+	{0x2B1B, 0x2B1C}, {0x2B50, 0x2B50}, {0x2B55, 0x2B55}, {0x2E80, 0x303E},
+	{0x3041, 0x33FF}, {0x3400, 0x4DBF}, {0x4E00, 0x9FFF}, {0xA000, 0xA4CF},
+	{0xA960, 0xA97F}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF}, {0xFE10, 0xFE19},
+	{0xFE30, 0xFE6F}, {0xFF00, 0xFF60}, {0xFFE0, 0xFFE6}, {0x16FE0, 0x16FE4},
+	{0x17000, 0x18AFF}, {0x1B000, 0x1B2FF}, {0x1F004, 0x1F004}, {0x1F0CF, 0x1F0CF},
+	{0x1F18E, 0x1F18E}, {0x1F191, 0x1F19A}, {0x1F200, 0x1F202}, {0x1F210, 0x1F23B},
+	{0x1F240, 0x1F248}, {0x1F250, 0x1F251}, {0x1F260, 0x1F265}, {0x1F300, 0x1F320},
+	{0x1F32D, 0x1F335}, {0x1F337, 0x1F37C}, {0x1F37E, 0x1F393}, {0x1F3A0, 0x1F3CA},
+	{0x1F3CF, 0x1F3D3}, {0x1F3E0, 0x1F3F0}, {0x1F3F4, 0x1F3F4}, {0x1F3F8, 0x1F43E},
+	{0x1F440, 0x1F440}, {0x1F442, 0x1F4FC}, {0x1F4FF, 0x1F53D}, {0x1F54B, 0x1F54E},
+	{0x1F550, 0x1F567}, {0x1F57A, 0x1F57A}, {0x1F595, 0x1F596}, {0x1F5A4, 0x1F5A4},
+	{0x1F5FB, 0x1F64F}, {0x1F680, 0x1F6C5}, {0x1F6CC, 0x1F6CC}, {0x1F6D0, 0x1F6D2},
+	{0x1F6D5, 0x1F6D7}, {0x1F6DC, 0x1F6DF}, {0x1F6EB, 0x1F6EC}, {0x1F6F4, 0x1F6FC},
+	{0x1F7E0, 0x1F7EB}, {0x1F7F0, 0x1F7F0}, {0x1F90C, 0x1F93A}, {0x1F93C, 0x1F945},
+	{0x1F947, 0x1F9FF}, {0x1FA70, 0x1FAFF}, {0x20000, 0x2FFFD}, {0x30000, 0x3FFFD},
+}
+
+// Returns the number of columns that a terminal uses for the given code
+// point: 0 for combining marks and other invisible characters, 2 for wide
+// characters, and 1 for everything else.  Control characters return -1.
+//
+// Characters of ambiguous width (including the box-drawing characters) are
+// taken to be narrow, as they are outside of East Asian locales.
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x20 || (r >= 0x7F && r < 0xA0):
+		return -1
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf) || (r >= 0x1160 && r <= 0x11FF):
+		return 0
+	}
+	i := sort.Search(len(wideRanges), func(i int) bool { return wideRanges[i].last >= r })
+	if i < len(wideRanges) && wideRanges[i].first <= r {
+		return 2
+	}
+	return 1
+}
+
+func isRegionalIndicator(r rune) bool { return r >= 0x1F1E6 && r <= 0x1F1FF; }
+
+// Returns true if the string is a single glyph: a single code point, or a
+// code point followed by combining marks, variation selectors, emoji
+// modifiers and zero-width-joined emoji, or a pair of regional indicators
+// (a flag.)
+func isSingleGlyph(s string) bool {
+	runes := []rune(s)
+	if len(runes) == 0 || runeWidth(runes[0]) <= 0 {
+		return false
+	}
+	if len(runes) == 2 && isRegionalIndicator(runes[0]) && isRegionalIndicator(runes[1]) {
+		return true
+	}
+	for i := 1; i < len(runes); i++ {
+		switch r := runes[i]; {
+		case runeWidth(r) == 0:
+			continue
+		case r >= 0x1F3FB && r <= 0x1F3FF:
+			// Skin tones.
+			continue
+		case runes[i - 1] == '\u200D' && runeWidth(r) > 0:
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// Returns the number of columns that a glyph (see isSingleGlyph()) takes up.
+func glyphWidth(s string) int {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return 0
+	}
+	width := runeWidth(runes[0])
+	switch {
+	case isRegionalIndicator(runes[0]):
+		return 2
+	case width == 1 && strings.ContainsRune(s, '\uFE0F'):
+		// Variation selector 16 asks for the emoji presentation.
+		return 2
+	}
+	return width
+}
+
+// Returns the display string for a cell rune, taking placeholders into
+// account.
+func (m *Maze) glyph(c rune) string {
+	if g, ok := m.glyphs[c]; ok {
+		return g
+	}
+	return string(c)
+}
+
+// Returns the rune to store in the cells for the given glyph: the glyph
+// itself if it's a single code point, or else a placeholder for it.
+func (m *Maze) glyphRune(glyph string) rune {
+	runes := []rune(glyph)
+	if len(runes) == 1 {
+		return runes[0]
+	}
+	for placeholder, g := range(m.glyphs) {
+		if g == glyph {
+			return placeholder
+		}
+	}
+
+	// The map is replaced rather than modified, since mazes made with
+	// NewMazeOverExisting() share it.
+	glyphs := map[rune]string{}
+	for placeholder, g := range(m.glyphs) {
+		glyphs[placeholder] = g
+	}
+	placeholder := rune(firstGlyphPlaceholder + len(glyphs))
+	glyphs[placeholder] = glyph
+	m.glyphs = glyphs
+	return placeholder
+}
+
+// Returns the display strings for the floor, fill, intersection, horizontal
+// and vertical runes, in that order.
+func (m *Maze) displayGlyphs() []string {
+	return []string{m.glyph(m.floor), m.glyph(m.fill), m.glyph(m.intersection), m.glyph(m.horizontal), m.glyph(m.vertical)}
+}
+
+// Checks that every display glyph is one or two columns wide.  Mixing the
+// two is fine; see renderedCells().
+func (m *Maze) checkGlyphWidths() error {
+	names := []string{"floor", "fill", "intersection", "horizontal", "vertical"}
+	for i, g := range(m.displayGlyphs()) {
+		if width := glyphWidth(g); width < 1 || width > 2 {
+			return fmt.Errorf("the %v glyph, %q, is %v columns wide; glyphs must be one or two columns wide", names[i], g, width)
+		}
+	}
+	return nil
+}
+
+// Returns true if any of the display glyphs is two columns wide, in which
+// case every cell is printed two columns wide.  The wall glyphs don't count
+// when the walls are drawn with a box-drawing style instead.
+func (m *Maze) hasWideGlyphs() bool {
+	glyphs := m.displayGlyphs()
+	if m.boxStyle != nil {
+		glyphs = glyphs[:2]
+	}
+	for _, g := range(glyphs) {
+		if glyphWidth(g) == 2 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the text to print for each cell, with the box-drawing joins
+// applied (see joinedCell()) and narrow glyphs padded out to two columns if
+// any glyph is wide.
+func (m *Maze) renderedCells() []string {
+	result := make([]string, 0, m.cellCount())
+	for y := 0; y < m.height; y++ {
+		result = append(result, m.renderedRow(y)...)
+	}
+	return result
+}
+
+// Returns the text to print for each cell of one row, as renderedCells()
+// does.
+func (m *Maze) renderedRow(y int) []string {
+	result := make([]string, m.width)
+	for x := range(result) {
+		result[x] = m.glyph(m.joinedCell(x, y))
+	}
+	if !m.hasWideGlyphs() {
+		return result
+	}
+
+	horizontalPadding := " "
+	if m.boxStyle != nil {
+		horizontalPadding = string(m.boxStyle[left | right])
+	} else if glyphWidth(m.glyph(m.horizontal)) == 1 {
+		horizontalPadding = m.glyph(m.horizontal)
+	}
+	carriesHorizontal := func(x int) bool {
+		c := m.cell(m.offset(x, y))
+		return c == m.horizontal || c == m.intersection
+	}
+
+	for x := range(result) {
+		if glyphWidth(result[x]) != 1 {
+			continue
+		}
+		switch c := m.cell(m.offset(x, y)); {
+		case c == m.floor || c == m.fill:
+			result[x] += result[x]
+		case carriesHorizontal(x) && x + 1 < m.width && carriesHorizontal(x + 1):
+			result[x] += horizontalPadding
+		default:
+			result[x] += " "
+		}
+	}
+	return result
+}
+
+// Splits a line of text, as printed by Print(), back into cell runes.
+// Glyphs that are several code points long are turned back into their
+// placeholders, and the padding after narrow glyphs is skipped.
+func (m *Maze) parseGlyphs(line string) []rune {
+	wide := m.hasWideGlyphs()
+	result := []rune{}
+	for len(line) > 0 {
+		c, glyph := rune(-1), ""
+		for placeholder, g := range(m.glyphs) {
+			if strings.HasPrefix(line, g) && len(g) > len(glyph) {
+				c, glyph = placeholder, g
+			}
+		}
+		if c < 0 {
+			c = []rune(line)[0]
+			glyph = string(c)
+		}
+		line = line[len(glyph):]
+		result = append(result, c)
+
+		if wide && glyphWidth(glyph) == 1 && len(line) > 0 {
+			_, size := utf8.DecodeRuneInString(line)
+			line = line[size:]
+		}
+	}
+	return result
+}